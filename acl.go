@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/fffonion/rclone-precache/pkg/pathutil"
+	"github.com/gin-gonic/gin"
+)
+
+// errPathNotAllowed is returned when a path lies outside the caller's ACL
+var errPathNotAllowed = errors.New("path not allowed")
+
+// PathACL limits users to API path prefixes. Keys are user names as
+// authenticated, or "key:" and the name for API keys. Users without rules
+// may access every path.
+type PathACL map[string][]string
+
+// parseACL parses a comma separated list of user=prefix rules. A user may
+// be given several rules.
+func parseACL(list string) (PathACL, error) {
+	acl := make(PathACL)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		user, prefix, ok := strings.Cut(entry, "=")
+		if !ok || user == "" || prefix == "" {
+			return nil, fmt.Errorf("invalid ACL rule %q, want user=prefix", entry)
+		}
+		acl[user] = append(acl[user], cleanPath(prefix))
+	}
+	return acl, nil
+}
+
+// allows reports whether user may act on reqPath
+func (acl PathACL) allows(user, reqPath string) bool {
+	prefixes, limited := acl[user]
+	if !limited {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if pathutil.HasPrefix(reqPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// visible reports whether user may see reqPath, which includes the
+// directories leading to the prefixes it is allowed
+func (acl PathACL) visible(user, reqPath string) bool {
+	if acl.allows(user, reqPath) {
+		return true
+	}
+	for _, prefix := range acl[user] {
+		if pathutil.HasPrefix(prefix, reqPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowPath rejects the request with 403 unless its user may act on
+// reqPath
+func (s *Server) allowPath(c *gin.Context, reqPath string) bool {
+	if s.acl.allows(c.GetString("user"), cleanPath(reqPath)) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Access to %s is not allowed", cleanPath(reqPath))})
+	return false
+}
+
+// visiblePath rejects the request with 403 unless its user may see reqPath
+func (s *Server) visiblePath(c *gin.Context, reqPath string) bool {
+	if s.acl.visible(c.GetString("user"), cleanPath(reqPath)) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Access to %s is not allowed", cleanPath(reqPath))})
+	return false
+}
+
+// allowJob rejects the request with 403 unless its user may act on the path
+// of job id. Unknown jobs are left to the handler to report.
+func (s *Server) allowJob(c *gin.Context, id string) bool {
+	job, exists := s.cacheManager.GetJob(id)
+	if !exists {
+		return true
+	}
+	return s.allowPath(c, job.Path)
+}
+
+// visibleJobs returns the jobs whose paths user may see
+func (s *Server) visibleJobs(user string, jobs []*cache.Job) []*cache.Job {
+	if _, limited := s.acl[user]; !limited {
+		return jobs
+	}
+	visible := make([]*cache.Job, 0, len(jobs))
+	for _, job := range jobs {
+		if s.acl.visible(user, job.Path) {
+			visible = append(visible, job)
+		}
+	}
+	return visible
+}
+
+// visibleEvent limits a live event to the jobs user may see, reporting
+// false if it is about a job user may not see at all
+func (s *Server) visibleEvent(user string, event cache.Event) (cache.Event, bool) {
+	if _, limited := s.acl[user]; !limited {
+		return event, true
+	}
+	switch data := event.Data.(type) {
+	case cache.ProgressEvent:
+		data.Jobs = s.visibleJobs(user, data.Jobs)
+		event.Data = data
+	case cache.SpeedSample:
+		jobs := make(map[string]float64, len(data.Jobs))
+		for id, speed := range data.Jobs {
+			if job, exists := s.cacheManager.GetJob(id); exists && s.acl.visible(user, job.Path) {
+				jobs[id] = speed
+			}
+		}
+		data.Jobs = jobs
+		event.Data = data
+	case cache.HistoryRecord:
+		return event, s.acl.visible(user, data.Path)
+	case json.RawMessage:
+		// A job encoded when it changed state
+		var job struct {
+			Path string `json:"path"`
+		}
+		if json.Unmarshal(data, &job) != nil {
+			return event, false
+		}
+		return event, s.acl.visible(user, job.Path)
+	}
+	return event, true
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestPathACL(t *testing.T) {
+	acl, err := parseACL("bob=/tv/kids, bob=movies/family/,key:guest=/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user, path      string
+		allows, visible bool
+	}{
+		{"bob", "/tv/kids", true, true},
+		{"bob", "/tv/kids/show/e01.mkv", true, true},
+		{"bob", "/movies/family/film", true, true},
+		{"bob", "/tv", false, true},
+		{"bob", "/", false, true},
+		{"bob", "/movies", false, true},
+		{"bob", "/tv/kidsplus", false, false},
+		{"bob", "/tv/news", false, false},
+		{"bob", "/music", false, false},
+		{"key:guest", "/anything", true, true},
+		{"alice", "/music", true, true},
+		{"", "/tv/news", true, true},
+	}
+	for _, tt := range tests {
+		if got := acl.allows(tt.user, tt.path); got != tt.allows {
+			t.Errorf("allows(%q, %q) = %v, want %v", tt.user, tt.path, got, tt.allows)
+		}
+		if got := acl.visible(tt.user, tt.path); got != tt.visible {
+			t.Errorf("visible(%q, %q) = %v, want %v", tt.user, tt.path, got, tt.visible)
+		}
+	}
+}
+
+func TestParseACLInvalid(t *testing.T) {
+	for _, list := range []string{"bob", "=/tv", "bob="} {
+		if _, err := parseACL(list); err == nil {
+			t.Errorf("parseACL(%q) succeeded, want an error", list)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/gin-gonic/gin"
+)
+
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// API key scopes, each including the ones before it
+const (
+	ScopeRead     = "read"     // Browse and watch progress
+	ScopePrecache = "precache" // Start, pause and cancel jobs, pin paths
+	ScopeAdmin    = "admin"    // Purge the cache, manage schedules and keys
+)
+
+// scopeRank orders scopes, returning -1 for unknown ones
+func scopeRank(scope string) int {
+	switch scope {
+	case ScopeRead:
+		return 0
+	case ScopePrecache:
+		return 1
+	case ScopeAdmin:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// APIKey grants a scope to requests sending its token in X-Api-Key. Only a
+// hash of the token is kept.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scope     string    `json:"scope"`
+	Prefix    string    `json:"prefix"` // Start of the token, to tell keys apart
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKeyStore keeps API keys in apikeys.json
+type APIKeyStore struct {
+	path string
+	keys map[string]APIKey // By hash
+	mu   sync.RWMutex
+}
+
+// NewAPIKeyStore creates a store backed by apikeys.json inside dir
+func NewAPIKeyStore(dir string) *APIKeyStore {
+	return &APIKeyStore{
+		path: filepath.Join(dir, "apikeys.json"),
+		keys: make(map[string]APIKey),
+	}
+}
+
+// Load reads saved keys, keeping none if the file is missing
+func (ks *APIKeyStore) Load() error {
+	data, err := os.ReadFile(ks.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for _, key := range keys {
+		ks.keys[key.Hash] = key
+	}
+	return nil
+}
+
+// hashToken returns the stored form of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// list returns the keys, oldest first. Caller must hold the lock.
+func (ks *APIKeyStore) list() []APIKey {
+	keys := make([]APIKey, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, k int) bool {
+		return keys[i].CreatedAt.Before(keys[k].CreatedAt)
+	})
+	return keys
+}
+
+// List returns all keys, oldest first
+func (ks *APIKeyStore) List() []APIKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.list()
+}
+
+// Create adds a key and returns it with its token, which is not stored
+func (ks *APIKeyStore) Create(name, scope string) (APIKey, string, error) {
+	if scopeRank(scope) < 0 {
+		return APIKey{}, "", fmt.Errorf("unknown scope %q", scope)
+	}
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return APIKey{}, "", err
+	}
+	token := "rp_" + hex.EncodeToString(b[:])
+	key := APIKey{
+		ID:        cache.NewID(),
+		Name:      name,
+		Scope:     scope,
+		Prefix:    token[:8],
+		Hash:      hashToken(token),
+		CreatedAt: time.Now(),
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[key.Hash] = key
+	return key, token, cache.SaveJSON(ks.path, ks.list())
+}
+
+// Remove revokes a key
+func (ks *APIKeyStore) Remove(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for hash, key := range ks.keys {
+		if key.ID == id {
+			delete(ks.keys, hash)
+			return cache.SaveJSON(ks.path, ks.list())
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+// lookup returns the key a token belongs to
+func (ks *APIKeyStore) lookup(token string) (APIKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, exists := ks.keys[hashToken(token)]
+	return key, exists
+}
+
+// empty reports whether no keys exist
+func (ks *APIKeyStore) empty() bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return len(ks.keys) == 0
+}
+
+// requireScope rejects authenticated requests whose scope is below scope.
+// Requests authenticated without a key, or on a server without
+// authentication, have every scope.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if granted := c.GetString("scope"); granted != "" && scopeRank(granted) < scopeRank(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("API key lacks the %s scope", scope)})
+		}
+	}
+}
+
+// handleListAPIKeys lists the API keys without their tokens
+func (s *Server) handleListAPIKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, s.apiKeys.List())
+}
+
+// handleCreateAPIKey creates a key from a JSON body with name and scope.
+// The token is only returned here. Without other authentication the first
+// key locks the API, so it must be an admin key.
+func (s *Server) handleCreateAPIKey(c *gin.Context) {
+	var req struct {
+		Name  string `json:"name"`
+		Scope string `json:"scope"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	setAuditTarget(c, req.Name)
+	if scopeRank(req.Scope) < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown scope %q", req.Scope)})
+		return
+	}
+	if s.basicAuth == nil && s.oidc == nil && s.apiKeys.empty() && req.Scope != ScopeAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Create an admin key first, it will be required from then on"})
+		return
+	}
+	key, token, err := s.apiKeys.Create(req.Name, req.Scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key, "token": token})
+}
+
+// handleDeleteAPIKey revokes a key
+func (s *Server) handleDeleteAPIKey(c *gin.Context) {
+	if err := s.apiKeys.Remove(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key deleted"})
+}
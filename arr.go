@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ArrClient queries a Radarr or Sonarr server for the folder of a title
+type ArrClient struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewArrClient creates a client for the Radarr or Sonarr server at baseURL
+func NewArrClient(baseURL, apiKey string) *ArrClient {
+	return &ArrClient{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// lookupPath returns the folder of the title matching an external ID, e.g.
+// resource "movie" with param "tmdbId" on Radarr or "series" with "tvdbId"
+// on Sonarr
+func (a *ArrClient) lookupPath(resource, param, id string) (string, error) {
+	query := url.Values{param: {id}}
+	req, err := http.NewRequest(http.MethodGet, a.url+"/api/v3/"+resource+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Api-Key", a.apiKey)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s lookup returned %s", resource, resp.Status)
+	}
+
+	var titles []struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&titles); err != nil {
+		return "", err
+	}
+	if len(titles) == 0 || titles[0].Path == "" {
+		return "", fmt.Errorf("no %s found with %s %s", resource, param, id)
+	}
+	return titles[0].Path, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditActions names the API routes that change state
+var auditActions = map[string]string{
+	"POST /api/precache":        "precache",
+	"POST /api/precache/*path":  "precache",
+	"DELETE /api/jobs":          "clear",
+	"DELETE /api/jobs/:id":      "cancel",
+	"POST /api/jobs/:id/pause":  "pause",
+	"POST /api/jobs/:id/resume": "resume",
+	"POST /api/hooks/radarr":    "hook.radarr",
+	"POST /api/hooks/plex":      "hook.plex",
+	"POST /api/hooks/overseerr": "hook.overseerr",
+	"POST /api/hooks/completed": "hook.completed",
+	"POST /api/hooks/tautulli":  "hook.tautulli",
+	"POST /api/pin/*path":       "pin",
+	"POST /api/unpin/*path":     "unpin",
+	"DELETE /api/cache/*path":   "purge",
+	"DELETE /api/sizecache":     "sizecache.flush",
+	"POST /api/schedules":       "schedule.create",
+	"DELETE /api/schedules/:id": "schedule.delete",
+	"POST /api/keys":            "key.create",
+	"DELETE /api/keys/:id":      "key.delete",
+}
+
+// AuditRecord tells who asked for a change, what it was and how the
+// server answered
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Method    string    `json:"method"`
+	Route     string    `json:"route"`
+	Target    string    `json:"target,omitempty"` // Path, job or other ID acted on
+	Status    int       `json:"status"`
+	User      string    `json:"user,omitempty"`
+	Auth      string    `json:"auth,omitempty"` // How the user authenticated
+	ClientIP  string    `json:"client_ip"`
+	RequestID string    `json:"request_id"`
+}
+
+// AuditQuery selects a page of audit records
+type AuditQuery struct {
+	Since  time.Time
+	Until  time.Time
+	User   string
+	Action string
+	Offset int
+	Limit  int
+}
+
+// AuditLog keeps mutating API requests in an append-only JSON lines file
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewAuditLog creates a log backed by audit.jsonl inside dir
+func NewAuditLog(dir string) *AuditLog {
+	return &AuditLog{path: filepath.Join(dir, "audit.jsonl")}
+}
+
+// Append adds a record to the audit file
+func (al *AuditLog) Append(record AuditRecord) error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(al.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(al.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Query returns matching records, newest first, along with the total
+// number of matches before pagination
+func (al *AuditLog) Query(q AuditQuery) ([]AuditRecord, int, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	f, err := os.Open(al.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []AuditRecord{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var matches []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if !q.Since.IsZero() && record.Time.Before(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && record.Time.After(q.Until) {
+			continue
+		}
+		if q.User != "" && record.User != q.User {
+			continue
+		}
+		if q.Action != "" && record.Action != q.Action {
+			continue
+		}
+		matches = append(matches, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	for i, k := 0, len(matches)-1; i < k; i, k = i+1, k-1 {
+		matches[i], matches[k] = matches[k], matches[i]
+	}
+
+	total := len(matches)
+	if q.Offset >= total {
+		return []AuditRecord{}, total, nil
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matches) {
+		matches = matches[:q.Limit]
+	}
+	return matches, total, nil
+}
+
+// setAuditTarget names what a request acts on when its route has no path
+// or ID, e.g. the paths of a batch precache
+func setAuditTarget(c *gin.Context, target string) {
+	c.Set("audit_target", target)
+}
+
+// audit records every state changing API request once it has been served,
+// including refused ones
+func (s *Server) audit(c *gin.Context) {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodOptions {
+		return
+	}
+	c.Next()
+
+	route := c.FullPath()
+	action, ok := auditActions[c.Request.Method+" "+route]
+	if !ok {
+		return
+	}
+	target := c.GetString("audit_target")
+	if target == "" {
+		if p := c.Param("path"); p != "" {
+			target = cleanPath(p)
+		} else {
+			target = c.Param("id")
+		}
+	}
+	record := AuditRecord{
+		Time:      time.Now(),
+		Action:    action,
+		Method:    c.Request.Method,
+		Route:     route,
+		Target:    target,
+		Status:    c.Writer.Status(),
+		User:      c.GetString("user"),
+		Auth:      c.GetString("auth"),
+		ClientIP:  c.ClientIP(),
+		RequestID: c.GetString("request_id"),
+	}
+	if err := s.auditLog.Append(record); err != nil {
+		slog.Error("Error writing audit log", "action", action, "error", err)
+	}
+}
+
+// handleAudit returns audit records, newest first. Supports limit/offset
+// pagination, since/until date filters and user and action filters.
+func (s *Server) handleAudit(c *gin.Context) {
+	q := AuditQuery{Limit: 50, User: c.Query("user"), Action: c.Query("action")}
+	var err error
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+	if v := c.Query("since"); v != "" {
+		if q.Since, err = parseDate(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since: " + err.Error()})
+			return
+		}
+	}
+	if v := c.Query("until"); v != "" {
+		if q.Until, err = parseDate(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until: " + err.Error()})
+			return
+		}
+	}
+
+	records, total, err := s.auditLog.Query(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"offset":  q.Offset,
+		"limit":   q.Limit,
+		"records": records,
+	})
+}
+
+// auditPaths joins the paths of a batch request for the audit target
+func auditPaths(paths []string) string {
+	cleaned := make([]string, len(paths))
+	for i, p := range paths {
+		cleaned[i] = cleanPath(p)
+	}
+	return strings.Join(cleaned, ",")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authRealm is sent with Basic auth challenges
+const authRealm = "rclone-precache"
+
+// apiKeyUserPrefix starts the user names of API keys, e.g. in ACL rules
+const apiKeyUserPrefix = "key:"
+
+// BasicAuth checks HTTP Basic credentials. Passwords are kept as given,
+// either plain or as htpasswd bcrypt ($2y$) or SHA-1 ({SHA}) hashes.
+type BasicAuth struct {
+	users map[string]string
+}
+
+// NewBasicAuth creates an empty user list
+func NewBasicAuth() *BasicAuth {
+	return &BasicAuth{users: make(map[string]string)}
+}
+
+// AddUser allows a user with a plain password
+func (a *BasicAuth) AddUser(user, password string) {
+	a.users[user] = password
+}
+
+// LoadHtpasswd adds the users of an htpasswd file with bcrypt or SHA-1
+// hashed passwords
+func (a *BasicAuth) LoadHtpasswd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(entry, ":")
+		if !ok || user == "" || !(strings.HasPrefix(hash, "$2") || strings.HasPrefix(hash, "{SHA}")) {
+			return fmt.Errorf("%s:%d: want user:hash with a bcrypt or {SHA} hash", path, line)
+		}
+		a.users[user] = hash
+	}
+	return scanner.Err()
+}
+
+// check reports whether password is right for user
+func (a *BasicAuth) check(user, password string) bool {
+	stored, exists := a.users[user]
+	if !exists {
+		// Spend the same time as a wrong password
+		bcrypt.CompareHashAndPassword([]byte("$2a$10$7EqJtq98hPqEX7fNZaFWoO.HKJNe1/PfWNMG3i0kHfR1M6mNzQH5."), []byte(password))
+		return false
+	}
+	switch {
+	case strings.HasPrefix(stored, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		hashed := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hashed), []byte(stored)) == 1
+	default:
+		return subtle.ConstantTimeCompare([]byte(password), []byte(stored)) == 1
+	}
+}
+
+var (
+	// errInvalidAPIKey is returned for an API key that doesn't exist
+	errInvalidAPIKey = errors.New("invalid API key")
+	// errUnauthorized is returned when credentials are required but none
+	// were valid
+	errUnauthorized = errors.New("unauthorized")
+)
+
+// Credentials identify the caller of a request. All fields are empty when
+// no authentication is configured.
+type Credentials struct {
+	User   string
+	Scope  string // Scope of the API key used, empty for full access
+	Method string // key, bearer, session or basic
+}
+
+// authenticate checks the credentials sent with r, from the X-Api-Key and
+// Authorization headers or the session cookie
+func (s *Server) authenticate(r *http.Request) (Credentials, error) {
+	if token := r.Header.Get("X-Api-Key"); token != "" {
+		key, ok := s.apiKeys.lookup(token)
+		if !ok {
+			return Credentials{}, errInvalidAPIKey
+		}
+		return Credentials{User: apiKeyUserPrefix + key.Name, Scope: key.Scope, Method: "key"}, nil
+	}
+	if s.oidc != nil {
+		if user, ok := s.oidc.authenticate(r); ok {
+			method := "session"
+			if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+				method = "bearer"
+			}
+			return Credentials{User: user, Method: method}, nil
+		}
+	}
+	if s.basicAuth != nil {
+		if user, password, ok := r.BasicAuth(); ok && s.basicAuth.check(user, password) {
+			return Credentials{User: user, Method: "basic"}, nil
+		}
+	}
+	if s.basicAuth != nil || s.oidc != nil || !s.apiKeys.empty() {
+		return Credentials{}, errUnauthorized
+	}
+	return Credentials{}, nil
+}
+
+// requireAuth rejects requests without valid credentials once users, API
+// keys or OIDC are configured. A token in X-Api-Key limits the request to
+// the key's scope. The authenticated user is kept in the "user" context key
+// and how they authenticated in "auth".
+// Browsers asking for the UI are sent to the OIDC login instead.
+func (s *Server) requireAuth(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, "/auth/") || probePaths[c.Request.URL.Path] || s.tokenHook(c.Request.URL.Path) {
+		return
+	}
+	creds, err := s.authenticate(c.Request)
+	if errors.Is(err, errInvalidAPIKey) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		return
+	}
+	if err == nil {
+		if creds.Method != "" {
+			c.Set("user", creds.User)
+			c.Set("auth", creds.Method)
+		}
+		if creds.Scope != "" {
+			c.Set("scope", creds.Scope)
+		}
+		return
+	}
+
+	switch {
+	case s.oidc != nil && c.Request.Method == http.MethodGet && !strings.HasPrefix(c.Request.URL.Path, "/api/"):
+		c.Redirect(http.StatusFound, "/auth/login?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+	case s.basicAuth != nil:
+		c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	case s.oidc != nil || !s.apiKeys.empty():
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	}
+}
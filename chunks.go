@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/gin-gonic/gin"
+)
+
+// chunkMapLimit bounds the resolution a client may request
+const chunkMapLimit = 65536
+
+// handleChunks returns a per-chunk cache map of a file. The chunk_size
+// query parameter (e.g. 4M) overrides the default resolution.
+func (s *Server) handleChunks(c *gin.Context) {
+	reqPath := cleanPath(c.Param("path"))
+	if !s.visiblePath(c, reqPath) {
+		return
+	}
+	sourcePath, cachePath, err := s.paths(reqPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
+		return
+	}
+
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
+		return
+	}
+	if info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chunk maps are only available for files"})
+		return
+	}
+	size := info.Size()
+
+	chunkSize := cache.DefaultChunkSize(size)
+	if v := c.Query("chunk_size"); v != "" {
+		if chunkSize, err = parseSize(v); err != nil || chunkSize <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chunk_size"})
+			return
+		}
+		if size/chunkSize > chunkMapLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "chunk_size too small for this file"})
+			return
+		}
+	}
+
+	ranges, err := s.cacheManager.CachedRanges(cachePath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		ranges = []cache.ByteRange{}
+	case errors.Is(err, cache.ErrSparseUnsupported):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, cache.ChunkMap{
+		Path:        reqPath,
+		Size:        size,
+		ChunkSize:   chunkSize,
+		CachedBytes: cache.RangesLength(ranges),
+		Ranges:      ranges,
+		Chunks:      cache.BuildChunkMap(size, chunkSize, ranges),
+	})
+}
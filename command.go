@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultCommandTimeout stops a hung on-complete command
+const defaultCommandTimeout = 10 * time.Minute
+
+// commandConfig are the settings of a command notifications entry
+type commandConfig struct {
+	Command yaml.Node `yaml:"command"` // A string run by the shell or a list run directly
+	Timeout string    `yaml:"timeout"`
+}
+
+// commandNotifier runs a command for job events, e.g. to start a library
+// scan once a job finishes. Job details are passed in PRECACHE_*
+// environment variables. Commands run one at a time, in order.
+type commandNotifier struct {
+	args    []string
+	timeout time.Duration
+}
+
+func newCommandNotifier(_ *Server, entry *yaml.Node) (cache.Notifier, error) {
+	var config commandConfig
+	if err := entry.Decode(&config); err != nil {
+		return nil, err
+	}
+	n := &commandNotifier{timeout: defaultCommandTimeout}
+	switch config.Command.Kind {
+	case yaml.ScalarNode:
+		if runtime.GOOS == "windows" {
+			n.args = []string{"cmd", "/C", config.Command.Value}
+		} else {
+			n.args = []string{"/bin/sh", "-c", config.Command.Value}
+		}
+	case yaml.SequenceNode:
+		if err := config.Command.Decode(&n.args); err != nil {
+			return nil, err
+		}
+	}
+	if len(n.args) == 0 || n.args[len(n.args)-1] == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	if config.Timeout != "" {
+		var err error
+		if n.timeout, err = parseDuration(config.Timeout); err != nil {
+			return nil, fmt.Errorf("timeout: %w", err)
+		}
+	}
+	return n, nil
+}
+
+func (n *commandNotifier) JobQueued(job cache.JobSummary) {
+	n.run(notifyQueued, job)
+}
+
+func (n *commandNotifier) JobStarted(job cache.JobSummary) {
+	n.run(notifyStarted, job)
+}
+
+func (n *commandNotifier) JobCompleted(job cache.JobSummary) {
+	n.run(notifyCompleted, job)
+}
+
+func (n *commandNotifier) JobFailed(job cache.JobSummary) {
+	n.run(notifyFailed, job)
+}
+
+// commandEnv returns the environment variables describing a job event
+func commandEnv(event string, job cache.JobSummary) []string {
+	env := []string{
+		"PRECACHE_EVENT=" + event,
+		"PRECACHE_STATUS=" + string(job.State),
+		"PRECACHE_JOB_ID=" + job.ID,
+		"PRECACHE_PATH=" + job.Path,
+		"PRECACHE_USER=" + job.User,
+		"PRECACHE_BYTES=" + strconv.FormatInt(job.BytesRead, 10),
+		"PRECACHE_CACHED_BYTES=" + strconv.FormatInt(job.CachedSize, 10),
+		"PRECACHE_TOTAL_BYTES=" + strconv.FormatInt(job.TotalSize, 10),
+		"PRECACHE_DURATION=" + strconv.FormatFloat(job.Duration, 'f', 3, 64),
+		"PRECACHE_ERRORS=" + strconv.Itoa(job.ErrorCount),
+	}
+	if len(job.Errors) > 0 {
+		env = append(env, "PRECACHE_ERROR="+job.Errors[0].Path+": "+job.Errors[0].Error)
+	}
+	return env
+}
+
+// run starts the command for an event and waits for it to exit
+func (n *commandNotifier) run(event string, job cache.JobSummary) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, n.args[0], n.args[1:]...)
+	cmd.Env = append(os.Environ(), commandEnv(event, job)...)
+	setChildAttributes(cmd)
+
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	log := slog.With("command", n.args[0], "event", event, "job", job.ID)
+	if err != nil {
+		log.Warn("Job event command failed", "error", err, "output", strings.TrimSpace(string(output)))
+		return
+	}
+	log.Debug("Ran job event command", "duration", time.Since(start), "output", strings.TrimSpace(string(output)))
+}
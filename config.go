@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the YAML file given with -config. Keys other than mounts,
+// schedules and notifications are flag names without the dash, e.g.
+// "max-jobs: 4". Lists are accepted wherever a flag takes a comma separated
+// list, and acl may map users to lists of prefixes.
+type Config struct {
+	Mounts        []*Mount
+	Schedules     []Schedule
+	Notifications []notificationConfig
+
+	flags map[string]string
+}
+
+// mountConfig is a mounts entry of the config file
+type mountConfig struct {
+	Name     string                 `yaml:"name"`
+	Mount    string                 `yaml:"mount"`
+	Cache    string                 `yaml:"cache"`
+	Defaults map[string]interface{} `yaml:"defaults"` // Same names and formats as the precache query parameters
+}
+
+// scheduleConfig is a schedules entry of the config file
+type scheduleConfig struct {
+	ID          string                 `yaml:"id"`
+	Path        string                 `yaml:"path"`
+	Interval    string                 `yaml:"interval"`
+	Incremental bool                   `yaml:"incremental"`
+	Options     map[string]interface{} `yaml:"options"`
+}
+
+// loadConfig reads and checks a config file
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	config := &Config{flags: make(map[string]string)}
+	for key, node := range raw {
+		switch {
+		case key == "mounts" && node.Kind == yaml.SequenceNode:
+			err = config.decodeMounts(&node)
+		case key == "schedules":
+			err = config.decodeSchedules(&node)
+		case key == "notifications":
+			err = config.decodeNotifications(&node)
+		case key == "acl" && node.Kind == yaml.MappingNode:
+			config.flags[key], err = aclValue(&node)
+		default:
+			config.flags[key], err = flagValue(&node)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+	return config, nil
+}
+
+// flagValue turns a scalar or a list of scalars into a flag value
+func flagValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("line %d: want a list of values", item.Line)
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("line %d: want a value or a list", node.Line)
+	}
+}
+
+// aclValue turns a mapping of users to prefixes into the -acl format
+func aclValue(node *yaml.Node) (string, error) {
+	var acl map[string][]string
+	if err := node.Decode(&acl); err != nil {
+		return "", err
+	}
+	var rules []string
+	for user, prefixes := range acl {
+		for _, prefix := range prefixes {
+			rules = append(rules, user+"="+prefix)
+		}
+	}
+	return strings.Join(rules, ","), nil
+}
+
+func (c *Config) decodeMounts(node *yaml.Node) error {
+	var entries []mountConfig
+	if err := node.Decode(&entries); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Name == "" || strings.Contains(entry.Name, "/") || entry.Mount == "" || entry.Cache == "" {
+			return fmt.Errorf("mount %q needs a name without slashes, a mount and a cache", entry.Name)
+		}
+		if seen[entry.Name] {
+			return fmt.Errorf("duplicate mount %q", entry.Name)
+		}
+		seen[entry.Name] = true
+		defaults, err := optionValues(entry.Defaults)
+		if err != nil {
+			return fmt.Errorf("mount %q: %w", entry.Name, err)
+		}
+		c.Mounts = append(c.Mounts, &Mount{Name: entry.Name, MountPath: entry.Mount, CachePath: entry.Cache, Defaults: defaults})
+	}
+	return nil
+}
+
+func (c *Config) decodeSchedules(node *yaml.Node) error {
+	var entries []scheduleConfig
+	if err := node.Decode(&entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		c.Schedules = append(c.Schedules, Schedule{
+			ID:          entry.ID,
+			Path:        entry.Path,
+			Interval:    entry.Interval,
+			Incremental: entry.Incremental,
+			Options:     entry.Options,
+		})
+	}
+	return nil
+}
+
+// envPrefix starts the environment variable of every flag, e.g.
+// RCLONE_PRECACHE_MAX_JOBS for -max-jobs
+const envPrefix = "RCLONE_PRECACHE_"
+
+// envName returns the environment variable setting a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets flags from their environment variables, except those given
+// on the command line
+func applyEnv(fs *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || explicit[f.Name] || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %w", envName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// apply sets the flags named in the config file, except those given on the
+// command line or in the environment
+func (c *Config) apply(fs *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for name, value := range c.flags {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("option %q: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The UI reads the CSRF token from csrfCookie and echoes it in csrfHeader
+// on state-changing requests. A foreign page can't read the cookie, so it
+// can't forge the header.
+const (
+	csrfCookie = "rp_csrf"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfProtect issues the CSRF cookie and checks the token on browser
+// requests that change state and were authenticated by credentials the
+// browser sends on its own, a session cookie or Basic auth. API keys,
+// bearer tokens and clients that aren't browsers are not affected.
+func (s *Server) csrfProtect(c *gin.Context) {
+	token, err := c.Cookie(csrfCookie)
+	if err != nil || token == "" {
+		token = randomString()
+		c.SetSameSite(http.SameSiteStrictMode)
+		c.SetCookie(csrfCookie, token, 0, "/", "", c.Request.TLS != nil, false)
+		token = ""
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	if s.tokenHook(c.Request.URL.Path) {
+		return
+	}
+	if method := c.GetString("auth"); method != "session" && method != "basic" {
+		return
+	}
+	// Browsers send Origin or Sec-Fetch-Site with every POST and DELETE
+	if c.GetHeader("Origin") == "" && c.GetHeader("Sec-Fetch-Site") == "" {
+		return
+	}
+	sent := c.GetHeader(csrfHeader)
+	if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing or invalid CSRF token"})
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerPprof serves Go runtime profiles under /debug/pprof for admins,
+// e.g. go tool pprof http://host:8000/debug/pprof/profile?seconds=30
+func (s *Server) registerPprof(router *gin.Engine) {
+	debug := router.Group("/debug/pprof", requireScope(ScopeAdmin))
+	debug.GET("/", gin.WrapF(pprof.Index))
+	debug.GET("/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/profile", gin.WrapF(pprof.Profile))
+	debug.GET("/symbol", gin.WrapF(pprof.Symbol))
+	debug.POST("/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/trace", gin.WrapF(pprof.Trace))
+	// Named profiles such as heap, goroutine and allocs
+	debug.GET("/:profile", func(c *gin.Context) {
+		pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+	})
+}
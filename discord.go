@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// discordBarWidth is the number of squares in the progress bar
+	discordBarWidth = 10
+	// discordRetries is how often a rate limited message is sent again
+	discordRetries = 3
+)
+
+// Embed colors by job event
+const (
+	discordBlue  = 0x3498db
+	discordGreen = 0x2ecc71
+	discordRed   = 0xe74c3c
+	discordGrey  = 0x95a5a6
+)
+
+// discordConfig are the settings of a discord notifications entry
+type discordConfig struct {
+	URL       string `yaml:"url"` // Webhook URL from the channel's integrations
+	Username  string `yaml:"username"`
+	AvatarURL string `yaml:"avatar-url"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields"`
+	Timestamp   time.Time      `json:"timestamp"`
+}
+
+type discordMessage struct {
+	Username  string         `json:"username,omitempty"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	Embeds    []discordEmbed `json:"embeds"`
+}
+
+// discordNotifier posts job events as embeds to a Discord webhook
+type discordNotifier struct {
+	config discordConfig
+	client *http.Client
+}
+
+func newDiscordNotifier(_ *Server, entry *yaml.Node) (cache.Notifier, error) {
+	var config discordConfig
+	if err := entry.Decode(&config); err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(config.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", config.URL)
+	}
+	return &discordNotifier{config: config, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (d *discordNotifier) JobQueued(job cache.JobSummary) {
+	d.post(discordEmbed{
+		Title:       "Queued",
+		Description: job.Path,
+		Color:       discordGrey,
+		Timestamp:   job.CreatedAt,
+	})
+}
+
+func (d *discordNotifier) JobStarted(job cache.JobSummary) {
+	d.post(discordEmbed{
+		Title:       "Caching",
+		Description: job.Path,
+		Color:       discordBlue,
+		Fields: []discordField{
+			{Name: "Total size", Value: formatSize(job.TotalSize), Inline: true},
+			{Name: "Progress", Value: progressBar(job.CachedSize, job.TotalSize)},
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+func (d *discordNotifier) JobCompleted(job cache.JobSummary) {
+	d.post(d.finished("Cached", discordGreen, job))
+}
+
+func (d *discordNotifier) JobFailed(job cache.JobSummary) {
+	if job.State == cache.StateCancelled {
+		d.post(d.finished("Cancelled", discordGrey, job))
+	} else {
+		d.post(d.finished("Failed", discordRed, job))
+	}
+}
+
+// finished builds the embed of a finished job
+func (d *discordNotifier) finished(title string, color int, job cache.JobSummary) discordEmbed {
+	embed := discordEmbed{
+		Title:       title,
+		Description: job.Path,
+		Color:       color,
+		Fields: []discordField{
+			{Name: "Total size", Value: formatSize(job.TotalSize), Inline: true},
+			{Name: "Read", Value: formatSize(job.BytesRead), Inline: true},
+			{Name: "Duration", Value: formatETA(&job.Duration), Inline: true},
+			{Name: "Progress", Value: progressBar(job.CachedSize, job.TotalSize)},
+		},
+		Timestamp: time.Now(),
+	}
+	if job.FinishedAt != nil {
+		embed.Timestamp = *job.FinishedAt
+	}
+	if job.ErrorCount > 0 {
+		value := strconv.Itoa(job.ErrorCount)
+		if len(job.Errors) > 0 {
+			value += "\n" + job.Errors[0].Path + ": " + job.Errors[0].Error
+		}
+		embed.Fields = append(embed.Fields, discordField{Name: "Errors", Value: value})
+	}
+	return embed
+}
+
+// progressBar draws done out of total as a row of emoji squares
+func progressBar(done, total int64) string {
+	fraction := 1.0
+	if total > 0 {
+		fraction = min(float64(done)/float64(total), 1)
+	}
+	filled := int(fraction * discordBarWidth)
+	return strings.Repeat("🟩", filled) + strings.Repeat("⬜", discordBarWidth-filled) +
+		fmt.Sprintf(" %.0f%%", fraction*100)
+}
+
+// post sends an embed, waiting out rate limits
+func (d *discordNotifier) post(embed discordEmbed) {
+	body, err := json.Marshal(discordMessage{
+		Username:  d.config.Username,
+		AvatarURL: d.config.AvatarURL,
+		Embeds:    []discordEmbed{embed},
+	})
+	if err != nil {
+		slog.Error("Error encoding Discord message", "error", err)
+		return
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := d.client.Post(d.config.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			// Keep the webhook token in the URL out of logs
+			if urlErr, ok := err.(*url.Error); ok {
+				err = urlErr.Err
+			}
+			slog.Warn("Error posting to Discord", "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < discordRetries {
+			delay, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+			if err != nil {
+				delay = 1
+			}
+			time.Sleep(min(time.Duration(delay*float64(time.Second)), time.Minute))
+			continue
+		}
+		if resp.StatusCode/100 != 2 {
+			slog.Warn("Error posting to Discord", "status", resp.Status)
+		}
+		return
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+)
+
+// episodePattern matches S01E02 and 1x02 style episode numbers
+var episodePattern = regexp.MustCompile(`(?i)(?:s(\d{1,2})[ ._-]?e(\d{1,3})|\b(\d{1,2})x(\d{2,3})\b)`)
+
+// episode identifies an episode within a series
+type episode struct {
+	season, number int
+}
+
+// before reports whether e comes earlier in the series than other
+func (e episode) before(other episode) bool {
+	if e.season != other.season {
+		return e.season < other.season
+	}
+	return e.number < other.number
+}
+
+// parseEpisode extracts the season and episode numbers from a file name
+func parseEpisode(name string) (episode, bool) {
+	m := episodePattern.FindStringSubmatch(name)
+	if m == nil {
+		return episode{}, false
+	}
+	season, number := m[1], m[2]
+	if season == "" {
+		season, number = m[3], m[4]
+	}
+	s, _ := strconv.Atoi(season)
+	n, _ := strconv.Atoi(number)
+	return episode{season: s, number: n}, true
+}
+
+// nextEpisodes returns up to count video files following reqPath in its
+// directory. Files named with SxxEyy or NxNN numbers are ordered by
+// episode, others by name.
+func (s *Server) nextEpisodes(reqPath string, count int) []string {
+	dir, name := path.Split(reqPath)
+	sourceDir, _, err := s.paths(dir)
+	if err != nil {
+		return nil
+	}
+	entries, err := os.ReadDir(sourceDir)
+	if err != nil {
+		return nil
+	}
+
+	var videos []string
+	for _, entry := range entries {
+		if !entry.IsDir() && cache.VideoExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			videos = append(videos, entry.Name())
+		}
+	}
+
+	// follows reports whether a video comes after the current file
+	current, numbered := parseEpisode(name)
+	follows := func(video string) bool { return video > name }
+	if numbered {
+		sort.SliceStable(videos, func(i, k int) bool {
+			a, aok := parseEpisode(videos[i])
+			b, bok := parseEpisode(videos[k])
+			if aok && bok && a != b {
+				return a.before(b)
+			}
+			return videos[i] < videos[k]
+		})
+		follows = func(video string) bool {
+			ep, ok := parseEpisode(video)
+			return ok && current.before(ep)
+		}
+	} else {
+		sort.Strings(videos)
+	}
+
+	var next []string
+	for _, video := range videos {
+		if follows(video) && len(next) < count {
+			next = append(next, path.Join(dir, video))
+		}
+	}
+	return next
+}
+
+// prefetchNext queues the episodes following a precached or played file at
+// low priority, if next episode prefetching is enabled
+func (s *Server) prefetchNext(reqPath string, origin cache.JobOrigin) {
+	if s.prefetchCount <= 0 || !cache.VideoExtensions[strings.ToLower(path.Ext(reqPath))] {
+		return
+	}
+	opts := s.defaultOptions(reqPath)
+	opts.Priority = cache.PriorityLow
+	for _, next := range s.nextEpisodes(reqPath, s.prefetchCount) {
+		if _, err := s.queuePath(next, origin, opts); err != nil {
+			slog.Error("Error prefetching next episode", "path", next, "error", err)
+		}
+	}
+}
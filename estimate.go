@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleEstimate reports what precaching a path would read, accepting the
+// same options as handlePrecache
+func (s *Server) handleEstimate(c *gin.Context) {
+	reqPath := cleanPath(c.Param("path"))
+	if !s.allowPath(c, reqPath) {
+		return
+	}
+	sourcePath, cachePath, err := s.paths(reqPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts, err := s.parseJobOptions(reqPath, c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	estimate, err := s.cacheManager.Estimate(reqPath, sourcePath, cachePath, opts)
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, estimate)
+}
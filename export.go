@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/gin-gonic/gin"
+)
+
+// historyColumns are the columns of a CSV history export
+var historyColumns = []string{
+	"id", "path", "state", "user", "client_ip", "started_at", "finished_at",
+	"duration_seconds", "total_bytes", "average_speed", "error_count",
+}
+
+// handleHistoryExport downloads every finished job matching the history
+// filters, newest first, as CSV or JSON (?format=csv|json) for capacity
+// planning and bandwidth accounting
+func (s *Server) handleHistoryExport(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, want csv or json"})
+		return
+	}
+	q, ok := s.historyFilter(c)
+	if !ok {
+		return
+	}
+	records, _, err := s.cacheManager.QueryHistory(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("precache-history-%s.%s", time.Now().Format("20060102"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "json" {
+		c.JSON(http.StatusOK, records)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Write(historyColumns)
+	for _, record := range records {
+		w.Write(historyRow(record))
+	}
+	w.Flush()
+}
+
+// historyRow formats a history record as CSV fields in historyColumns
+// order
+func historyRow(record cache.HistoryRecord) []string {
+	return []string{
+		record.ID,
+		record.Path,
+		string(record.State),
+		record.User,
+		record.ClientIP,
+		record.StartedAt.Format(time.RFC3339),
+		record.FinishedAt.Format(time.RFC3339),
+		strconv.FormatFloat(record.Duration, 'f', 3, 64),
+		strconv.FormatInt(record.TotalBytes, 10),
+		strconv.FormatFloat(record.AverageSpeed, 'f', 0, 64),
+		strconv.Itoa(len(record.Errors)),
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+//go:generate protoc -I proto --go_out=proto --go_opt=paths=source_relative --go-grpc_out=proto --go-grpc_opt=paths=source_relative proto/precache.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/api"
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	pb "github.com/fffonion/rclone-precache/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcScopes is the API key scope each gRPC method needs
+var grpcScopes = map[string]string{
+	pb.Precache_Browse_FullMethodName:         ScopeRead,
+	pb.Precache_StreamProgress_FullMethodName: ScopeRead,
+	pb.Precache_StartJob_FullMethodName:       ScopePrecache,
+	pb.Precache_CancelJob_FullMethodName:      ScopePrecache,
+}
+
+// grpcActions names the gRPC methods that change state, for the audit log
+var grpcActions = map[string]string{
+	pb.Precache_StartJob_FullMethodName:  "precache",
+	pb.Precache_CancelJob_FullMethodName: "cancel",
+}
+
+// grpcCallKey keeps the caller of a gRPC request in its context
+type grpcCallKey struct{}
+
+// grpcCall is who sent a gRPC request
+type grpcCall struct {
+	Credentials
+	ClientIP  string
+	RequestID string
+}
+
+// callOf returns the caller stored by the interceptors
+func callOf(ctx context.Context) grpcCall {
+	call, _ := ctx.Value(grpcCallKey{}).(grpcCall)
+	return call
+}
+
+// origin returns who started a job through gRPC
+func (call grpcCall) origin() cache.JobOrigin {
+	return cache.JobOrigin{ClientIP: call.ClientIP, User: call.User, RequestID: call.RequestID}
+}
+
+// grpcService implements the Precache gRPC service on top of the server
+// the REST API uses
+type grpcService struct {
+	pb.UnimplementedPrecacheServer
+	s *Server
+}
+
+// NewGRPCServer creates a gRPC server for the Precache service, with
+// reflection for tools like grpcurl. Requests are authenticated like REST
+// requests, from the x-api-key or authorization metadata.
+func (s *Server) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(s.grpcUnary),
+		grpc.ChainStreamInterceptor(s.grpcStream),
+	)
+	srv := grpc.NewServer(opts...)
+	pb.RegisterPrecacheServer(srv, &grpcService{s: s})
+	reflection.Register(srv)
+	return srv
+}
+
+// grpcAuthorize authenticates a call to method and checks its scope,
+// returning a context carrying the caller
+func (s *Server) grpcAuthorize(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := &http.Request{Header: http.Header{}}
+	for key, values := range md {
+		r.Header[http.CanonicalHeaderKey(key)] = values
+	}
+
+	call := grpcCall{RequestID: r.Header.Get(requestIDHeader)}
+	if !validRequestID(call.RequestID) {
+		call.RequestID = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, call.RequestID))
+	if p, ok := peer.FromContext(ctx); ok {
+		call.ClientIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(call.ClientIP); err == nil {
+			call.ClientIP = host
+		}
+	}
+
+	creds, err := s.authenticate(r)
+	if err != nil {
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+	call.Credentials = creds
+	if scope := grpcScopes[method]; creds.Scope != "" && scopeRank(creds.Scope) < scopeRank(scope) {
+		return ctx, status.Errorf(codes.PermissionDenied, "API key lacks the %s scope", scope)
+	}
+	return context.WithValue(ctx, grpcCallKey{}, call), nil
+}
+
+// grpcUnary authenticates unary calls and audits those changing state
+func (s *Server) grpcUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx, err := s.grpcAuthorize(ctx, info.FullMethod)
+	var resp interface{}
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	s.logGRPC(ctx, info.FullMethod, start, err)
+
+	if action, ok := grpcActions[info.FullMethod]; ok {
+		call := callOf(ctx)
+		var target string
+		switch req := req.(type) {
+		case *pb.StartJobRequest:
+			target = cleanPath(req.GetPath())
+		case *pb.CancelJobRequest:
+			target = req.GetId()
+		}
+		record := AuditRecord{
+			Time:      time.Now(),
+			Action:    action,
+			Method:    "GRPC",
+			Route:     info.FullMethod,
+			Target:    target,
+			Status:    grpcHTTPStatus(status.Code(err)),
+			User:      call.User,
+			Auth:      call.Method,
+			ClientIP:  call.ClientIP,
+			RequestID: call.RequestID,
+		}
+		if err := s.auditLog.Append(record); err != nil {
+			slog.Error("Error writing audit log", "action", action, "error", err)
+		}
+	}
+	return resp, err
+}
+
+// grpcContextStream replaces the context of a server stream
+type grpcContextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s grpcContextStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcStream authenticates streaming calls
+func (s *Server) grpcStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := s.grpcAuthorize(stream.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, grpcContextStream{ServerStream: stream, ctx: ctx})
+	}
+	s.logGRPC(ctx, info.FullMethod, start, err)
+	return err
+}
+
+// logGRPC logs a finished call like logRequests logs HTTP requests
+func (s *Server) logGRPC(ctx context.Context, method string, start time.Time, err error) {
+	call := callOf(ctx)
+	code := status.Code(err)
+	level := slog.LevelDebug
+	switch code {
+	case codes.OK, codes.Canceled:
+	case codes.Internal, codes.Unknown, codes.Unavailable:
+		level = slog.LevelError
+	default:
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "gRPC request",
+		"method", method,
+		"code", code.String(),
+		"duration", time.Since(start),
+		"client_ip", call.ClientIP,
+		"user", call.User,
+		"request_id", call.RequestID,
+	)
+}
+
+// grpcError converts a cache manager error to a gRPC status
+func grpcError(err error) error {
+	var code codes.Code
+	switch {
+	case errors.Is(err, cache.ErrJobExists):
+		code = codes.AlreadyExists
+	case errors.Is(err, cache.ErrLowDiskSpace):
+		code = codes.ResourceExhausted
+	default:
+		switch jobErrorStatus(err) {
+		case http.StatusNotFound:
+			code = codes.NotFound
+		case http.StatusForbidden:
+			code = codes.PermissionDenied
+		case http.StatusConflict:
+			code = codes.FailedPrecondition
+		case http.StatusServiceUnavailable:
+			code = codes.Unavailable
+		default:
+			code = codes.Internal
+		}
+	}
+	return status.Error(code, err.Error())
+}
+
+// grpcHTTPStatus returns the HTTP status a REST request failing like a
+// gRPC call would have, for the audit log
+func grpcHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.FailedPrecondition:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusInsufficientStorage
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Browse lists a directory like GET /api/browse
+func (g *grpcService) Browse(ctx context.Context, req *pb.BrowseRequest) (*pb.BrowseResponse, error) {
+	s := g.s
+	user := callOf(ctx).User
+	reqPath := cleanPath(req.GetPath())
+	if !s.acl.visible(user, reqPath) {
+		return nil, status.Errorf(codes.PermissionDenied, "Access to %s is not allowed", reqPath)
+	}
+
+	var fileInfos []api.FileInfo
+	if s.named() && reqPath == "/" {
+		fileInfos = s.listMounts(user)
+	} else {
+		fullPath, cacheBase, err := s.paths(reqPath)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "Path not found")
+		}
+		if req.GetRefresh() {
+			if s.rc == nil {
+				return nil, status.Error(codes.FailedPrecondition, "rclone remote control is not configured")
+			}
+			// A stale listing is still better than none
+			if err := s.rc.refresh(reqPath); err != nil {
+				slog.Error("Error refreshing directory", "path", reqPath, "error", err)
+			}
+		}
+		if fileInfos, err = s.listDirectory(user, reqPath, fullPath, cacheBase, nil); err != nil {
+			return nil, status.Error(codes.NotFound, "Path not found")
+		}
+	}
+
+	resp := &pb.BrowseResponse{Entries: make([]*pb.FileInfo, len(fileInfos))}
+	for i, info := range fileInfos {
+		resp.Entries[i] = &pb.FileInfo{
+			Name:       info.Name,
+			Path:       info.Path,
+			IsDir:      info.IsDir,
+			Size:       info.Size,
+			Modified:   timestamppb.New(time.Unix(int64(info.CreatedTime), 0)),
+			CachedSize: info.CachedSize,
+		}
+	}
+	return resp, nil
+}
+
+// StartJob starts caching a path like POST /api/precache
+func (g *grpcService) StartJob(ctx context.Context, req *pb.StartJobRequest) (*pb.Job, error) {
+	s := g.s
+	call := callOf(ctx)
+	reqPath := cleanPath(req.GetPath())
+	if !s.acl.allows(call.User, reqPath) {
+		return nil, status.Errorf(codes.PermissionDenied, "Access to %s is not allowed", reqPath)
+	}
+	sourcePath, cachePath, err := s.paths(reqPath)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	query := url.Values{}
+	for name, value := range req.GetOptions() {
+		query.Set(name, value)
+	}
+	for _, glob := range req.GetInclude() {
+		query.Add("include", glob)
+	}
+	for _, glob := range req.GetExclude() {
+		query.Add("exclude", glob)
+	}
+	opts, err := s.parseJobOptions(reqPath, query)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	job, err := s.cacheManager.StartJob(reqPath, sourcePath, cachePath, call.origin(), opts)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	s.prefetchNext(reqPath, call.origin())
+	return jobMessage(job), nil
+}
+
+// CancelJob cancels a job, or clears a finished one, like
+// DELETE /api/jobs/:id
+func (g *grpcService) CancelJob(ctx context.Context, req *pb.CancelJobRequest) (*pb.CancelJobResponse, error) {
+	// REST refuses this in rejectWrites, but the manager only refuses new jobs
+	if g.s.cacheManager.ReadOnly() {
+		return nil, status.Error(codes.PermissionDenied, cache.ErrReadOnly.Error())
+	}
+	if job, exists := g.s.cacheManager.GetJob(req.GetId()); exists && !g.s.acl.allows(callOf(ctx).User, job.Path) {
+		return nil, status.Errorf(codes.PermissionDenied, "Access to %s is not allowed", job.Path)
+	}
+	err := g.s.cacheManager.CancelJob(req.GetId())
+	if errors.Is(err, cache.ErrJobFinished) {
+		err = g.s.cacheManager.ClearJob(req.GetId())
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.CancelJobResponse{}, nil
+}
+
+// StreamProgress sends the progress events the SSE and WebSocket streams
+// carry, limited to one job if asked
+func (g *grpcService) StreamProgress(req *pb.StreamProgressRequest, stream pb.Precache_StreamProgressServer) error {
+	cm := g.s.cacheManager
+	user := callOf(stream.Context()).User
+	jobID := req.GetJobId()
+	if jobID != "" {
+		job, exists := cm.GetJob(jobID)
+		if !exists {
+			return status.Error(codes.NotFound, cache.ErrJobNotFound.Error())
+		}
+		if !g.s.acl.visible(user, job.Path) {
+			return status.Errorf(codes.PermissionDenied, "Access to %s is not allowed", job.Path)
+		}
+	}
+
+	events := cm.Events().Subscribe()
+	defer cm.Events().Unsubscribe(events)
+
+	// send reports whether the stream should go on
+	send := func(update cache.ProgressEvent) (bool, error) {
+		msg := &pb.ProgressUpdate{Global: globalMessage(update.Global)}
+		found := false
+		for _, job := range g.s.visibleJobs(user, update.Jobs) {
+			if jobID != "" && job.ID != jobID {
+				continue
+			}
+			jobMsg := jobMessage(job)
+			msg.Jobs = append(msg.Jobs, jobMsg)
+			found = !jobMsg.Progress.IsComplete
+		}
+		if err := stream.Send(msg); err != nil {
+			return false, err
+		}
+		return jobID == "" || found, nil
+	}
+
+	more, err := send(cache.ProgressEvent{Global: cm.GetGlobalProgress(), Jobs: cm.ListJobs()})
+	for more && err == nil {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if update, isProgress := event.Data.(cache.ProgressEvent); isProgress {
+				more, err = send(update)
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+	return err
+}
+
+// jobMessage converts a job with its current progress
+func jobMessage(job *cache.Job) *pb.Job {
+	progress := job.Progress()
+	started, finished := job.Timestamps()
+
+	msg := &pb.Job{
+		Id:        job.ID,
+		Path:      job.Path,
+		User:      job.User,
+		RequestId: job.RequestID,
+		CreatedAt: timestamppb.New(job.CreatedAt),
+		Progress: &pb.JobProgress{
+			State:          string(progress.State),
+			CurrentSpeed:   progress.CurrentSpeed,
+			TotalBytesRead: progress.TotalBytesRead,
+			TotalSize:      progress.TotalSize,
+			CachedSize:     progress.CachedSize,
+			BytesRemaining: progress.BytesRemaining,
+			EtaSeconds:     progress.ETASeconds,
+			IsComplete:     progress.IsComplete,
+			ErrorCount:     int32(progress.ErrorCount),
+		},
+	}
+	if started != nil {
+		msg.StartedAt = timestamppb.New(*started)
+	}
+	if finished != nil {
+		msg.FinishedAt = timestamppb.New(*finished)
+	}
+	return msg
+}
+
+// globalMessage converts the progress across all jobs
+func globalMessage(global cache.GlobalProgress) *pb.GlobalProgress {
+	return &pb.GlobalProgress{
+		TotalSpeed:     global.TotalSpeed,
+		OverallPercent: global.OverallPercent,
+		ActiveJobs:     int32(global.ActiveJobs),
+		QueuedJobs:     int32(global.QueuedJobs),
+		PausedJobs:     int32(global.PausedJobs),
+		BytesRemaining: global.BytesRemaining,
+		EtaSeconds:     global.ETASeconds,
+	}
+}
+
+// listenGRPC serves the gRPC API on addr, with the HTTP server's TLS
+// settings, until the server fails or ctx is done. Calls in flight then
+// get up to shutdownTimeout to finish.
+func (s *Server) listenGRPC(ctx context.Context, addr string, opts TLSOptions, shutdownTimeout time.Duration) error {
+	var serverOpts []grpc.ServerOption
+	if opts.enabled() {
+		config, err := tlsConfig(opts)
+		if err != nil {
+			return fmt.Errorf("gRPC TLS: %w", err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := s.NewGRPCServer(serverOpts...)
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("Serving gRPC", "addr", ln.Addr().String(), "tls", opts.enabled())
+		errs <- srv.Serve(ln)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	slog.Info("Shutting down gRPC server", "timeout", shutdownTimeout)
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		// Progress streams stay open until closed
+		srv.Stop()
+	}
+	return nil
+}
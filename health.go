@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MountStatus is the outcome of the latest mount health check
+type MountStatus struct {
+	Name      string    `json:"name,omitempty"`
+	Path      string    `json:"path"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	Latency   float64   `json:"latency_seconds"`
+	CheckedAt time.Time `json:"checked_at"`
+	Since     time.Time `json:"since"` // When the mount last became healthy or unhealthy
+}
+
+// HealthMonitor periodically lists the mount root to notice a disconnected
+// or hanging mount
+type HealthMonitor struct {
+	path       string
+	mountPoint bool // Also require path to be a mount point, for managed mounts
+	interval   time.Duration
+	timeout    time.Duration
+	onChange   func(healthy bool)
+
+	mu       sync.RWMutex
+	status   MountStatus
+	checking bool // Set while a check is still waiting on the mount
+}
+
+// NewHealthMonitor creates a monitor for a mount. onChange is called
+// whenever the mount becomes healthy or unhealthy.
+func NewHealthMonitor(m *Mount, mountPoint bool, interval, timeout time.Duration, onChange func(bool)) *HealthMonitor {
+	now := time.Now()
+	return &HealthMonitor{
+		path:       m.MountPath,
+		mountPoint: mountPoint,
+		interval:   interval,
+		timeout:    timeout,
+		onChange:   onChange,
+		status:     MountStatus{Name: m.Name, Path: m.MountPath, Healthy: true, CheckedAt: now, Since: now},
+	}
+}
+
+// Start runs checks in the background
+func (h *HealthMonitor) Start() {
+	go func() {
+		for {
+			h.check()
+			time.Sleep(h.interval)
+		}
+	}()
+}
+
+// Status returns the latest check result
+func (h *HealthMonitor) Status() MountStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status
+}
+
+// probe stats and lists the mount root
+func (h *HealthMonitor) probe() error {
+	if h.mountPoint {
+		mounted, err := isMountPoint(h.path)
+		if err != nil {
+			return err
+		}
+		if !mounted {
+			return fmt.Errorf("%s is not mounted", h.path)
+		}
+	}
+	f, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// check probes the mount once. A probe that doesn't return within the
+// timeout counts as a hung mount; no further probes are started until it
+// returns.
+func (h *HealthMonitor) check() {
+	h.mu.Lock()
+	if h.checking {
+		h.mu.Unlock()
+		h.record(fmt.Errorf("mount still not responding"), 0)
+		return
+	}
+	h.checking = true
+	h.mu.Unlock()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- h.probe()
+		h.mu.Lock()
+		h.checking = false
+		h.mu.Unlock()
+	}()
+
+	select {
+	case err := <-done:
+		h.record(err, time.Since(start))
+	case <-time.After(h.timeout):
+		h.record(fmt.Errorf("mount not responding after %s", h.timeout), h.timeout)
+	}
+}
+
+// record stores a check result and reports transitions
+func (h *HealthMonitor) record(err error, latency time.Duration) {
+	now := time.Now()
+	h.mu.Lock()
+	wasHealthy := h.status.Healthy
+	h.status.Healthy = err == nil
+	h.status.Error = ""
+	if err != nil {
+		h.status.Error = err.Error()
+	}
+	h.status.Latency = latency.Seconds()
+	h.status.CheckedAt = now
+	changed := wasHealthy != h.status.Healthy
+	if changed {
+		h.status.Since = now
+	}
+	healthy := h.status.Healthy
+	h.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if healthy {
+		slog.Info("Mount is healthy again", "mount", h.path)
+	} else {
+		slog.Error("Mount is unhealthy", "mount", h.path, "error", err)
+	}
+	if h.onChange != nil {
+		h.onChange(healthy)
+	}
+}
+
+// StartHealthMonitor checks every mount each interval, pausing its jobs
+// while it is down. A managed mount is remounted when it fails.
+func (s *Server) StartHealthMonitor(interval, timeout time.Duration) {
+	for _, m := range s.mounts {
+		m := m
+		managed := s.mount != nil && s.mount.mountPath == m.MountPath
+		m.health = NewHealthMonitor(m, managed, interval, timeout, func(healthy bool) {
+			s.cacheManager.MountChanged(m.MountPath, healthy)
+			if !healthy && managed {
+				go s.recoverMount()
+			}
+		})
+		m.health.Start()
+	}
+}
+
+// recoverMount remounts the managed mount until it is ready again. Paused
+// jobs resume from their checkpoints once the health check passes.
+func (s *Server) recoverMount() {
+	delay := mountRestartDelay
+	for {
+		err := s.mount.Remount()
+		if err == nil {
+			slog.Info("Remounted", "mount", s.mount.mountPath)
+			return
+		}
+		slog.Error("Error remounting", "mount", s.mount.mountPath, "error", err)
+		time.Sleep(delay)
+		delay = min(delay*2, mountMaxRestartDelay)
+	}
+}
+
+// handleHealth reports the status of every mount, with 503 while any of
+// them is unhealthy
+func (s *Server) handleHealth(c *gin.Context) {
+	healthy := true
+	statuses := make([]MountStatus, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		if m.health == nil {
+			continue
+		}
+		status := m.health.Status()
+		healthy = healthy && status.Healthy
+		statuses = append(statuses, status)
+	}
+	code := http.StatusOK
+	if !healthy {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"healthy": healthy, "mounts": statuses})
+}
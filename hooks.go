@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultOptions returns the options of jobs started by integrations,
+// which are the defaults of the mount serving reqPath
+func (s *Server) defaultOptions(reqPath string) cache.JobOptions {
+	opts, err := s.parseJobOptions(reqPath, nil)
+	if err != nil {
+		return cache.JobOptions{Threads: s.threadCount}
+	}
+	return opts
+}
+
+// queuePath starts a job for a mount-relative path, as used by
+// integrations. An unfinished job for the path is returned instead of
+// starting another. Paths outside the ACL of the origin's user are refused.
+func (s *Server) queuePath(reqPath string, origin cache.JobOrigin, opts cache.JobOptions) (*cache.Job, error) {
+	if !s.acl.allows(origin.User, cleanPath(reqPath)) {
+		return nil, fmt.Errorf("%w: %s", errPathNotAllowed, cleanPath(reqPath))
+	}
+	sourcePath, cachePath, err := s.paths(reqPath)
+	if err != nil {
+		return nil, err
+	}
+	job, err := s.cacheManager.StartJob(reqPath, sourcePath, cachePath, origin, opts)
+	if errors.Is(err, cache.ErrJobExists) {
+		if existing, exists := s.cacheManager.FindJob(reqPath); exists {
+			return existing, nil
+		}
+	}
+	return job, err
+}
+
+// cancelPath cancels the unfinished job for a path that no longer exists,
+// unless the path lies outside the ACL of user
+func (s *Server) cancelPath(reqPath, user string) {
+	if !s.acl.allows(user, cleanPath(reqPath)) {
+		return
+	}
+	if job, exists := s.cacheManager.FindJob(reqPath); exists {
+		s.cacheManager.CancelJob(job.ID)
+	}
+}
+
+// CompletedHook is posted by download clients when a download finishes
+type CompletedHook struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// tokenHooks are the hook routes of senders that can't log in, such as
+// download clients and Plex. Once a hook token is configured they skip the
+// regular authentication and take the token instead.
+var tokenHooks = map[string]bool{"/api/hooks/completed": true, "/api/hooks/plex": true}
+
+// tokenHook reports whether a request path is authenticated by the hook token
+func (s *Server) tokenHook(path string) bool {
+	return s.hookToken != "" && tokenHooks[path]
+}
+
+// requireHookToken checks the hook token, sent as a bearer token or token
+// query parameter, on the routes in tokenHooks. Without a hook token the
+// regular authentication applies.
+func (s *Server) requireHookToken(c *gin.Context) {
+	if !s.tokenHook(c.Request.URL.Path) {
+		return
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		token = c.Query("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.hookToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid hook token"})
+	}
+}
+
+// handleCompletedHook precaches a finished download, e.g. from a torrent
+// client's run on completion script. The path may be mount-relative or a
+// client path covered by the path map. Callers authenticate with the hook
+// token, see requireHookToken.
+func (s *Server) handleCompletedHook(c *gin.Context) {
+	if s.hookToken == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Hook token is not configured"})
+		return
+	}
+
+	var hook CompletedHook
+	if err := c.ShouldBindJSON(&hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reqPath, ok := s.externalPath(hook.Path)
+	if !ok {
+		reqPath = cleanPath(hook.Path)
+	}
+	if !s.exists(reqPath) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Path not found: %s", reqPath)})
+		return
+	}
+
+	job, err := s.queuePath(reqPath, originOf(c), s.defaultOptions(reqPath))
+	if err != nil {
+		c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Caching %s", reqPath),
+		"job_id":  job.ID,
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+)
+
+// JellyfinPoller watches a Jellyfin or Emby server's playback sessions and
+// precaches what each user will play next
+type JellyfinPoller struct {
+	server   *Server
+	url      string
+	apiKey   string
+	ahead    int // Items to precache after the one playing
+	client   *http.Client
+	lastItem map[string]string // Now playing item ID by session ID
+}
+
+// jellyfinItem is the part of a Jellyfin item used for precaching
+type jellyfinItem struct {
+	ID   string `json:"Id"`
+	Type string `json:"Type"`
+	Path string `json:"Path"`
+}
+
+// jellyfinSession is the part of a Jellyfin session used for precaching
+type jellyfinSession struct {
+	ID              string        `json:"Id"`
+	UserName        string        `json:"UserName"`
+	NowPlayingItem  *jellyfinItem `json:"NowPlayingItem"`
+	NowPlayingQueue []struct {
+		ID string `json:"Id"`
+	} `json:"NowPlayingQueue"`
+}
+
+// StartJellyfinPoller polls the sessions of the Jellyfin or Emby server at
+// baseURL every interval
+func (s *Server) StartJellyfinPoller(baseURL, apiKey string, interval time.Duration, ahead int) {
+	p := &JellyfinPoller{
+		server:   s,
+		url:      strings.TrimSuffix(baseURL, "/"),
+		apiKey:   apiKey,
+		ahead:    ahead,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		lastItem: make(map[string]string),
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := p.poll(); err != nil {
+				slog.Error("Error polling Jellyfin sessions", "error", err)
+			}
+		}
+	}()
+	slog.Info("Polling Jellyfin sessions", "url", p.url, "interval", interval)
+}
+
+// get decodes a JSON response from the Jellyfin API
+func (p *JellyfinPoller) get(path string, query url.Values, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, p.url+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Emby-Token", p.apiKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// poll precaches ahead of every session whose playing item changed
+func (p *JellyfinPoller) poll() error {
+	var sessions []jellyfinSession
+	if err := p.get("/Sessions", url.Values{}, &sessions); err != nil {
+		return err
+	}
+
+	active := make(map[string]string, len(sessions))
+	for _, session := range sessions {
+		item := session.NowPlayingItem
+		if item == nil {
+			continue
+		}
+		active[session.ID] = item.ID
+		if p.lastItem[session.ID] == item.ID {
+			continue
+		}
+		paths, err := p.nextPaths(session)
+		if err != nil {
+			slog.Error("Error finding next Jellyfin items", "jellyfin_user", session.UserName, "error", err)
+			continue
+		}
+		for _, reqPath := range paths {
+			if _, err := p.server.queuePath(reqPath, cache.JobOrigin{}, p.server.defaultOptions(reqPath)); err != nil {
+				slog.Error("Error queueing Jellyfin item", "path", reqPath, "jellyfin_user", session.UserName, "error", err)
+			}
+		}
+	}
+	p.lastItem = active
+	return nil
+}
+
+// nextPaths returns the mount-relative paths to precache for a session:
+// the items queued after the playing one, or else the following episodes
+// in its season directory
+func (p *JellyfinPoller) nextPaths(session jellyfinSession) ([]string, error) {
+	item := session.NowPlayingItem
+
+	var queued []string
+	for i, entry := range session.NowPlayingQueue {
+		if entry.ID == item.ID {
+			for _, next := range session.NowPlayingQueue[i+1:] {
+				if len(queued) < p.ahead {
+					queued = append(queued, next.ID)
+				}
+			}
+			break
+		}
+	}
+	if len(queued) > 0 {
+		var result struct {
+			Items []jellyfinItem `json:"Items"`
+		}
+		query := url.Values{"Ids": {strings.Join(queued, ",")}, "Fields": {"Path"}}
+		if err := p.get("/Items", query, &result); err != nil {
+			return nil, err
+		}
+		var paths []string
+		for _, next := range result.Items {
+			if reqPath, ok := p.server.externalPath(next.Path); ok && next.Path != "" {
+				paths = append(paths, reqPath)
+			}
+		}
+		return paths, nil
+	}
+
+	if item.Type != "Episode" || item.Path == "" {
+		return nil, nil
+	}
+	reqPath, ok := p.server.externalPath(item.Path)
+	if !ok {
+		return nil, fmt.Errorf("no path mapping for %s", item.Path)
+	}
+	return p.server.nextEpisodes(reqPath, p.ahead), nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newLogger creates a logger writing records at level or above, either as
+// key=value lines ("console") or one JSON object per line ("json")
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, want debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "console":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, want console or json", format)
+	}
+}
+
+// setupLogging makes the standard and slog loggers write through a new
+// logger. Gin's route listing and warnings are only shown at debug level.
+func setupLogging(format, level string) error {
+	logger, err := newLogger(os.Stderr, format, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	return nil
+}
+
+// logRequests logs each API request once it has been served, at debug
+// level for successful ones
+func logRequests(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	status := c.Writer.Status()
+	level := slog.LevelDebug
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case status >= 400:
+		level = slog.LevelWarn
+	}
+	args := []any{
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"status", status,
+		"duration", time.Since(start),
+		"client_ip", c.ClientIP(),
+		"request_id", c.GetString("request_id"),
+	}
+	if user := c.GetString("user"); user != "" {
+		args = append(args, "user", user)
+	}
+	if errs := c.Errors.String(); errs != "" {
+		args = append(args, "error", errs)
+	}
+	slog.Log(c.Request.Context(), level, "Request", args...)
+}
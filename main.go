@@ -1,25 +1,349 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/fffonion/rclone-precache/pkg/sizer"
+	"github.com/fffonion/rclone-precache/pkg/tracing"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "top" {
+		if err := runTop(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	ConfigPath := flag.String("config", "", "YAML config file with flag names as keys, plus mounts and schedules lists; flags and "+envPrefix+"* environment variables take precedence")
+	Listen := flag.String("listen", ":8000", "Address and port to serve on, e.g. 127.0.0.1:8000 to accept local connections only")
+	GRPCListen := flag.String("grpc-listen", "", "Address and port to serve the gRPC API on, e.g. :9000, with the same TLS settings and credentials as HTTP; empty to disable")
+	TLSCert := flag.String("tls-cert", "", "TLS certificate file to serve HTTPS with, together with -tls-key")
+	TLSKey := flag.String("tls-key", "", "TLS private key file")
+	ACMEDomains := flag.String("acme-domain", "", "Comma separated domains to get Let's Encrypt certificates for; -listen must be reachable on port 443")
+	ACMECache := flag.String("acme-cache", "", "Directory keeping Let's Encrypt certificates (default <state-dir>/acme)")
+	ACMEEmail := flag.String("acme-email", "", "Contact address for Let's Encrypt expiry notices")
+	TLSClientCA := flag.String("tls-client-ca", "", "PEM file of CAs client certificates must be signed by; other connections are rejected")
+	User := flag.String("user", "", "User required to access the API and UI with HTTP Basic auth, together with -password")
+	Password := flag.String("password", "", "Password of -user")
+	Htpasswd := flag.String("htpasswd", "", "htpasswd file of users allowed in, with bcrypt or SHA-1 passwords")
+	OIDCIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL to log users in with, e.g. https://auth.example.com")
+	OIDCClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID, also the audience required of bearer tokens")
+	OIDCClientSecret := flag.String("oidc-client-secret", "", "OpenID Connect client secret")
+	OIDCRedirectURL := flag.String("oidc-redirect-url", "", "Callback URL registered with the provider (default <request origin>/auth/callback)")
+	OIDCScopes := flag.String("oidc-scopes", "openid,profile,email", "Comma separated scopes requested at login")
+	ACL := flag.String("acl", "", "Comma separated user=prefix rules limiting users, or key:<name> for API keys, to API paths; users without rules may access all")
+	ReadOnly := flag.Bool("read-only", false, "Refuse every request that starts jobs or changes state, e.g. for a status page")
 	MountPath := flag.String("mount", "", "Source path")
 	CachePath := flag.String("cache", "", "Cache path")
-	ChunkSize := flag.Int("chunk", 1, "Chunk size in MB for caching")
+	MountList := flag.String("mounts", "", "Comma separated name=mount:cache pairs served under /<name>, instead of -mount and -cache; job defaults may follow as a query, e.g. ?threads=8&chunk=16M&bwlimit=40M")
+	ChunkSize := flag.Int("chunk", 0, "Chunk size in MB for caching, 0 to tune it per file from measured throughput")
 	ThreadCount := flag.Int("thread", 2, "Threads count caching")
+	MaxJobs := flag.Int("max-jobs", 2, "Maximum number of concurrent precache jobs, 0 for unlimited")
+	Retries := flag.Int("retries", 3, "Retries per file for failed reads")
+	RetryDelay := flag.Duration("retry-delay", time.Second, "Delay before the first retry, doubled for each further retry")
+	SidecarExts := flag.String("sidecar-ext", strings.Join(cache.DefaultSidecarExtensions, ","), "Comma separated sidecar extensions cached with a video, empty to disable")
+	SkipExts := flag.String("skip-ext", strings.Join(cache.DefaultSkipExtensions, ","), "Comma separated extensions directory jobs never cache")
+	AllowExts := flag.String("allow-ext", "", "Comma separated extensions directory jobs only cache, empty to allow all")
+	Watch := flag.String("watch", "", "Comma separated mount-relative directories to watch for new files to precache")
+	WatchSettle := flag.Duration("watch-settle", 30*time.Second, "Time a new file or directory must stay unchanged before it is precached")
+	PathMapList := flag.String("path-map", "", "Comma separated from=to prefixes mapping paths reported by integrations to mount-relative paths")
+	PlexURL := flag.String("plex-url", "", "Plex server URL for the Plex webhook, e.g. http://localhost:32400")
+	PlexToken := flag.String("plex-token", "", "Plex API token")
+	PlexAhead := flag.Int("plex-ahead", 2, "Episodes to precache after the one playing in Plex")
+	JellyfinURL := flag.String("jellyfin-url", "", "Jellyfin or Emby server URL to poll for playback sessions")
+	JellyfinKey := flag.String("jellyfin-key", "", "Jellyfin or Emby API key")
+	JellyfinInterval := flag.Duration("jellyfin-interval", 30*time.Second, "How often to poll Jellyfin sessions")
+	JellyfinAhead := flag.Int("jellyfin-ahead", 2, "Items to precache after the one playing in Jellyfin")
+	RadarrURL := flag.String("radarr-url", "", "Radarr URL used to find movie folders for Overseerr requests")
+	RadarrKey := flag.String("radarr-key", "", "Radarr API key")
+	SonarrURL := flag.String("sonarr-url", "", "Sonarr URL used to find series folders for Overseerr requests")
+	SonarrKey := flag.String("sonarr-key", "", "Sonarr API key")
+	HookToken := flag.String("hook-token", "", "Token authenticating /api/hooks/completed, which is disabled without one, and /api/hooks/plex instead of the regular login")
+	PrefetchNext := flag.Int("prefetch-next", 0, "Episodes to precache at low priority after a precached or played episode, 0 to disable")
+	BwLimit := flag.String("bwlimit", "0", "Bandwidth limit across all jobs in bytes per second, e.g. 50M, 0 for unlimited")
+	MinFree := flag.String("min-free", "0", "Free space to keep in the cache directory, e.g. 20G; jobs are refused or paused below it, 0 to disable")
+	Quota := flag.String("quota", "0", "Maximum size of the cache directory, e.g. 500G; least recently used files are evicted to stay under it, 0 to disable")
+	RcURL := flag.String("rc-url", "", "rclone remote control URL of the mount, e.g. http://localhost:5572, for VFS stats and to forget purged paths")
+	RcUser := flag.String("rc-user", "", "rclone remote control user")
+	RcPass := flag.String("rc-pass", "", "rclone remote control password")
+	RcFs := flag.String("rc-fs", "", "rclone remote of the mount, needed when rclone serves several")
+	VFSRemote := flag.String("vfs-remote", "", "rclone remote of the mount, e.g. gdrive:media; -cache is then rclone's --cache-dir and cached bytes come from its vfsMeta")
+	RcloneMountRemote := flag.String("rclone-mount", "", "Remote to mount at -mount with a supervised rclone process, e.g. gdrive:media, empty to use an existing mount")
+	RcloneBin := flag.String("rclone-bin", "rclone", "rclone binary used by -rclone-mount")
+	RcloneArgs := flag.String("rclone-args", "", "Extra space separated rclone mount flags, e.g. \"--vfs-cache-mode full --cache-dir /var/cache/rclone\"")
+	MountTimeout := flag.Duration("mount-timeout", time.Minute, "How long to wait for the managed mount before giving up")
+	HealthInterval := flag.Duration("health-interval", 30*time.Second, "How often to check that the mount responds")
+	HealthTimeout := flag.Duration("health-timeout", 10*time.Second, "Time after which a mount check counts as hung")
+	SizeCacheTTL := flag.Duration("size-cache-ttl", sizer.DefaultMaxAge, "How long measured file and directory sizes are reused before being measured again")
+	SizeCacheMax := flag.Int("size-cache-max", 100000, "Maximum file and directory sizes kept in memory, least recently used dropped first, 0 for unlimited")
+	StateDir := flag.String("state-dir", "", "Directory for saved job state (default <cache>/.rclone-precache)")
+	LogFormat := flag.String("log-format", "console", "Log format, console for key=value lines or json for log shippers such as Loki or ELK")
+	OTLPEndpoint := flag.String("otlp-endpoint", "", "OpenTelemetry collector OTLP/HTTP URL to export request and job traces to, e.g. http://localhost:4318")
+	OTLPService := flag.String("otlp-service", "rclone-precache", "Service name reported with traces")
+	StatsdAddr := flag.String("statsd", "", "StatsD host:port to push job and throughput gauges to over UDP")
+	InfluxURL := flag.String("influx-url", "", "InfluxDB write URL to push metrics to in line protocol, e.g. http://localhost:8086/api/v2/write?org=home&bucket=precache")
+	InfluxToken := flag.String("influx-token", "", "InfluxDB API token")
+	MetricsInterval := flag.Duration("metrics-interval", 10*time.Second, "How often to push metrics to StatsD or InfluxDB")
+	MetricsPrefix := flag.String("metrics-prefix", "rclone_precache", "Name prefix of pushed metrics")
+	Debug := flag.Bool("debug", false, "Serve pprof CPU and heap profiles under /debug/pprof, requiring an admin when authentication is enabled")
+	Version := flag.Bool("version", false, "Print the version and exit")
+	ShutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "How long to wait for requests in flight on SIGTERM or SIGINT")
+	LogLevel := flag.String("log-level", "info", "Lowest level logged: debug, info, warn or error; requests are logged at debug unless they fail")
 	flag.Parse()
+	if *Version {
+		fmt.Println(buildInfo())
+		return
+	}
+	if err := applyEnv(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+
+	var config *Config
+	if *ConfigPath != "" {
+		var err error
+		if config, err = loadConfig(*ConfigPath); err != nil {
+			log.Fatal(err)
+		}
+		if err := config.apply(flag.CommandLine); err != nil {
+			log.Fatalf("%s: %v", *ConfigPath, err)
+		}
+	}
 
-	if *MountPath == "" || *CachePath == "" {
+	if err := setupLogging(*LogFormat, *LogLevel); err != nil {
+		log.Fatal(err)
+	}
+	// Take the socket systemd passed before starting rclone, which would
+	// otherwise inherit it
+	systemdLn, err := systemdListener()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mounts, err := parseMounts(*MountList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(mounts) == 0 && config != nil && *MountPath == "" {
+		mounts = config.Mounts
+	}
+	if len(mounts) > 0 {
+		if *MountPath != "" || *CachePath != "" {
+			log.Fatal("-mounts can't be combined with -mount and -cache")
+		}
+		if *RcloneMountRemote != "" || *RcURL != "" || *VFSRemote != "" {
+			log.Fatal("-rclone-mount, -rc-url and -vfs-remote need a single -mount and -cache")
+		}
+	}
+	if (*TLSCert == "") != (*TLSKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
+	if (*User == "") != (*Password == "") {
+		log.Fatal("-user and -password must be given together")
+	}
+	if *TLSCert != "" && *ACMEDomains != "" {
+		log.Fatal("-acme-domain can't be combined with -tls-cert")
+	}
+	if len(mounts) == 0 && (*MountPath == "" || *CachePath == "") {
 		log.Fatal("Mount and cache paths are required")
 	}
+	if *SizeCacheTTL <= 0 || *SizeCacheMax < 0 {
+		log.Fatal("-size-cache-ttl must be positive and -size-cache-max at least 0")
+	}
+	pathMap, err := parsePathMap(*PathMapList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	acl, err := parseACL(*ACL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bwlimit, err := parseSize(*BwLimit)
+	if err != nil {
+		log.Fatalf("Invalid bwlimit: %v", err)
+	}
+	minFree, err := parseSize(*MinFree)
+	if err != nil {
+		log.Fatalf("Invalid min-free: %v", err)
+	}
+	quota, err := parseSize(*Quota)
+	if err != nil {
+		log.Fatalf("Invalid quota: %v", err)
+	}
+
+	extensions := cache.ExtensionRules{
+		Sidecars: cache.ParseExtensions(*SidecarExts),
+		Skip:     cache.ParseExtensions(*SkipExts),
+		Allow:    cache.ParseExtensions(*AllowExts),
+	}
+
+	var mount *RcloneMount
+	if *RcloneMountRemote != "" {
+		mount = NewRcloneMount(*RcloneBin, *RcloneMountRemote, *MountPath, strings.Fields(*RcloneArgs), *MountTimeout)
+		mount.Start()
+		if err := mount.WaitReady(*MountTimeout); err != nil {
+			log.Fatal(err)
+		}
+		slog.Info("Mounted", "remote", *RcloneMountRemote, "mount", *MountPath)
+	}
+
+	cachePath := *CachePath
+	var vfsCache *cache.VFSCache
+	if *VFSRemote != "" {
+		if vfsCache, err = cache.NewVFSCache(*CachePath, *VFSRemote); err != nil {
+			log.Fatal(err)
+		}
+		cachePath = vfsCache.DataRoot()
+		// Keep saved state out of the directories rclone manages
+		if *StateDir == "" {
+			*StateDir = filepath.Join(*CachePath, ".rclone-precache")
+		}
+	}
+
+	if len(mounts) == 0 {
+		mounts = []*Mount{{MountPath: *MountPath, CachePath: cachePath}}
+	}
 
 	// Create server instance
-	server := NewServer(*MountPath, *CachePath, *ChunkSize*1024*1024, *ThreadCount)
+	server := NewServer(mounts, *ChunkSize*1024*1024, *ThreadCount, *MaxJobs,
+		cache.RetryPolicy{MaxRetries: *Retries, BaseDelay: *RetryDelay}, extensions, *StateDir)
+	if err := server.checkMountDefaults(); err != nil {
+		log.Fatal(err)
+	}
+	if config != nil {
+		if err := server.ConfigureSchedules(config.Schedules); err != nil {
+			log.Fatalf("%s: %v", *ConfigPath, err)
+		}
+		if err := server.EnableNotifications(config.Notifications); err != nil {
+			log.Fatalf("%s: %v", *ConfigPath, err)
+		}
+	}
+	if vfsCache != nil {
+		server.UseVFSCache(vfsCache)
+	}
+	server.sizer.SetLimits(*SizeCacheTTL, *SizeCacheMax)
+	server.cacheManager.SetBwLimit(bwlimit)
+	server.cacheManager.SetMinFree(minFree)
+	if quota > 0 {
+		server.EnableQuota(quota)
+	}
+	server.pathMap = pathMap
+	server.hookToken = *HookToken
+	server.cacheManager.SetReadOnly(*ReadOnly)
+	server.acl = acl
+	server.debug = *Debug
+	if *StatsdAddr != "" || *InfluxURL != "" {
+		pusher, err := NewMetricsPusher(server.cacheManager, *MetricsPrefix, *StatsdAddr, *InfluxURL, *InfluxToken)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pusher.Start(*MetricsInterval)
+	}
+	if *OTLPEndpoint != "" {
+		server.UseTracer(tracing.New(*OTLPEndpoint, *OTLPService))
+	}
+	if *OIDCIssuer != "" {
+		if *OIDCClientID == "" {
+			log.Fatal("-oidc-issuer needs -oidc-client-id")
+		}
+		if server.oidc, err = NewOIDCProvider(*OIDCIssuer, *OIDCClientID, *OIDCClientSecret, *OIDCRedirectURL, parseList(*OIDCScopes)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *User != "" || *Htpasswd != "" {
+		server.basicAuth = NewBasicAuth()
+		if *User != "" {
+			server.basicAuth.AddUser(*User, *Password)
+		}
+		if *Htpasswd != "" {
+			if err := server.basicAuth.LoadHtpasswd(*Htpasswd); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	server.prefetchCount = *PrefetchNext
+	if *RcURL != "" {
+		server.rc = NewRcClient(*RcURL, *RcUser, *RcPass, *RcFs)
+		server.cacheManager.StartVFSStats(server.rc.VFSStats)
+	}
+	if *PlexURL != "" {
+		server.plex = NewPlexClient(*PlexURL, *PlexToken, *PlexAhead)
+	}
+	if *RadarrURL != "" {
+		server.radarr = NewArrClient(*RadarrURL, *RadarrKey)
+	}
+	if *SonarrURL != "" {
+		server.sonarr = NewArrClient(*SonarrURL, *SonarrKey)
+	}
+	// Restored jobs start right away, so only once limits, read-only mode,
+	// quota and tracing are in place
+	if err := server.cacheManager.RestoreJobs(); err != nil {
+		slog.Error("Error restoring saved jobs", "error", err)
+	}
+	if *JellyfinURL != "" {
+		server.StartJellyfinPoller(*JellyfinURL, *JellyfinKey, *JellyfinInterval, *JellyfinAhead)
+	}
+	server.mount = mount
+	server.StartHealthMonitor(*HealthInterval, *HealthTimeout)
+	if *Watch != "" {
+		if err := server.StartWatcher(strings.Split(*Watch, ","), *WatchSettle); err != nil {
+			log.Fatalf("Error starting watcher: %v", err)
+		}
+	}
+	tlsOpts := TLSOptions{
+		CertFile:     *TLSCert,
+		KeyFile:      *TLSKey,
+		ACMEDomains:  parseList(*ACMEDomains),
+		ACMECache:    *ACMECache,
+		ACMEEmail:    *ACMEEmail,
+		ClientCAFile: *TLSClientCA,
+	}
+	if tlsOpts.ClientCAFile != "" && !tlsOpts.enabled() {
+		log.Fatal("-tls-client-ca needs -tls-cert or -acme-domain")
+	}
+	if tlsOpts.ACMECache == "" {
+		tlsOpts.ACMECache = filepath.Join(server.stateDir, "acme")
+	}
 	r := server.SetupRouter()
-	if err := r.Run(":8000"); err != nil {
+	startWatchdog(server.cacheManager.QueueStalled)
+
+	// On SIGTERM or SIGINT, park jobs before the HTTP server drains. A
+	// second signal kills the process at once.
+	signals, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	httpCtx, stopHTTP := context.WithCancel(context.Background())
+	go func() {
+		<-signals.Done()
+		stopSignals()
+		slog.Info("Shutting down")
+		sdNotify("STOPPING=1")
+		server.cacheManager.Shutdown()
+		stopHTTP()
+	}()
+	grpcDone := make(chan struct{})
+	go func() {
+		defer close(grpcDone)
+		if *GRPCListen == "" {
+			return
+		}
+		if err := server.listenGRPC(httpCtx, *GRPCListen, tlsOpts, *ShutdownTimeout); err != nil {
+			log.Fatalf("Error serving gRPC: %v", err)
+		}
+	}()
+	if err := listen(httpCtx, systemdLn, *Listen, r, tlsOpts, *ShutdownTimeout); err != nil {
 		log.Fatal(err)
 	}
+	<-grpcDone
+	server.tracer.Flush()
+	slog.Info("Stopped")
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+)
+
+// statsdPacketSize keeps StatsD datagrams below a typical Ethernet MTU
+const statsdPacketSize = 1432
+
+// metricSample is one measurement taken for a push
+type metricSample struct {
+	name  string
+	value float64
+}
+
+// MetricsPusher sends job and throughput metrics to StatsD over UDP and to
+// InfluxDB's HTTP write API, for setups that don't scrape Prometheus
+type MetricsPusher struct {
+	cm          *cache.Manager
+	prefix      string
+	statsd      net.Conn // nil without StatsD
+	influxURL   string   // Write endpoint with its org, bucket or db query, empty without InfluxDB
+	influxToken string
+	client      *http.Client
+}
+
+// NewMetricsPusher connects to the configured endpoints. statsdAddr is a
+// host:port, influxURL a full write URL such as
+// http://localhost:8086/api/v2/write?org=home&bucket=precache.
+func NewMetricsPusher(cm *cache.Manager, prefix, statsdAddr, influxURL, influxToken string) (*MetricsPusher, error) {
+	p := &MetricsPusher{
+		cm:          cm,
+		prefix:      prefix,
+		influxURL:   influxURL,
+		influxToken: influxToken,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	if statsdAddr != "" {
+		conn, err := net.Dial("udp", statsdAddr)
+		if err != nil {
+			return nil, fmt.Errorf("statsd: %w", err)
+		}
+		p.statsd = conn
+	}
+	return p, nil
+}
+
+// Start pushes metrics every interval in the background
+func (p *MetricsPusher) Start(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			p.push()
+		}
+	}()
+}
+
+// push takes one set of samples and sends it everywhere configured
+func (p *MetricsPusher) push() {
+	now := time.Now()
+	global := p.cm.GetGlobalProgress()
+	samples := []metricSample{
+		{"speed_bytes", global.TotalSpeed},
+		{"overall_percent", global.OverallPercent},
+		{"active_jobs", float64(global.ActiveJobs)},
+		{"queued_jobs", float64(global.QueuedJobs)},
+		{"paused_jobs", float64(global.PausedJobs)},
+		{"cached_bytes", float64(global.CachedSize)},
+		{"remaining_bytes", float64(global.BytesRemaining)},
+	}
+	if global.VFS != nil {
+		samples = append(samples,
+			metricSample{"vfs_cache_bytes", float64(global.VFS.CacheBytesUsed)},
+			metricSample{"vfs_cache_files", float64(global.VFS.CacheFiles)},
+		)
+	}
+
+	if p.statsd != nil {
+		if err := p.sendStatsd(samples); err != nil {
+			slog.Warn("Error sending StatsD metrics", "error", err)
+		}
+	}
+	if p.influxURL != "" {
+		if err := p.writeInflux(samples, p.cm.ListJobs(), now); err != nil {
+			slog.Warn("Error writing InfluxDB metrics", "url", p.influxURL, "error", err)
+		}
+	}
+}
+
+// sendStatsd sends the samples as gauges, packing as many as fit in each
+// datagram
+func (p *MetricsPusher) sendStatsd(samples []metricSample) error {
+	var packet bytes.Buffer
+	for _, sample := range samples {
+		line := fmt.Sprintf("%s.%s:%s|g", p.prefix, sample.name, strconv.FormatFloat(sample.value, 'f', -1, 64))
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacketSize {
+			if _, err := p.statsd.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() == 0 {
+		return nil
+	}
+	_, err := p.statsd.Write(packet.Bytes())
+	return err
+}
+
+// writeInflux writes the samples as one point and each unfinished job as a
+// point tagged with its ID and path, in line protocol
+func (p *MetricsPusher) writeInflux(samples []metricSample, jobs []*cache.Job, now time.Time) error {
+	var body bytes.Buffer
+	fields := make([]string, len(samples))
+	for i, sample := range samples {
+		fields[i] = sample.name + "=" + strconv.FormatFloat(sample.value, 'f', -1, 64)
+	}
+	fmt.Fprintf(&body, "%s %s %d\n", p.prefix, strings.Join(fields, ","), now.UnixNano())
+
+	for _, job := range jobs {
+		progress := job.Progress()
+		if progress.IsComplete {
+			continue
+		}
+		fmt.Fprintf(&body, "%s_job,job=%s,path=%s,state=%s speed_bytes=%s,read_bytes=%di,cached_bytes=%di,total_bytes=%di,errors=%di %d\n",
+			p.prefix, influxTag(job.ID), influxTag(job.Path), influxTag(string(progress.State)),
+			strconv.FormatFloat(progress.CurrentSpeed, 'f', -1, 64),
+			progress.TotalBytesRead, progress.CachedSize, progress.TotalSize, progress.ErrorCount,
+			now.UnixNano())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.influxURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if p.influxToken != "" {
+		req.Header.Set("Authorization", "Token "+p.influxToken)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("InfluxDB returned %s", resp.Status)
+	}
+	return nil
+}
+
+// influxTagEscaper escapes the characters line protocol gives a meaning
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+
+// influxTag formats a line protocol tag value, which may not be empty
+func influxTag(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return influxTagEscaper.Replace(value)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Delays between restarts of a managed rclone mount that keeps exiting
+const (
+	mountRestartDelay    = 5 * time.Second
+	mountMaxRestartDelay = time.Minute
+)
+
+// RcloneMount runs `rclone mount` as a child process and restarts it
+// whenever it exits
+type RcloneMount struct {
+	bin       string
+	remote    string
+	mountPath string
+	args      []string      // Extra flags, e.g. --vfs-cache-mode full
+	timeout   time.Duration // How long a remount may take to become ready
+
+	mu         sync.Mutex
+	cmd        *exec.Cmd
+	restart    chan struct{} // Skips the delay before the next restart
+	remounting bool
+}
+
+// NewRcloneMount prepares a managed mount of remote at mountPath
+func NewRcloneMount(bin, remote, mountPath string, args []string, timeout time.Duration) *RcloneMount {
+	return &RcloneMount{
+		bin:       bin,
+		remote:    remote,
+		mountPath: mountPath,
+		args:      args,
+		timeout:   timeout,
+		restart:   make(chan struct{}, 1),
+	}
+}
+
+// Start launches rclone and keeps it running in the background
+func (m *RcloneMount) Start() {
+	go func() {
+		delay := mountRestartDelay
+		for {
+			started := time.Now()
+			err := m.run()
+			slog.Error("rclone mount exited", "remote", m.remote, "error", err)
+
+			// A mount that ran for a while gets restarted quickly again
+			if time.Since(started) > mountMaxRestartDelay {
+				delay = mountRestartDelay
+			}
+			select {
+			case <-time.After(delay):
+			case <-m.restart:
+			}
+			delay = min(delay*2, mountMaxRestartDelay)
+		}
+	}()
+}
+
+// run executes one rclone process until it exits, logging its output
+func (m *RcloneMount) run() error {
+	args := append([]string{"mount", m.remote, m.mountPath}, m.args...)
+	cmd := exec.Command(m.bin, args...)
+	setChildAttributes(cmd)
+	output, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = cmd.Stderr
+
+	slog.Info("Starting rclone", "bin", m.bin, "args", args)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cmd = cmd
+	m.mu.Unlock()
+
+	logOutput(output)
+	err = cmd.Wait()
+
+	m.mu.Lock()
+	m.cmd = nil
+	m.mu.Unlock()
+	return err
+}
+
+// logOutput copies rclone's output into the log line by line
+func logOutput(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		slog.Info("rclone", "output", scanner.Text())
+	}
+}
+
+// Remount stops rclone, detaches the dead mount and starts rclone again,
+// waiting for the new mount to become ready. Concurrent calls return at once.
+func (m *RcloneMount) Remount() error {
+	m.mu.Lock()
+	if m.remounting {
+		m.mu.Unlock()
+		return nil
+	}
+	m.remounting = true
+	cmd := m.cmd
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.remounting = false
+		m.mu.Unlock()
+	}()
+
+	slog.Info("Remounting", "remote", m.remote, "mount", m.mountPath)
+	if cmd != nil {
+		cmd.Process.Kill()
+	}
+	if err := forceUnmount(m.mountPath); err != nil {
+		slog.Error("Error unmounting", "mount", m.mountPath, "error", err)
+	}
+	select {
+	case m.restart <- struct{}{}:
+	default:
+	}
+	return m.WaitReady(m.timeout)
+}
+
+// WaitReady blocks until the mount point is mounted and readable
+func (m *RcloneMount) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		mounted, err := isMountPoint(m.mountPath)
+		if mounted {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err == nil {
+				err = fmt.Errorf("%s is not mounted", m.mountPath)
+			}
+			return fmt.Errorf("rclone mount not ready after %s: %w", timeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
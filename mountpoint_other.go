@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package main
+
+import "errors"
+
+// isMountPoint can't tell mounts apart on this platform
+func isMountPoint(path string) (bool, error) {
+	return false, errors.New("mount detection not supported on this platform")
+}
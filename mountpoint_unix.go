@@ -0,0 +1,29 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// isMountPoint reports whether path is the root of a filesystem other than
+// its parent's
+func isMountPoint(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	parent, err := os.Stat(filepath.Dir(filepath.Clean(path)))
+	if err != nil {
+		return false, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	parentStat, parentOK := parent.Sys().(*syscall.Stat_t)
+	if !ok || !parentOK {
+		return false, errors.New("device numbers not available")
+	}
+	return stat.Dev != parentStat.Dev, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/fffonion/rclone-precache/pkg/api"
+	"github.com/fffonion/rclone-precache/pkg/pathutil"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrUnknownMount is returned for API paths naming no configured mount
+var ErrUnknownMount = errors.New("unknown mount")
+
+// Mount pairs a mounted remote with the cache directory holding its data.
+// With several mounts, API paths start with the mount name, e.g.
+// /gdrive/tv/show. A single unnamed mount serves paths directly.
+type Mount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mount_path"`
+	CachePath string `json:"cache_path"`
+	// Defaults are precache query parameters for jobs on this mount, e.g.
+	// threads, chunk, bwlimit and filters, overridden by each request
+	Defaults url.Values `json:"defaults,omitempty"`
+
+	health *HealthMonitor
+}
+
+// parseMounts parses a comma separated list of name=mount:cache entries.
+// Job defaults may follow as a query string, e.g.
+// gdrive=/mnt/gdrive:/cache/gdrive?threads=8&chunk=16M&bwlimit=40M.
+func parseMounts(list string) ([]*Mount, error) {
+	var mounts []*Mount
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		entry, rawQuery, _ := strings.Cut(entry, "?")
+		defaults, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return nil, fmt.Errorf("invalid defaults of mount %q: %w", entry, err)
+		}
+		name, paths, ok := strings.Cut(entry, "=")
+		mountPath, cachePath, ok2 := splitMountPaths(paths)
+		if !ok || !ok2 || name == "" || strings.Contains(name, "/") || mountPath == "" || cachePath == "" {
+			return nil, fmt.Errorf("invalid mount %q, want name=mount:cache", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate mount %q", name)
+		}
+		seen[name] = true
+		mounts = append(mounts, &Mount{Name: name, MountPath: mountPath, CachePath: cachePath, Defaults: defaults})
+	}
+	return mounts, nil
+}
+
+// splitMountPaths splits mount:cache at the first colon that doesn't end a
+// Windows drive letter, so that C:\media:D:\cache splits in two
+func splitMountPaths(paths string) (string, string, bool) {
+	skip := 0
+	if len(paths) >= 2 && paths[1] == ':' && unicode.IsLetter(rune(paths[0])) &&
+		(len(paths) == 2 || paths[2] == '\\' || paths[2] == '/') {
+		skip = 2
+	}
+	mountPath, cachePath, ok := strings.Cut(paths[skip:], ":")
+	return paths[:skip] + mountPath, cachePath, ok
+}
+
+// checkMountDefaults reports the first mount whose job defaults are invalid
+func (s *Server) checkMountDefaults() error {
+	for _, m := range s.mounts {
+		if _, err := s.parseJobOptions(cleanPath(m.Name), nil); err != nil {
+			return fmt.Errorf("mount %q: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// named reports whether API paths are prefixed with mount names
+func (s *Server) named() bool {
+	return len(s.mounts) > 1 || s.mounts[0].Name != ""
+}
+
+// locate returns the mount serving an API path and the path inside it
+func (s *Server) locate(reqPath string) (*Mount, string, error) {
+	reqPath = cleanPath(reqPath)
+	if !s.named() {
+		return s.mounts[0], reqPath, nil
+	}
+	name, rest, _ := strings.Cut(strings.TrimPrefix(reqPath, "/"), "/")
+	for _, m := range s.mounts {
+		if m.Name == name {
+			return m, cleanPath(rest), nil
+		}
+	}
+	return nil, "", fmt.Errorf("%w %q", ErrUnknownMount, name)
+}
+
+// paths returns the source and cache paths of an API path
+func (s *Server) paths(reqPath string) (string, string, error) {
+	m, inner, err := s.locate(reqPath)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(m.MountPath, inner), filepath.Join(m.CachePath, inner), nil
+}
+
+// exists reports whether an API path names an existing source file or
+// directory
+func (s *Server) exists(reqPath string) bool {
+	sourcePath, _, err := s.paths(reqPath)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(sourcePath)
+	return err == nil
+}
+
+// requestPath turns a path below one of the mount points into an API path
+func (s *Server) requestPath(sourcePath string) (string, bool) {
+	sourcePath = path.Clean(filepath.ToSlash(sourcePath))
+	for _, m := range s.mounts {
+		mountPath := path.Clean(filepath.ToSlash(m.MountPath))
+		if pathutil.HasPrefix(sourcePath, mountPath) {
+			return cleanPath(path.Join(m.Name, strings.TrimPrefix(sourcePath, mountPath))), true
+		}
+	}
+	return "", false
+}
+
+// externalPath maps a path reported by an integration to an API path,
+// using the path map first and then the mount points
+func (s *Server) externalPath(external string) (string, bool) {
+	if reqPath, ok := s.pathMap.resolve(external); ok {
+		return reqPath, true
+	}
+	return s.requestPath(external)
+}
+
+// browseMounts lists the configured mounts as top-level directories
+func (s *Server) browseMounts(c *gin.Context) {
+	c.JSON(http.StatusOK, s.listMounts(c.GetString("user")))
+}
+
+// listMounts returns the mounts user may see as directories
+func (s *Server) listMounts(user string) []api.FileInfo {
+	fileInfos := make([]api.FileInfo, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		if !s.acl.visible(user, "/"+m.Name) {
+			continue
+		}
+		var created float64
+		if info, err := os.Stat(m.MountPath); err == nil {
+			created = float64(info.ModTime().Unix())
+		}
+		fileInfos = append(fileInfos, api.FileInfo{
+			Name:        m.Name,
+			Path:        "/" + m.Name,
+			IsDir:       true,
+			CreatedTime: created,
+			CachedSize:  s.cachedSize(m.CachePath, true),
+		})
+	}
+	return fileInfos
+}
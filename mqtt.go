@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of MQTT notifications
+const (
+	defaultMQTTTopic         = "rclone-precache"
+	defaultMQTTClientID      = "rclone-precache"
+	defaultMQTTStatsInterval = 30 * time.Second
+	mqttPublishTimeout       = 10 * time.Second
+)
+
+// mqttConfig are the settings of an mqtt notifications entry
+type mqttConfig struct {
+	Broker        string `yaml:"broker"` // e.g. tcp://localhost:1883, ssl:// or ws://
+	Username      string `yaml:"username"`
+	Password      string `yaml:"password"`
+	ClientID      string `yaml:"client-id"`
+	Topic         string `yaml:"topic"` // Prefix of all topics published to
+	QoS           *byte  `yaml:"qos"`
+	StatsInterval string `yaml:"stats-interval"` // 0 disables stats
+}
+
+// MQTTPublisher publishes job events and global stats to an MQTT broker,
+// e.g. for Home Assistant. Below the topic prefix it publishes
+//
+//	status        online or offline, retained
+//	stats         global progress every stats interval, retained
+//	job/<event>   summary of a job that was queued, started, completed or failed
+type MQTTPublisher struct {
+	server *Server
+	client mqtt.Client
+	topic  string
+	qos    byte
+}
+
+func newMQTTNotifier(s *Server, entry *yaml.Node) (cache.Notifier, error) {
+	var config mqttConfig
+	if err := entry.Decode(&config); err != nil {
+		return nil, err
+	}
+	if config.Broker == "" {
+		return nil, fmt.Errorf("broker is required")
+	}
+	if config.Topic == "" {
+		config.Topic = defaultMQTTTopic
+	}
+	if config.ClientID == "" {
+		config.ClientID = defaultMQTTClientID
+	}
+	p := &MQTTPublisher{server: s, topic: config.Topic, qos: 1}
+	if config.QoS != nil {
+		if *config.QoS > 2 {
+			return nil, fmt.Errorf("qos must be 0, 1 or 2")
+		}
+		p.qos = *config.QoS
+	}
+	interval := defaultMQTTStatsInterval
+	if config.StatsInterval != "" {
+		var err error
+		if interval, err = parseDuration(config.StatsInterval); err != nil {
+			return nil, fmt.Errorf("stats-interval: %w", err)
+		}
+	}
+
+	status := p.topic + "/status"
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(status, "offline", p.qos, true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			slog.Info("Connected to MQTT broker", "broker", config.Broker)
+			client.Publish(status, p.qos, true, "online")
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("Lost connection to MQTT broker", "broker", config.Broker, "error", err)
+		})
+	p.client = mqtt.NewClient(opts)
+	// Connects in the background, retrying until the broker is up
+	p.client.Connect()
+
+	if interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				p.publishStats()
+			}
+		}()
+	}
+	return p, nil
+}
+
+// publish sends a JSON payload and waits for the broker to take it
+func (p *MQTTPublisher) publish(topic string, retained bool, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Error encoding MQTT payload", "topic", topic, "error", err)
+		return
+	}
+	token := p.client.Publish(p.topic+"/"+topic, p.qos, retained, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		slog.Warn("Timed out publishing to MQTT", "topic", p.topic+"/"+topic)
+	} else if err := token.Error(); err != nil {
+		slog.Warn("Error publishing to MQTT", "topic", p.topic+"/"+topic, "error", err)
+	}
+}
+
+// publishStats sends the global progress, retained so that subscribers
+// see it right away
+func (p *MQTTPublisher) publishStats() {
+	if !p.client.IsConnected() {
+		return
+	}
+	p.publish("stats", true, p.server.cacheManager.GetGlobalProgress())
+}
+
+func (p *MQTTPublisher) JobQueued(job cache.JobSummary) {
+	p.publish("job/"+notifyQueued, false, job)
+}
+
+func (p *MQTTPublisher) JobStarted(job cache.JobSummary) {
+	p.publish("job/"+notifyStarted, false, job)
+}
+
+func (p *MQTTPublisher) JobCompleted(job cache.JobSummary) {
+	p.publish("job/"+notifyCompleted, false, job)
+}
+
+func (p *MQTTPublisher) JobFailed(job cache.JobSummary) {
+	p.publish("job/"+notifyFailed, false, job)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"gopkg.in/yaml.v3"
+)
+
+// notifierFactory builds a notification backend from its entry in the
+// notifications list of the config file
+type notifierFactory func(s *Server, entry *yaml.Node) (cache.Notifier, error)
+
+// notifierFactories are the notification backends by the type naming them
+// in the config file
+var notifierFactories = map[string]notifierFactory{
+	"command":  newCommandNotifier,
+	"discord":  newDiscordNotifier,
+	"gotify":   newGotifyNotifier,
+	"log":      newLogNotifier,
+	"mqtt":     newMQTTNotifier,
+	"ntfy":     newNtfyNotifier,
+	"telegram": newTelegramNotifier,
+	"webhook":  newWebhookNotifier,
+}
+
+// notifierEvents are the job events sent to backends of a type when their
+// entry lists none. Types not listed get all events.
+var notifierEvents = map[string][]string{
+	"command":  {notifyCompleted, notifyFailed},
+	"discord":  {notifyStarted, notifyCompleted, notifyFailed},
+	"gotify":   {notifyCompleted, notifyFailed},
+	"ntfy":     {notifyCompleted, notifyFailed},
+	"telegram": {notifyCompleted, notifyFailed},
+	"webhook":  {notifyCompleted, notifyFailed},
+}
+
+// Job events a notification entry may subscribe to
+const (
+	notifyQueued    = "queued"
+	notifyStarted   = "started"
+	notifyCompleted = "completed"
+	notifyFailed    = "failed"
+)
+
+// notificationConfig is a notifications entry of the config file. Type
+// selects the backend, events the job events sent to it. The other keys
+// are settings of the backend.
+type notificationConfig struct {
+	Type   string   `yaml:"type"`
+	Events []string `yaml:"events"`
+
+	node yaml.Node
+}
+
+func (c *Config) decodeNotifications(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: want a list of notification backends", node.Line)
+	}
+	for _, item := range node.Content {
+		var entry notificationConfig
+		if err := item.Decode(&entry); err != nil {
+			return err
+		}
+		if _, ok := notifierFactories[entry.Type]; !ok {
+			return fmt.Errorf("line %d: unknown notification type %q", item.Line, entry.Type)
+		}
+		for _, event := range entry.Events {
+			switch event {
+			case notifyQueued, notifyStarted, notifyCompleted, notifyFailed:
+			default:
+				return fmt.Errorf("line %d: unknown job event %q", item.Line, event)
+			}
+		}
+		if len(entry.Events) == 0 {
+			entry.Events = notifierEvents[entry.Type]
+		}
+		entry.node = *item
+		c.Notifications = append(c.Notifications, entry)
+	}
+	return nil
+}
+
+// EnableNotifications builds the configured notification backends and
+// tells each about the job events it subscribed to. Several backends,
+// also of the same type, may be enabled at once.
+func (s *Server) EnableNotifications(entries []notificationConfig) error {
+	for i, entry := range entries {
+		notifier, err := notifierFactories[entry.Type](s, &entry.node)
+		if err != nil {
+			return fmt.Errorf("notification %d (%s): %w", i+1, entry.Type, err)
+		}
+		if len(entry.Events) > 0 {
+			notifier = newEventFilter(notifier, entry.Events)
+		}
+		s.cacheManager.AddNotifier(notifier)
+		slog.Info("Enabled notifications", "type", entry.Type)
+	}
+	return nil
+}
+
+// eventFilter passes on only the job events a backend subscribed to
+type eventFilter struct {
+	notifier cache.Notifier
+	events   map[string]bool
+}
+
+func newEventFilter(notifier cache.Notifier, events []string) *eventFilter {
+	f := &eventFilter{notifier: notifier, events: make(map[string]bool)}
+	for _, event := range events {
+		f.events[event] = true
+	}
+	return f
+}
+
+func (f *eventFilter) JobQueued(job cache.JobSummary) {
+	if f.events[notifyQueued] {
+		f.notifier.JobQueued(job)
+	}
+}
+
+func (f *eventFilter) JobStarted(job cache.JobSummary) {
+	if f.events[notifyStarted] {
+		f.notifier.JobStarted(job)
+	}
+}
+
+func (f *eventFilter) JobCompleted(job cache.JobSummary) {
+	if f.events[notifyCompleted] {
+		f.notifier.JobCompleted(job)
+	}
+}
+
+func (f *eventFilter) JobFailed(job cache.JobSummary) {
+	if f.events[notifyFailed] {
+		f.notifier.JobFailed(job)
+	}
+}
+
+// logNotifier writes job events to the log, e.g. to try out which events
+// an entry subscribes to
+type logNotifier struct{}
+
+func newLogNotifier(*Server, *yaml.Node) (cache.Notifier, error) {
+	return logNotifier{}, nil
+}
+
+func (logNotifier) JobQueued(job cache.JobSummary) {
+	slog.Info("Job queued", "job", job.ID, "path", job.Path, "user", job.User)
+}
+
+func (logNotifier) JobStarted(job cache.JobSummary) {
+	slog.Info("Job started", "job", job.ID, "path", job.Path, "bytes", job.TotalSize)
+}
+
+func (logNotifier) JobCompleted(job cache.JobSummary) {
+	slog.Info("Job completed", "job", job.ID, "path", job.Path, "bytes", job.BytesRead, "seconds", job.Duration)
+}
+
+func (logNotifier) JobFailed(job cache.JobSummary) {
+	slog.Info("Job failed", "job", job.ID, "path", job.Path, "state", job.State, "errors", job.ErrorCount)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionCookie   = "rp_session"
+	loginCookie     = "rp_oidc_login" // State, nonce and return path of a login in progress
+	sessionLifetime = 24 * time.Hour
+	loginLifetime   = 10 * time.Minute
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// OIDCProvider logs users into the web UI with the authorization code flow
+// and validates bearer JWTs sent to the API. Sessions are kept in signed
+// cookies, so they end when the server restarts.
+type OIDCProvider struct {
+	clientID     string
+	clientSecret string
+	redirectURL  string // Empty to derive it from each request
+	scopes       []string
+	client       *http.Client
+
+	authURL  string
+	tokenURL string
+	verifier *oidc.IDTokenVerifier // Checks signature, issuer, audience and lifetime
+
+	sessionKey []byte
+}
+
+// NewOIDCProvider discovers the endpoints of issuer
+func NewOIDCProvider(issuer, clientID, clientSecret, redirectURL string, scopes []string) (*OIDCProvider, error) {
+	p := &OIDCProvider{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  redirectURL,
+		scopes:       scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		sessionKey:   make([]byte, 32),
+	}
+	if _, err := rand.Read(p.sessionKey); err != nil {
+		return nil, err
+	}
+
+	// The provider fetches its signing keys with this client later on, so
+	// its context must outlive discovery
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), p.client), issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", issuer, err)
+	}
+	endpoint := provider.Endpoint()
+	p.authURL, p.tokenURL = endpoint.AuthURL, endpoint.TokenURL
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: clientID})
+	return p, nil
+}
+
+// idClaims are the ID token and access token claims used here
+type idClaims struct {
+	Subject           string `json:"sub"`
+	Nonce             string `json:"nonce"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+}
+
+// user returns the name a token's holder is known by
+func (c idClaims) user() string {
+	switch {
+	case c.PreferredUsername != "":
+		return c.PreferredUsername
+	case c.Email != "":
+		return c.Email
+	default:
+		return c.Subject
+	}
+}
+
+// verify checks a JWT's signature, issuer, audience and lifetime. Names
+// that would pass for API keys in ACL rules and job attribution are
+// refused, as users can often choose their preferred_username.
+func (p *OIDCProvider) verify(ctx context.Context, token string) (idClaims, error) {
+	var claims idClaims
+	idToken, err := p.verifier.Verify(ctx, token)
+	if err != nil {
+		return claims, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return claims, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if user := claims.user(); strings.HasPrefix(user, apiKeyUserPrefix) {
+		return claims, fmt.Errorf("%w: user name %q is reserved for API keys", ErrInvalidToken, user)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// sign returns value with an HMAC appended
+func (p *OIDCProvider) sign(value string) string {
+	mac := hmac.New(sha256.New, p.sessionKey)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// unsign returns the value of a signed string, if the HMAC matches
+func (p *OIDCProvider) unsign(signed string) (string, bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", false
+	}
+	value := signed[:i]
+	return value, hmac.Equal([]byte(p.sign(value)), []byte(signed))
+}
+
+// session is the content of a session cookie
+type session struct {
+	User    string `json:"user"`
+	Expires int64  `json:"exp"`
+}
+
+// authenticate returns the user of a valid bearer token or session cookie
+func (p *OIDCProvider) authenticate(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		claims, err := p.verify(r.Context(), token)
+		return claims.user(), err == nil
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	value, ok := p.unsign(cookie.Value)
+	if !ok {
+		return "", false
+	}
+	var sess session
+	if decodeSegment(value, &sess) != nil || sess.Expires < time.Now().Unix() {
+		return "", false
+	}
+	return sess.User, true
+}
+
+// callbackURL returns the redirect URL registered with the provider
+func (p *OIDCProvider) callbackURL(r *http.Request) string {
+	if p.redirectURL != "" {
+		return p.redirectURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + "/auth/callback"
+}
+
+func randomString() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// handleLogin redirects to the provider, returning to next afterwards
+func (p *OIDCProvider) handleLogin(c *gin.Context) {
+	next := c.Query("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+	state, nonce := randomString(), randomString()
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(loginCookie, p.sign(strings.Join([]string{state, nonce, next}, "|")),
+		int(loginLifetime.Seconds()), "/auth/", "", c.Request.TLS != nil, true)
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.clientID},
+		"redirect_uri":  {p.callbackURL(c.Request)},
+		"scope":         {strings.Join(p.scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	c.Redirect(http.StatusFound, p.authURL+"?"+query.Encode())
+}
+
+// exchange trades an authorization code for an ID token
+func (p *OIDCProvider) exchange(r *http.Request, code string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.callbackURL(r)},
+	}
+	req, err := http.NewRequest(http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || result.IDToken == "" {
+		return "", fmt.Errorf("token request failed: %s %s", resp.Status, result.Error)
+	}
+	return result.IDToken, nil
+}
+
+// handleCallback completes a login and sets the session cookie
+func (p *OIDCProvider) handleCallback(c *gin.Context) {
+	cookie, err := c.Cookie(loginCookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No login in progress"})
+		return
+	}
+	value, ok := p.unsign(cookie)
+	parts := strings.SplitN(value, "|", 3)
+	if !ok || len(parts) != 3 || parts[0] != c.Query("state") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login state mismatch"})
+		return
+	}
+	if errParam := c.Query("error"); errParam != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Login failed: %s", errParam)})
+		return
+	}
+
+	idToken, err := p.exchange(c.Request, c.Query("code"))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	claims, err := p.verify(c.Request.Context(), idToken)
+	if err == nil && claims.Nonce != parts[1] {
+		err = fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, _ := json.Marshal(session{User: claims.user(), Expires: time.Now().Add(sessionLifetime).Unix()})
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(loginCookie, "", -1, "/auth/", "", c.Request.TLS != nil, true)
+	c.SetCookie(sessionCookie, p.sign(base64.RawURLEncoding.EncodeToString(data)),
+		int(sessionLifetime.Seconds()), "/", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, parts[2])
+}
+
+// handleLogout ends the session
+func (p *OIDCProvider) handleLogout(c *gin.Context) {
+	c.SetCookie(sessionCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
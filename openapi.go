@@ -0,0 +1,443 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/fffonion/rclone-precache/pkg/api"
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/fffonion/rclone-precache/pkg/sizer"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed frontend/docs.html
+var docsHTML string
+
+// apiParam is a query parameter of an API route
+type apiParam struct {
+	Name        string
+	Type        string // OpenAPI type of one value
+	Description string
+	Repeated    bool // May be given several times
+}
+
+// apiOperation documents an API route for the OpenAPI document. Body and
+// Response hold a value of the JSON type sent and returned, nil for none.
+type apiOperation struct {
+	Summary     string
+	Scope       string // Least API key scope allowed to call the route
+	Query       []apiParam
+	Body        interface{}
+	BodyType    string // Content type of Body, JSON if empty
+	Response    interface{}
+	ContentType string // Content type of Response, JSON if empty
+}
+
+// Response bodies the handlers build with gin.H, besides those in pkg/api
+type (
+	auditPage struct {
+		Total   int           `json:"total"`
+		Offset  int           `json:"offset"`
+		Limit   int           `json:"limit"`
+		Records []AuditRecord `json:"records"`
+	}
+	healthReport struct {
+		Healthy bool          `json:"healthy"`
+		Mounts  []MountStatus `json:"mounts"`
+	}
+	readyReport struct {
+		Ready  bool         `json:"ready"`
+		Checks []ReadyCheck `json:"checks"`
+	}
+	apiKeyRequest struct {
+		Name  string `json:"name"`
+		Scope string `json:"scope"`
+	}
+	apiKeyCreated struct {
+		Key   APIKey `json:"key"`
+		Token string `json:"token"`
+	}
+)
+
+// Query parameters shared by several routes
+var (
+	precacheParams = []apiParam{
+		{Name: "threads", Type: "integer", Description: "Threads per file"},
+		{Name: "chunk", Type: "string", Description: "Bytes read at once, such as 8M"},
+		{Name: "files", Type: "integer", Description: "Files of a directory cached at once"},
+		{Name: "mode", Type: "string", Description: "full, headtail or media"},
+		{Name: "strategy", Type: "string", Description: "read or advise"},
+		{Name: "head", Type: "string", Description: "Bytes cached from the start of each file in headtail mode"},
+		{Name: "tail", Type: "string", Description: "Bytes cached from the end of each file in headtail mode"},
+		{Name: "include", Type: "string", Description: "Glob a file must match one of", Repeated: true},
+		{Name: "exclude", Type: "string", Description: "Glob no file may match", Repeated: true},
+		{Name: "regex", Type: "string", Description: "Pattern the relative path must contain a match of"},
+		{Name: "min_size", Type: "string", Description: "Smallest file cached"},
+		{Name: "max_size", Type: "string", Description: "Largest file cached"},
+		{Name: "newer_than", Type: "string", Description: "Age such as 7d or a timestamp files must be modified after"},
+		{Name: "depth", Type: "integer", Description: "Directory levels to descend, 0 for the top level only"},
+		{Name: "priority", Type: "string", Description: "low, normal or high"},
+		{Name: "preempt", Type: "boolean", Description: "Pause lower priority jobs to free a slot"},
+		{Name: "bwlimit", Type: "string", Description: "Bytes per second for this job, or off"},
+		{Name: "dry_run", Type: "boolean", Description: "Only return an estimate"},
+	}
+	userParams = []apiParam{
+		{Name: "user", Type: "string", Description: "Only jobs started by this user"},
+		{Name: "mine", Type: "boolean", Description: "Only jobs started by the caller"},
+	}
+	dateParams = []apiParam{
+		{Name: "since", Type: "string", Description: "RFC 3339 timestamp or YYYY-MM-DD date"},
+		{Name: "until", Type: "string", Description: "RFC 3339 timestamp or YYYY-MM-DD date"},
+	}
+	pageParams = append([]apiParam{
+		{Name: "limit", Type: "integer", Description: "Records per page, 50 by default"},
+		{Name: "offset", Type: "integer", Description: "Records skipped"},
+	}, dateParams...)
+	exportParams = append(append([]apiParam{
+		{Name: "format", Type: "string", Description: "csv or json, csv by default"},
+	}, userParams...), dateParams...)
+	usageParams = []apiParam{
+		{Name: "period", Type: "string", Description: "day or week, day by default"},
+		{Name: "since", Type: "string", Description: "Age such as 30d, RFC 3339 timestamp or YYYY-MM-DD date, 30 days or 12 weeks ago by default"},
+		{Name: "until", Type: "string", Description: "Age, RFC 3339 timestamp or YYYY-MM-DD date, now by default"},
+	}
+	timeSeriesParams = []apiParam{
+		{Name: "range", Type: "string", Description: "How far back, such as 6h or 7d, 24h by default"},
+		{Name: "step", Type: "string", Description: "Time merged into one sample, 1m by default"},
+	}
+)
+
+// apiOperations documents the API routes, keyed like auditActions.
+// Registered routes missing here are still listed, without details.
+var apiOperations = map[string]apiOperation{
+	"GET /api/browse/*path": {Summary: "List a directory with cached sizes", Scope: ScopeRead,
+		Query: []apiParam{
+			{Name: "refresh", Type: "boolean", Description: "Refresh the listing through rclone's remote control first"},
+			{Name: "sort", Type: "string", Description: "name, size, mtime or cached_percent, mtime by default"},
+			{Name: "order", Type: "string", Description: "asc or desc, asc for name and desc otherwise by default"},
+			{Name: "q", Type: "string", Description: "Only entries whose names contain this, ignoring case"},
+			{Name: "glob", Type: "string", Description: "Only entries whose names match this glob, ignoring case"},
+		},
+		Response: []api.FileInfo{}},
+	"GET /api/stat/*path": {Summary: "Cached size of one file or directory and the job caching it", Scope: ScopeRead,
+		Response: api.PathInfo{}},
+	"GET /api/du/*path": {Summary: "Source and cached size of each entry of a directory", Scope: ScopeRead,
+		Response: api.DiskUsage{}},
+	"GET /api/estimate/*path": {Summary: "Estimate the bytes and time to cache a path", Scope: ScopeRead,
+		Query: precacheParams[:len(precacheParams)-1], Response: cache.Estimate{}},
+	"GET /api/cache-progress/*path": {Summary: "Progress of the job for a path, or overall progress for /", Scope: ScopeRead,
+		Response: cache.Job{}},
+	"GET /api/chunks/*path": {Summary: "Cached byte ranges of a file", Scope: ScopeRead,
+		Query:    []apiParam{{Name: "chunk_size", Type: "string", Description: "Size of the reported chunks"}},
+		Response: cache.ChunkMap{}},
+	"GET /api/events": {Summary: "Stream job progress and state changes as Server-Sent Events", Scope: ScopeRead,
+		ContentType: "text/event-stream"},
+	"GET /api/ws": {Summary: "Stream job progress and state changes over a WebSocket", Scope: ScopeRead},
+	"GET /api/history": {Summary: "Finished jobs, newest first", Scope: ScopeRead,
+		Query: append(append([]apiParam{}, userParams...), pageParams...), Response: api.HistoryPage{}},
+	"GET /api/history/export": {Summary: "Download finished jobs, newest first", Scope: ScopeRead,
+		Query: exportParams, ContentType: "text/csv"},
+	"GET /api/stats/timeseries": {Summary: "Throughput and cache size per minute, oldest first", Scope: ScopeRead,
+		Query: timeSeriesParams, Response: api.TimeSeries{}},
+	"GET /api/stats/usage": {Summary: "Bytes read per day or week and per mount", Scope: ScopeRead,
+		Query: usageParams, Response: api.UsageReport{}},
+	"GET /api/jobs": {Summary: "List tracked jobs", Scope: ScopeRead,
+		Query: userParams, Response: []cache.Job{}},
+	"GET /api/jobs/:id":     {Summary: "Get a job with its progress", Scope: ScopeRead, Response: cache.Job{}},
+	"GET /api/health":       {Summary: "Health of every mount, 503 while one is unhealthy", Scope: ScopeRead, Response: healthReport{}},
+	"GET /api/version":      {Summary: "Build version", Scope: ScopeRead, Response: BuildInfo{}},
+	"GET /api/openapi.json": {Summary: "This OpenAPI document", Scope: ScopeRead},
+	"GET /api/docs":         {Summary: "Interactive API documentation", Scope: ScopeRead, ContentType: "text/html"},
+	"GET /api/quota": {Summary: "Cache usage against the quota and the files evicted first", Scope: ScopeRead,
+		Query:    []apiParam{{Name: "limit", Type: "integer", Description: "Eviction candidates listed"}},
+		Response: api.QuotaReport{}},
+	"GET /api/pins":      {Summary: "List pinned paths", Scope: ScopeRead, Response: []Pin{}},
+	"GET /api/schedules": {Summary: "List schedules", Scope: ScopeRead, Response: []Schedule{}},
+
+	"POST /api/precache": {Summary: "Start jobs for several paths, all or none", Scope: ScopePrecache,
+		Body: api.BatchPrecacheRequest{}, Response: api.JobsStarted{}},
+	"POST /api/precache/*path": {Summary: "Start caching a file or directory", Scope: ScopePrecache,
+		Query: precacheParams, Response: api.JobStarted{}},
+	"DELETE /api/jobs":          {Summary: "Clear finished jobs and their summaries", Scope: ScopePrecache, Response: api.MessageResponse{}},
+	"DELETE /api/jobs/:id":      {Summary: "Cancel a queued or running job, or clear a finished one", Scope: ScopePrecache, Response: api.MessageResponse{}},
+	"POST /api/jobs/:id/pause":  {Summary: "Pause a running job", Scope: ScopePrecache, Response: api.MessageResponse{}},
+	"POST /api/jobs/:id/resume": {Summary: "Resume a paused job", Scope: ScopePrecache, Response: api.MessageResponse{}},
+	"POST /api/hooks/radarr": {Summary: "Radarr and Sonarr webhook", Scope: ScopePrecache,
+		Body: RadarrWebhook{}, Response: api.JobStarted{}},
+	"POST /api/hooks/plex": {Summary: "Plex webhook, with the JSON payload in a multipart form field, authenticated with the hook token if one is set", Scope: ScopePrecache,
+		Body: struct {
+			Payload string `json:"payload"`
+		}{}, BodyType: "multipart/form-data", Response: api.MessageResponse{}},
+	"POST /api/hooks/overseerr": {Summary: "Overseerr and Jellyseerr webhook", Scope: ScopePrecache,
+		Body: OverseerrWebhook{}, Response: api.MessageResponse{}},
+	"POST /api/hooks/completed": {Summary: "Precache a finished download, authenticated with the hook token", Scope: ScopePrecache,
+		Query: []apiParam{{Name: "token", Type: "string", Description: "Hook token, unless sent as a bearer token"}},
+		Body:  CompletedHook{}, Response: api.JobStarted{}},
+	"POST /api/hooks/tautulli": {Summary: "Tautulli webhook", Scope: ScopePrecache,
+		Body: TautulliWebhook{}, Response: api.MessageResponse{}},
+	"POST /api/pin/*path":   {Summary: "Pin a path so it is never evicted", Scope: ScopePrecache, Response: Pin{}},
+	"POST /api/unpin/*path": {Summary: "Unpin a path", Scope: ScopePrecache, Response: api.MessageResponse{}},
+
+	"DELETE /api/cache/*path": {Summary: "Delete the cached data of a path", Scope: ScopeAdmin,
+		Query:    []apiParam{{Name: "dry_run", Type: "boolean", Description: "Only report the bytes that would be freed"}},
+		Response: PurgeResult{}},
+	"GET /api/sizecache": {Summary: "Number and memory use of cached directory sizes", Scope: ScopeAdmin,
+		Response: sizer.Stats{}},
+	"DELETE /api/sizecache": {Summary: "Drop cached sizes below a path, or all of them", Scope: ScopeAdmin,
+		Query:    []apiParam{{Name: "path", Type: "string", Description: "Only drop sizes of this path and below"}},
+		Response: api.MessageResponse{}},
+	"POST /api/schedules":       {Summary: "Create a schedule", Scope: ScopeAdmin, Body: Schedule{}, Response: Schedule{}},
+	"DELETE /api/schedules/:id": {Summary: "Delete a schedule", Scope: ScopeAdmin, Response: api.MessageResponse{}},
+	"GET /api/audit": {Summary: "Audit log of state changing requests, newest first", Scope: ScopeAdmin,
+		Query: append([]apiParam{
+			{Name: "user", Type: "string", Description: "Only requests by this user"},
+			{Name: "action", Type: "string", Description: "Only this action, such as precache or key.create"},
+		}, pageParams...), Response: auditPage{}},
+	"GET /api/keys":        {Summary: "List API keys", Scope: ScopeAdmin, Response: []APIKey{}},
+	"POST /api/keys":       {Summary: "Create an API key, returning its token once", Scope: ScopeAdmin, Body: apiKeyRequest{}, Response: apiKeyCreated{}},
+	"DELETE /api/keys/:id": {Summary: "Revoke an API key", Scope: ScopeAdmin, Response: api.MessageResponse{}},
+
+	"GET /healthz": {Summary: "Liveness probe, without authentication", Response: map[string]string{}},
+	"GET /readyz":  {Summary: "Readiness probe, without authentication", Response: readyReport{}},
+}
+
+// routeParam matches gin's :name and *name path parameters
+var routeParam = regexp.MustCompile(`[:*]([A-Za-z_]+)`)
+
+// schemaBuilder collects the component schemas of reflected Go types
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+// schema returns the schema of t, referencing named structs as components
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(url.Values{}):
+		return map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := b.schema(t.Elem())
+		if _, ref := s["$ref"]; ref {
+			return s
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := componentName(t)
+		if _, ok := b.components[name]; !ok {
+			b.components[name] = nil // Guards against recursive types
+			b.components[name] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// object returns the schema of a struct's JSON fields, flattening embedded
+// structs the way encoding/json does
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+				add(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = b.schema(field.Type)
+			if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	add(t)
+
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// componentName names the schema of a Go type, capitalized for unexported
+// response types
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// content describes a request or response body
+func (b *schemaBuilder) content(value interface{}, contentType string) map[string]interface{} {
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	media := map[string]interface{}{}
+	if value != nil {
+		media["schema"] = b.schema(reflect.TypeOf(value))
+	}
+	return map[string]interface{}{contentType: media}
+}
+
+// openAPISpec builds an OpenAPI 3 document for the registered API and probe
+// routes
+func openAPISpec(routes gin.RoutesInfo) map[string]interface{} {
+	b := &schemaBuilder{components: map[string]interface{}{}}
+	errorSchema := b.content(api.ErrorResponse{}, "")
+	paths := map[string]map[string]interface{}{}
+
+	sort.Slice(routes, func(i, k int) bool { return routes[i].Path < routes[k].Path })
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, "/api/") && route.Path != "/healthz" && route.Path != "/readyz" {
+			continue
+		}
+		doc := apiOperations[route.Method+" "+route.Path]
+
+		var parameters []interface{}
+		for _, match := range routeParam.FindAllStringSubmatch(route.Path, -1) {
+			param := map[string]interface{}{"name": match[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}}
+			if match[1] == "path" {
+				param["description"] = "Path below the mount root, may contain slashes"
+			}
+			parameters = append(parameters, param)
+		}
+		for _, q := range doc.Query {
+			schema := map[string]interface{}{"type": q.Type}
+			if q.Repeated {
+				schema = map[string]interface{}{"type": "array", "items": schema}
+			}
+			parameters = append(parameters, map[string]interface{}{"name": q.Name, "in": "query", "description": q.Description, "schema": schema})
+		}
+
+		operation := map[string]interface{}{
+			"operationId": operationID(route.Method, route.Path),
+			"summary":     doc.Summary,
+			"responses": map[string]interface{}{
+				"200":     map[string]interface{}{"description": "Success", "content": b.content(doc.Response, doc.ContentType)},
+				"default": map[string]interface{}{"description": "Error", "content": errorSchema},
+			},
+		}
+		if doc.Scope != "" {
+			operation["tags"] = []string{doc.Scope}
+			operation["x-required-scope"] = doc.Scope
+		} else {
+			operation["tags"] = []string{"probes"}
+			operation["security"] = []interface{}{}
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if doc.Body != nil {
+			operation["requestBody"] = map[string]interface{}{"required": true, "content": b.content(doc.Body, doc.BodyType)}
+		}
+
+		specPath := routeParam.ReplaceAllString(route.Path, "{$1}")
+		if paths[specPath] == nil {
+			paths[specPath] = map[string]interface{}{}
+		}
+		paths[specPath][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "rclone-precache",
+			"description": "Precaches files of rclone mounts into the VFS cache. API keys are limited to the scope in each operation's tag.",
+			"version":     buildInfo().Version,
+		},
+		"tags": []interface{}{
+			map[string]interface{}{"name": ScopeRead, "description": "Browse and watch progress"},
+			map[string]interface{}{"name": ScopePrecache, "description": "Start, pause and cancel jobs, pin paths"},
+			map[string]interface{}{"name": ScopeAdmin, "description": "Purge the cache, manage schedules and keys"},
+			map[string]interface{}{"name": "probes", "description": "Container health checks"},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+			"securitySchemes": map[string]interface{}{
+				"apiKey":     map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-Api-Key"},
+				"basicAuth":  map[string]interface{}{"type": "http", "scheme": "basic"},
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "OIDC ID token"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"basicAuth": []string{}},
+			map[string]interface{}{"bearerAuth": []string{}},
+		},
+	}
+}
+
+// operationID derives an identifier such as postJobsIdPause from a route
+func operationID(method, route string) string {
+	var id strings.Builder
+	id.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(strings.TrimPrefix(route, "/api"), func(r rune) bool {
+		return r == '/' || r == ':' || r == '*' || r == '-' || r == '.'
+	}) {
+		id.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return id.String()
+}
+
+// marshalOpenAPI encodes the OpenAPI document once all routes are registered
+func marshalOpenAPI(routes gin.RoutesInfo) []byte {
+	spec, err := json.Marshal(openAPISpec(routes))
+	if err != nil {
+		panic(err)
+	}
+	return spec
+}
+
+// handleOpenAPI returns the OpenAPI document of the registered routes
+func (s *Server) handleOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", s.apiSpec)
+}
+
+// handleDocs serves the API explorer rendering the OpenAPI document
+func (s *Server) handleDocs(c *gin.Context) {
+	c.Header("Content-Type", "text/html")
+	c.String(http.StatusOK, docsHTML)
+}
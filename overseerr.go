@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OverseerrWebhook is the part of an Overseerr or Jellyseerr webhook
+// payload used for precaching. IDs are strings or numbers depending on the
+// payload template.
+type OverseerrWebhook struct {
+	NotificationType string `json:"notification_type"`
+	Subject          string `json:"subject"`
+	Media            struct {
+		MediaType string      `json:"media_type"`
+		TmdbID    interface{} `json:"tmdbId"`
+		TvdbID    interface{} `json:"tvdbId"`
+	} `json:"media"`
+	Extra []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"extra"`
+}
+
+// handleOverseerrWebhook precaches a requested title once it becomes
+// available. The folder is looked up in Radarr for movies and in Sonarr
+// for series, where only the requested seasons are cached if their folders
+// can be found.
+func (s *Server) handleOverseerrWebhook(c *gin.Context) {
+	var hook OverseerrWebhook
+	if err := c.ShouldBindJSON(&hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch hook.NotificationType {
+	case "TEST_NOTIFICATION":
+		c.JSON(http.StatusOK, gin.H{"message": "Test received"})
+		return
+	case "MEDIA_AVAILABLE":
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Ignored %s notification", hook.NotificationType)})
+		return
+	}
+
+	var folder string
+	var err error
+	switch hook.Media.MediaType {
+	case "movie":
+		if s.radarr == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Radarr lookup is not configured"})
+			return
+		}
+		folder, err = s.radarr.lookupPath("movie", "tmdbId", idString(hook.Media.TmdbID))
+	case "tv":
+		if s.sonarr == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Sonarr lookup is not configured"})
+			return
+		}
+		folder, err = s.sonarr.lookupPath("series", "tvdbId", idString(hook.Media.TvdbID))
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown media type %q", hook.Media.MediaType)})
+		return
+	}
+	if err != nil {
+		slog.Error("Error looking up Overseerr request", "subject", hook.Subject, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	reqPath, ok := s.externalPath(folder)
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("No path mapping for %s", folder)})
+		return
+	}
+	paths := []string{reqPath}
+	if seasons := s.seasonFolders(reqPath, hook.requestedSeasons()); len(seasons) > 0 {
+		paths = seasons
+	}
+
+	var ids []string
+	for _, p := range paths {
+		job, err := s.queuePath(p, originOf(c), s.defaultOptions(p))
+		if err != nil {
+			c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		ids = append(ids, job.ID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Caching %s", hook.Subject),
+		"job_ids": ids,
+	})
+}
+
+// requestedSeasons returns the season numbers listed in the payload extras
+func (hook OverseerrWebhook) requestedSeasons() []int {
+	var seasons []int
+	for _, extra := range hook.Extra {
+		if extra.Name != "Requested Seasons" {
+			continue
+		}
+		for _, field := range strings.Split(extra.Value, ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(field)); err == nil {
+				seasons = append(seasons, n)
+			}
+		}
+	}
+	return seasons
+}
+
+// seasonFolders returns the existing "Season N" or "Season 0N" folders of a
+// series for the given seasons
+func (s *Server) seasonFolders(seriesPath string, seasons []int) []string {
+	var folders []string
+	for _, season := range seasons {
+		for _, name := range []string{fmt.Sprintf("Season %d", season), fmt.Sprintf("Season %02d", season)} {
+			folder := path.Join(seriesPath, name)
+			sourcePath, _, err := s.paths(folder)
+			if err != nil {
+				return nil
+			}
+			if info, err := os.Stat(sourcePath); err == nil && info.IsDir() {
+				folders = append(folders, folder)
+				break
+			}
+		}
+	}
+	return folders
+}
+
+// idString formats an ID that may be encoded as a JSON string or number
+func idString(id interface{}) string {
+	switch v := id.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
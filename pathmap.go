@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fffonion/rclone-precache/pkg/pathutil"
+)
+
+// PathMapping rewrites paths reported by another application, such as a
+// Radarr root folder, to an API path
+type PathMapping struct {
+	From string
+	To   string
+}
+
+// PathMap translates external paths to API paths
+type PathMap []PathMapping
+
+// parsePathMap parses a comma separated list of from=to prefixes
+func parsePathMap(list string) (PathMap, error) {
+	var pm PathMap
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(entry, "=")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("invalid path mapping %q, want from=to", entry)
+		}
+		pm = append(pm, PathMapping{From: path.Clean(from), To: cleanPath(to)})
+	}
+	return pm, nil
+}
+
+// resolve maps an external path to an API path using the longest matching
+// prefix
+func (pm PathMap) resolve(external string) (string, bool) {
+	external = path.Clean(filepath.ToSlash(external))
+
+	best := -1
+	for i, m := range pm {
+		if pathutil.HasPrefix(external, m.From) && (best < 0 || len(m.From) > len(pm[best].From)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	m := pm[best]
+	return cleanPath(path.Join(m.To, strings.TrimPrefix(external, m.From))), true
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/fffonion/rclone-precache/pkg/pathutil"
+	"github.com/gin-gonic/gin"
+)
+
+var ErrPinNotFound = errors.New("path is not pinned")
+
+// Pin protects a file or directory in the cache from eviction
+type Pin struct {
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PinStore keeps pinned paths in pins.json
+type PinStore struct {
+	path string
+	pins map[string]Pin
+	mu   sync.RWMutex
+}
+
+// NewPinStore creates a store backed by pins.json inside dir
+func NewPinStore(dir string) *PinStore {
+	return &PinStore{
+		path: filepath.Join(dir, "pins.json"),
+		pins: make(map[string]Pin),
+	}
+}
+
+// Load reads saved pins, keeping none if the file is missing
+func (ps *PinStore) Load() error {
+	data, err := os.ReadFile(ps.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var pins []Pin
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, pin := range pins {
+		ps.pins[pin.Path] = pin
+	}
+	return nil
+}
+
+// list returns the pins sorted by path. Caller must hold the lock.
+func (ps *PinStore) list() []Pin {
+	pins := make([]Pin, 0, len(ps.pins))
+	for _, pin := range ps.pins {
+		pins = append(pins, pin)
+	}
+	sort.Slice(pins, func(i, k int) bool {
+		return pins[i].Path < pins[k].Path
+	})
+	return pins
+}
+
+// List returns all pins sorted by path
+func (ps *PinStore) List() []Pin {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.list()
+}
+
+// Add pins a path, keeping the original pin if it already exists
+func (ps *PinStore) Add(path string) (Pin, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if pin, exists := ps.pins[path]; exists {
+		return pin, nil
+	}
+	pin := Pin{Path: path, CreatedAt: time.Now()}
+	ps.pins[path] = pin
+	return pin, cache.SaveJSON(ps.path, ps.list())
+}
+
+// Remove unpins a path
+func (ps *PinStore) Remove(path string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, exists := ps.pins[path]; !exists {
+		return ErrPinNotFound
+	}
+	delete(ps.pins, path)
+	return cache.SaveJSON(ps.path, ps.list())
+}
+
+// covers reports whether path is pinned itself or lies below a pin
+func (ps *PinStore) covers(path string) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	for pinned := range ps.pins {
+		if pathutil.HasPrefix(path, pinned) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleListPins lists the pinned paths
+func (s *Server) handleListPins(c *gin.Context) {
+	c.JSON(http.StatusOK, s.pins.List())
+}
+
+// handlePin protects a mount-relative path from eviction
+func (s *Server) handlePin(c *gin.Context) {
+	reqPath := cleanPath(c.Param("path"))
+	if !s.allowPath(c, reqPath) {
+		return
+	}
+	if !s.exists(reqPath) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
+		return
+	}
+	pin, err := s.pins.Add(reqPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pin)
+}
+
+// handleUnpin lets a pinned path be evicted again
+func (s *Server) handleUnpin(c *gin.Context) {
+	reqPath := cleanPath(c.Param("path"))
+	if !s.allowPath(c, reqPath) {
+		return
+	}
+	err := s.pins.Remove(reqPath)
+	if errors.Is(err, ErrPinNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Unpinned %s", reqPath)})
+}
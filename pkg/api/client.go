@@ -0,0 +1,230 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/fffonion/rclone-precache/pkg/sizer"
+)
+
+// StatusError is returned for replies with a status other than 2xx
+type StatusError struct {
+	StatusCode int
+	Message    string // Error reported by the server, or the status text
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// Client calls the REST API of a running server
+type Client struct {
+	BaseURL    string // e.g. http://localhost:8000
+	APIKey     string
+	User       string // HTTP Basic auth, used without an API key
+	Password   string
+	HTTPClient *http.Client // http.DefaultClient if nil
+}
+
+// NewClient creates a client for the server at baseURL
+func NewClient(baseURL, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), APIKey: apiKey}
+}
+
+// Do sends a request with body, if not nil, encoded as JSON and decodes
+// a JSON reply into out, unless it is nil
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	u := c.BaseURL + (&url.URL{Path: path}).EscapedPath()
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-Api-Key", c.APIKey)
+	} else if c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var reply struct {
+			ErrorResponse
+			MessageResponse
+		}
+		json.NewDecoder(resp.Body).Decode(&reply)
+		message := reply.Error
+		if message == "" {
+			message = reply.Message
+		}
+		if message == "" {
+			message = resp.Status
+		}
+		return &StatusError{StatusCode: resp.StatusCode, Message: message}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiPath joins a mount-relative path below an API route
+func apiPath(route, p string) string {
+	return "/api/" + route + path.Clean("/"+p)
+}
+
+// Browse lists a directory with the bytes of each entry already cached.
+// Query takes the browse parameters, e.g. sort=size or q=name.
+func (c *Client) Browse(ctx context.Context, p string, query url.Values) ([]FileInfo, error) {
+	var entries []FileInfo
+	err := c.Do(ctx, http.MethodGet, apiPath("browse", p), query, nil, &entries)
+	return entries, err
+}
+
+// Stat describes one file or directory without listing its parent
+func (c *Client) Stat(ctx context.Context, p string) (PathInfo, error) {
+	var info PathInfo
+	err := c.Do(ctx, http.MethodGet, apiPath("stat", p), nil, nil, &info)
+	return info, err
+}
+
+// DiskUsage breaks the size of a directory down by its entries
+func (c *Client) DiskUsage(ctx context.Context, p string) (DiskUsage, error) {
+	var usage DiskUsage
+	err := c.Do(ctx, http.MethodGet, apiPath("du", p), nil, nil, &usage)
+	return usage, err
+}
+
+// Precache starts caching a file or directory. Options take the names of
+// the precache query parameters, e.g. mode=headtail or bwlimit=10M.
+func (c *Client) Precache(ctx context.Context, p string, options url.Values) (JobStarted, error) {
+	var reply JobStarted
+	err := c.Do(ctx, http.MethodPost, apiPath("precache", p), options, nil, &reply)
+	return reply, err
+}
+
+// PrecacheBatch starts one job per path, or none if any path fails
+func (c *Client) PrecacheBatch(ctx context.Context, req BatchPrecacheRequest) (JobsStarted, error) {
+	var reply JobsStarted
+	err := c.Do(ctx, http.MethodPost, "/api/precache", nil, req, &reply)
+	return reply, err
+}
+
+// Estimate reports what precaching a path with options would read
+func (c *Client) Estimate(ctx context.Context, p string, options url.Values) (cache.Estimate, error) {
+	var estimate cache.Estimate
+	err := c.Do(ctx, http.MethodGet, apiPath("estimate", p), options, nil, &estimate)
+	return estimate, err
+}
+
+// GlobalProgress returns the progress across all jobs
+func (c *Client) GlobalProgress(ctx context.Context) (cache.GlobalProgress, error) {
+	var progress cache.GlobalProgress
+	err := c.Do(ctx, http.MethodGet, "/api/cache-progress/", nil, nil, &progress)
+	return progress, err
+}
+
+// Jobs lists the tracked jobs, oldest first
+func (c *Client) Jobs(ctx context.Context) ([]*cache.Job, error) {
+	var jobs []*cache.Job
+	err := c.Do(ctx, http.MethodGet, "/api/jobs", nil, nil, &jobs)
+	return jobs, err
+}
+
+// Job looks up a job by ID
+func (c *Client) Job(ctx context.Context, id string) (*cache.Job, error) {
+	var job cache.Job
+	if err := c.Do(ctx, http.MethodGet, "/api/jobs/"+id, nil, nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// PauseJob pauses a running job, keeping its progress
+func (c *Client) PauseJob(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodPost, "/api/jobs/"+id+"/pause", nil, nil, nil)
+}
+
+// ResumeJob continues a paused job where it left off
+func (c *Client) ResumeJob(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodPost, "/api/jobs/"+id+"/resume", nil, nil, nil)
+}
+
+// CancelJob stops a queued or running job, or clears a finished one
+func (c *Client) CancelJob(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/jobs/"+id, nil, nil, nil)
+}
+
+// ClearJobs forgets all finished jobs and their summaries
+func (c *Client) ClearJobs(ctx context.Context) error {
+	return c.Do(ctx, http.MethodDelete, "/api/jobs", nil, nil, nil)
+}
+
+// TimeSeries returns throughput samples. Query takes the timeseries
+// parameters, e.g. range=24h or step=5m.
+func (c *Client) TimeSeries(ctx context.Context, query url.Values) (TimeSeries, error) {
+	var series TimeSeries
+	err := c.Do(ctx, http.MethodGet, "/api/stats/timeseries", query, nil, &series)
+	return series, err
+}
+
+// Usage returns the bytes read per period and mount. Query takes the
+// usage parameters, e.g. period=week or since=30d.
+func (c *Client) Usage(ctx context.Context, query url.Values) (UsageReport, error) {
+	var report UsageReport
+	err := c.Do(ctx, http.MethodGet, "/api/stats/usage", query, nil, &report)
+	return report, err
+}
+
+// History returns a page of finished jobs. Query takes the history
+// parameters, e.g. limit, offset, since or user.
+func (c *Client) History(ctx context.Context, query url.Values) (HistoryPage, error) {
+	var page HistoryPage
+	err := c.Do(ctx, http.MethodGet, "/api/history", query, nil, &page)
+	return page, err
+}
+
+// SizeCache returns the number of cached directory and file sizes
+func (c *Client) SizeCache(ctx context.Context) (sizer.Stats, error) {
+	var stats sizer.Stats
+	err := c.Do(ctx, http.MethodGet, "/api/sizecache", nil, nil, &stats)
+	return stats, err
+}
+
+// FlushSizeCache drops the cached sizes of p and everything below it, or
+// all of them if p is empty
+func (c *Client) FlushSizeCache(ctx context.Context, p string) error {
+	var query url.Values
+	if p != "" {
+		query = url.Values{"path": {p}}
+	}
+	return c.Do(ctx, http.MethodDelete, "/api/sizecache", query, nil, nil)
+}
@@ -0,0 +1,117 @@
+// Package api holds the request and response types of the rclone-precache
+// REST API and a Client for calling a running server from Go.
+package api
+
+import (
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+)
+
+// FileInfo is an entry of a directory listing
+type FileInfo struct {
+	Name        string  `json:"name"`
+	Path        string  `json:"path"`
+	IsDir       bool    `json:"is_dir"`
+	Size        *int64  `json:"size"` // nil for directories
+	CreatedTime float64 `json:"created_time"`
+	CachedSize  int64   `json:"cached_size"`
+}
+
+// PathInfo describes a single file or directory
+type PathInfo struct {
+	FileInfo
+	CachedPercent *float64 `json:"cached_percent"` // nil for directories
+	Caching       bool     `json:"caching"`        // An unfinished job covers the path
+	JobID         string   `json:"job_id,omitempty"`
+}
+
+// DiskUsage breaks the size of a directory down by its entries
+type DiskUsage struct {
+	Path       string           `json:"path"`
+	Size       int64            `json:"size"`
+	CachedSize int64            `json:"cached_size"`
+	Entries    []DiskUsageEntry `json:"entries"` // Largest first
+}
+
+// DiskUsageEntry is the size of one entry of a directory, including
+// everything below it
+type DiskUsageEntry struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	IsDir      bool   `json:"is_dir"`
+	Size       int64  `json:"size"`
+	CachedSize int64  `json:"cached_size"`
+}
+
+// BatchPrecacheRequest starts one job per path. Options take the same
+// names and formats as the precache query parameters.
+type BatchPrecacheRequest struct {
+	Paths   []string               `json:"paths"`
+	Options map[string]interface{} `json:"options"`
+}
+
+// ErrorResponse is the body of a failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// MessageResponse is the body of a request that changed something
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// JobStarted is the reply to a precache request
+type JobStarted struct {
+	Message string `json:"message"`
+	JobID   string `json:"job_id"`
+}
+
+// JobsStarted is the reply to a batch precache request
+type JobsStarted struct {
+	Message string   `json:"message"`
+	JobIDs  []string `json:"job_ids"`
+}
+
+// HistoryPage is one page of finished jobs
+type HistoryPage struct {
+	Total   int                   `json:"total"`
+	Offset  int                   `json:"offset"`
+	Limit   int                   `json:"limit"`
+	Records []cache.HistoryRecord `json:"records"`
+}
+
+// TimeSeries is the throughput of the last range, oldest first
+type TimeSeries struct {
+	Since   time.Time          `json:"since"`
+	Step    float64            `json:"step_seconds"`
+	Samples []cache.TimeSample `json:"samples"`
+}
+
+// UsageReport is the bytes read per day or week and per mount. Mounts
+// are keyed by name, "default" for a single unnamed mount.
+type UsageReport struct {
+	Period     string           `json:"period"` // day or week
+	Since      time.Time        `json:"since"`
+	Until      time.Time        `json:"until"`
+	TotalBytes int64            `json:"total_bytes"`
+	Mounts     map[string]int64 `json:"mounts"`
+	Periods    []UsagePeriod    `json:"periods"`
+}
+
+// UsagePeriod is the bytes read in one day or week, starting Monday
+type UsagePeriod struct {
+	Start  string           `json:"start"` // YYYY-MM-DD in the server's time zone
+	Bytes  int64            `json:"bytes"`
+	Jobs   int              `json:"jobs"`
+	Mounts map[string]int64 `json:"mounts"`
+}
+
+// QuotaReport is cache usage against the quota with the files evicted
+// first
+type QuotaReport struct {
+	Quota      int64              `json:"quota"`
+	Used       int64              `json:"used"`
+	Over       int64              `json:"over"`
+	Candidates []cache.CachedFile `json:"candidates"`
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Bounds of automatically tuned chunk sizes
+const (
+	adaptiveMinChunk   = 256 * 1024
+	adaptiveStartChunk = 1024 * 1024
+	adaptiveMaxChunk   = 16 * 1024 * 1024
+	tuneSamples        = 4 // Reads measured before each size change
+)
+
+// chunkTuner picks the read size for one file. A fixed tuner always returns
+// the configured size. An adaptive one starts small, doubles the size while
+// throughput keeps improving, halves it when throughput drops and after
+// read errors.
+type chunkTuner struct {
+	mu       sync.Mutex
+	size     int
+	fixed    bool
+	bytes    int64
+	elapsed  time.Duration
+	samples  int
+	lastRate float64 // Bytes per second measured at the previous size
+}
+
+// newChunkTuner returns a tuner for chunkSize bytes, 0 to tune automatically
+func newChunkTuner(chunkSize int) *chunkTuner {
+	if chunkSize > 0 {
+		return &chunkTuner{size: chunkSize, fixed: true}
+	}
+	return &chunkTuner{size: adaptiveStartChunk}
+}
+
+// next returns the size of the next read
+func (t *chunkTuner) next() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// observe records a read of n bytes that took elapsed
+func (t *chunkTuner) observe(n int, elapsed time.Duration) {
+	if t.fixed {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.bytes += int64(n)
+	t.elapsed += elapsed
+	t.samples++
+	if t.samples < tuneSamples || t.elapsed <= 0 {
+		return
+	}
+
+	rate := float64(t.bytes) / t.elapsed.Seconds()
+	switch {
+	case rate > t.lastRate*1.05 && t.size < adaptiveMaxChunk:
+		t.size *= 2
+	case rate < t.lastRate*0.9 && t.size > adaptiveMinChunk:
+		t.size /= 2
+	}
+	t.lastRate = rate
+	t.bytes, t.elapsed, t.samples = 0, 0, 0
+}
+
+// failed shrinks the read size after an error
+func (t *chunkTuner) failed() {
+	if t.fixed {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.size = max(t.size/2, adaptiveMinChunk)
+	t.lastRate = 0
+	t.bytes, t.elapsed, t.samples = 0, 0, 0
+}
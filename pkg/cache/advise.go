@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"os"
+	"time"
+)
+
+// adviseFileSegment warms [startPos, endPos) by asking the kernel to read it
+// ahead instead of copying it through userspace, chunk by chunk so that
+// pausing, progress and bandwidth limits work as for reads. It returns the
+// position reached.
+func (cm *Manager) adviseFileSegment(file *os.File, startPos, endPos int64, job *Job, tuner *chunkTuner, coverage *rangeCoverage) (int64, error) {
+	currentPos := startPos
+	defer job.flushBytes()
+
+	for currentPos < endPos {
+		if err := job.waitIfPaused(); err != nil {
+			return currentPos, err
+		}
+
+		length := min(int64(tuner.next()), endPos-currentPos)
+		adviseStart := time.Now()
+		err := adviseRange(file, currentPos, length)
+		tuner.observe(int(length), time.Since(adviseStart))
+		if err != nil {
+			return currentPos, err
+		}
+
+		cm.countBytes(job, coverage.add(currentPos, length))
+		currentPos += length
+
+		limiter := cm.bwlimit
+		if job.limiter != nil {
+			limiter = job.limiter
+		}
+		if err := limiter.Wait(job.ctx, int(length)); err != nil {
+			return currentPos, err
+		}
+	}
+
+	return currentPos, nil
+}
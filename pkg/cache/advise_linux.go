@@ -0,0 +1,18 @@
+//go:build linux
+
+package cache
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// adviseSupported reports whether the advise strategy is available
+const adviseSupported = true
+
+// adviseRange asks the kernel to read length bytes at offset into the page
+// cache with posix_fadvise(POSIX_FADV_WILLNEED)
+func adviseRange(file *os.File, offset, length int64) error {
+	return unix.Fadvise(int(file.Fd()), offset, length, unix.FADV_WILLNEED)
+}
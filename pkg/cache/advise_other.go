@@ -0,0 +1,17 @@
+//go:build !linux
+
+package cache
+
+import (
+	"errors"
+	"os"
+)
+
+// adviseSupported reports whether the advise strategy is available
+const adviseSupported = false
+
+// adviseRange is not available on this platform; validate rejects the
+// advise strategy before a job can reach it
+func adviseRange(file *os.File, offset, length int64) error {
+	return errors.New("posix_fadvise not supported on this platform")
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting bytes per second. Readers may take
+// more tokens than are available and then wait for the debt to refill, so
+// chunks larger than the burst still pass. A zero rate means unlimited.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second, 0 for unlimited
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter for bytesPerSec, 0 for unlimited
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetRate(bytesPerSec)
+	return l
+}
+
+// SetRate changes the limit, 0 for unlimited
+func (l *RateLimiter) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(bytesPerSec)
+	l.tokens = l.rate
+	l.last = time.Now()
+}
+
+// Rate returns the limit in bytes per second, 0 for unlimited
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate)
+}
+
+// Wait takes n tokens, blocking until the bucket is no longer in debt or
+// ctx is cancelled
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	// The bucket holds at most one second of tokens
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
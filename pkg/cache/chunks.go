@@ -0,0 +1,52 @@
+package cache
+
+// maxChunkMapEntries bounds the default chunk map resolution
+const maxChunkMapEntries = 256
+
+// ChunkInfo describes whether one fixed-size chunk of a file is cached
+type ChunkInfo struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+	Cached bool  `json:"cached"`
+}
+
+// ChunkMap is the cache map of a single file
+type ChunkMap struct {
+	Path        string      `json:"path"`
+	Size        int64       `json:"size"`
+	ChunkSize   int64       `json:"chunk_size"`
+	CachedBytes int64       `json:"cached_bytes"`
+	Ranges      []ByteRange `json:"ranges"`
+	Chunks      []ChunkInfo `json:"chunks"`
+}
+
+// BuildChunkMap marks each chunk of a size-byte file as cached when the
+// cached ranges cover it completely
+func BuildChunkMap(size, chunkSize int64, cached []ByteRange) []ChunkInfo {
+	chunks := make([]ChunkInfo, 0, (size+chunkSize-1)/chunkSize)
+	i := 0
+	for offset := int64(0); offset < size; offset += chunkSize {
+		chunk := ChunkInfo{Offset: offset, Length: min(chunkSize, size-offset)}
+		end := chunk.Offset + chunk.Length
+
+		// Skip ranges that end before this chunk
+		for i < len(cached) && cached[i].End() <= chunk.Offset {
+			i++
+		}
+		covered := chunk.Offset
+		for k := i; k < len(cached) && cached[k].Offset <= covered && covered < end; k++ {
+			covered = max(covered, cached[k].End())
+		}
+		chunk.Cached = covered >= end
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// DefaultChunkSize picks a 1MB-aligned chunk size giving at most
+// maxChunkMapEntries chunks
+func DefaultChunkSize(size int64) int64 {
+	const mb = 1024 * 1024
+	chunkSize := (size + maxChunkMapEntries - 1) / maxChunkMapEntries
+	return max((chunkSize+mb-1)/mb*mb, mb)
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// diskCheckInterval is how often running jobs check free cache space
+const diskCheckInterval = 10 * time.Second
+
+var (
+	// ErrLowDiskSpace is returned when a job would leave less free cache
+	// space than the configured minimum
+	ErrLowDiskSpace = errors.New("not enough free space in the cache directory")
+	// errFreeSpaceUnsupported is returned where free space can't be queried
+	errFreeSpaceUnsupported = errors.New("free space detection not supported on this platform")
+)
+
+// availableSpace returns the free bytes of the filesystem holding path,
+// using its nearest existing ancestor since cache directories are created
+// lazily
+func availableSpace(path string) (int64, error) {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return freeSpace(path)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return 0, fmt.Errorf("no existing directory above %s", path)
+		}
+		path = parent
+	}
+}
+
+// SetMinFree sets the free cache space jobs must leave. Jobs that would
+// drop below it are refused, and running jobs are paused while free space
+// stays below it.
+func (cm *Manager) SetMinFree(bytes int64) {
+	cm.Lock()
+	start := cm.minFree == 0 && bytes > 0
+	cm.minFree = bytes
+	cm.Unlock()
+
+	if start {
+		go cm.diskSpaceLoop()
+	}
+}
+
+// checkSpace refuses jobs whose planned size would leave less than the
+// minimum free space. Caller must hold the lock.
+func (cm *Manager) checkSpace(jobs []*Job) error {
+	if cm.minFree <= 0 || len(jobs) == 0 {
+		return nil
+	}
+	free, err := availableSpace(jobs[0].cachePath)
+	if err != nil {
+		// Without a reading the guard stays out of the way
+		return nil
+	}
+	var planned int64
+	for _, job := range jobs {
+		planned += job.TotalSize
+	}
+	if free-planned < cm.minFree {
+		return fmt.Errorf("%w: %d bytes free, %d planned", ErrLowDiskSpace, free, planned)
+	}
+	return nil
+}
+
+// diskSpaceLoop pauses running jobs while free cache space is below the
+// minimum and resumes them once space is available again
+func (cm *Manager) diskSpaceLoop() {
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cm.RLock()
+		minFree := cm.minFree
+		jobs := make([]*Job, 0, len(cm.jobs))
+		for _, job := range cm.jobs {
+			jobs = append(jobs, job)
+		}
+		cm.RUnlock()
+		if minFree <= 0 {
+			continue
+		}
+
+		for _, job := range jobs {
+			free, err := availableSpace(job.cachePath)
+			if err != nil {
+				continue
+			}
+			low := free < minFree
+			progress := job.Progress()
+			switch {
+			case low && progress.State == StateRunning:
+				if job.pauseForSpace() == nil {
+					job.Log().Warn("Paused job, cache is low on space", "free_bytes", free)
+					cm.publishJob(job)
+				}
+			case !low && progress.State == StatePaused && progress.LowDiskSpace:
+				if job.resume() == nil {
+					job.Log().Info("Resumed job, cache has space again", "free_bytes", free)
+					cm.publishJob(job)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package cache
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem holding path. NetBSD only has statvfs, whose free block count
+// is in fragment size units.
+func freeSpace(path string) (int64, error) {
+	var stat unix.Statvfs_t
+	if err := unix.Statvfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Frsize), nil
+}
@@ -0,0 +1,13 @@
+package cache
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem holding path. OpenBSD prefixes its statfs fields with f_.
+func freeSpace(path string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.F_bavail * int64(stat.F_bsize), nil
+}
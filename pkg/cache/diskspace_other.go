@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package cache
+
+// freeSpace is not available on this platform, so the free space guard
+// stays disabled
+func freeSpace(path string) (int64, error) {
+	return 0, errFreeSpaceUnsupported
+}
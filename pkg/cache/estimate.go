@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// throughputSamples is how many recent history records the estimate
+// falls back to when no job is running
+const throughputSamples = 10
+
+// Estimate describes what a precache request would read, without reading
+type Estimate struct {
+	Path           string   `json:"path"`
+	Files          int      `json:"files"`
+	TotalBytes     int64    `json:"total_bytes"`
+	CachedBytes    int64    `json:"cached_bytes"`
+	BytesRemaining int64    `json:"bytes_remaining"`
+	Throughput     float64  `json:"throughput"`
+	ETASeconds     *float64 `json:"eta_seconds"`
+}
+
+// Estimate walks sourcePath with the job's filters and totals the bytes a
+// job would cache and how many of them are already in the cache
+func (cm *Manager) Estimate(path, sourcePath, cachePath string, opts JobOptions) (Estimate, error) {
+	estimate := Estimate{Path: path}
+	if _, err := os.Stat(sourcePath); err != nil {
+		return estimate, err
+	}
+
+	add := func(filePath string, size int64) {
+		wanted := opts.wantedRanges(size)
+		estimate.Files++
+		estimate.TotalBytes += RangesLength(wanted)
+
+		relPath, err := filepath.Rel(sourcePath, filePath)
+		if err != nil {
+			return
+		}
+		if cached, err := cm.CachedRanges(filepath.Join(cachePath, relPath)); err == nil {
+			estimate.CachedBytes += RangesLength(wanted) - RangesLength(subtractRanges(wanted, cached))
+		}
+	}
+
+	err := cm.walkJobFiles(sourcePath, opts, func(filePath string, info os.FileInfo, err error) error {
+		if err == nil {
+			add(filePath, info.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		return estimate, err
+	}
+	for _, sidecar := range cm.findSidecars(sourcePath) {
+		if info, err := os.Stat(sidecar); err == nil {
+			add(sidecar, info.Size())
+		}
+	}
+
+	estimate.Throughput = cm.recentThroughput()
+	estimate.BytesRemaining, estimate.ETASeconds = estimateRemaining(estimate.TotalBytes, estimate.CachedBytes, estimate.Throughput)
+	return estimate, nil
+}
+
+// recentThroughput returns the current total speed, or the average speed of
+// the most recently finished jobs when nothing is running
+func (cm *Manager) recentThroughput() float64 {
+	if speed := cm.GetGlobalProgress().TotalSpeed; speed > 0 {
+		return speed
+	}
+
+	records, _, err := cm.QueryHistory(HistoryQuery{Limit: throughputSamples})
+	if err != nil {
+		return 0
+	}
+	var bytes int64
+	var seconds float64
+	for _, record := range records {
+		if record.Duration > 0 {
+			bytes += record.TotalBytes
+			seconds += record.Duration
+		}
+	}
+	if seconds == 0 {
+		return 0
+	}
+	return float64(bytes) / seconds
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event types pushed to live subscribers
+const (
+	EventJob      = "job"      // A job changed state
+	EventComplete = "complete" // A job finished, carries its history record
+	EventCleared  = "cleared"  // A finished job is no longer tracked, carries its ID
+	EventProgress = "progress" // Periodic global and per-job progress
+	EventSpeed    = "speed"    // Per-second speed sample
+)
+
+// Event is a notification pushed to live subscribers
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// ProgressEvent carries global progress together with every tracked job
+type ProgressEvent struct {
+	Global GlobalProgress `json:"global"`
+	Jobs   []*Job         `json:"jobs"`
+}
+
+// SpeedSample is the aggregate and per-job read speed at one instant
+type SpeedSample struct {
+	Time       time.Time          `json:"time"`
+	TotalSpeed float64            `json:"total_speed"`
+	Jobs       map[string]float64 `json:"jobs"`
+}
+
+// EventHub fans out events to any number of subscribers
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewEventHub creates an EventHub without subscribers
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving all future events
+func (h *EventHub) Subscribe() chan Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Event, 64)
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe stops delivery to ch and closes it
+func (h *EventHub) Unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.subscribers[ch]; exists {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// HasSubscribers reports whether anyone is listening
+func (h *EventHub) HasSubscribers() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers) > 0
+}
+
+// Publish delivers an event to all subscribers. Slow subscribers miss
+// events rather than blocking the publisher.
+func (h *EventHub) Publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// publishJob announces a job state change. The job is encoded right away
+// so subscribers see the state at the time of the transition.
+func (cm *Manager) publishJob(job *Job) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+	cm.events.Publish(Event{Type: EventJob, Data: json.RawMessage(data)})
+}
+
+// progressChanged wakes progressLoop without blocking the reader
+func (cm *Manager) progressChanged() {
+	select {
+	case cm.updated <- struct{}{}:
+	default:
+	}
+}
+
+// progressLoop publishes a progress snapshot whenever job progress changes,
+// at most once per progressInterval
+func (cm *Manager) progressLoop() {
+	var lastPublish time.Time
+	for range cm.updated {
+		if wait := progressInterval - time.Since(lastPublish); wait > 0 {
+			time.Sleep(wait)
+		}
+		lastPublish = time.Now()
+
+		if !cm.events.HasSubscribers() {
+			continue
+		}
+		jobs := cm.ListJobs()
+		if len(jobs) == 0 {
+			continue
+		}
+		global := cm.GetGlobalProgress()
+		cm.events.Publish(Event{
+			Type: EventProgress,
+			Data: ProgressEvent{
+				Global: global,
+				Jobs:   jobs,
+			},
+		})
+
+		sample := SpeedSample{
+			Time:       time.Now(),
+			TotalSpeed: global.TotalSpeed,
+			Jobs:       make(map[string]float64, len(jobs)),
+		}
+		for _, job := range jobs {
+			if progress := job.Progress(); progress.State == StateRunning {
+				sample.Jobs[job.ID] = progress.CurrentSpeed
+			}
+		}
+		cm.events.Publish(Event{Type: EventSpeed, Data: sample})
+	}
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// DefaultSkipExtensions mark partial downloads and temporary files
+var DefaultSkipExtensions = []string{".partial", ".part", ".tmp", ".!qb", ".crdownload"}
+
+// ExtensionRules are the server-wide extension lists. Extensions are
+// lowercase and start with a dot.
+type ExtensionRules struct {
+	Sidecars []string // Cached alongside a precached video
+	Skip     []string // Never cached by directory jobs
+	Allow    []string // If set, directory jobs cache only these
+}
+
+// Allowed reports whether a directory job may cache the file at path
+func (r ExtensionRules) Allowed(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if hasExtension(r.Skip, ext) {
+		return false
+	}
+	return len(r.Allow) == 0 || hasExtension(r.Allow, ext)
+}
+
+// restricts reports whether the rules can exclude any file
+func (r ExtensionRules) restricts() bool {
+	return len(r.Skip) > 0 || len(r.Allow) > 0
+}
+
+// hasExtension reports whether ext is in exts
+func hasExtension(exts []string, ext string) bool {
+	for _, want := range exts {
+		if ext == want {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseExtensions splits a comma separated extension list, adding the
+// leading dot where missing
+func ParseExtensions(list string) []string {
+	var exts []string
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
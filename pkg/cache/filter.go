@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// pathFilter selects which files of a directory job are cached. Paths are
+// relative to the job root and use forward slashes. A nil filter selects
+// everything.
+type pathFilter struct {
+	include     []*regexp.Regexp
+	exclude     []*regexp.Regexp
+	excludeDirs []*regexp.Regexp // Directories whose whole contents are excluded
+	regex       *regexp.Regexp   // Searched for in the relative path
+	minSize     int64
+	maxSize     int64 // 0 means no upper limit
+	newerThan   time.Time
+	maxDepth    int // Directory levels below the root to descend, -1 for all
+}
+
+// newPathFilter compiles the filters in opts, returning nil if there are none
+func newPathFilter(opts JobOptions) (*pathFilter, error) {
+	if len(opts.Include) == 0 && len(opts.Exclude) == 0 && opts.Regex == "" &&
+		opts.MinSize == 0 && opts.MaxSize == 0 && opts.NewerThan == nil && opts.Depth == nil {
+		return nil, nil
+	}
+
+	f := &pathFilter{minSize: opts.MinSize, maxSize: opts.MaxSize, maxDepth: -1}
+	if opts.Depth != nil {
+		f.maxDepth = *opts.Depth
+	}
+	if opts.NewerThan != nil {
+		f.newerThan = *opts.NewerThan
+	}
+	if opts.Regex != "" {
+		re, err := regexp.Compile(opts.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+		f.regex = re
+	}
+	for _, glob := range opts.Include {
+		re, err := globToRegexp(glob)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, re)
+	}
+	for _, glob := range opts.Exclude {
+		re, err := globToRegexp(glob)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, re)
+
+		// "dir/**" and "dir/" exclude the directory itself, so it need not be walked
+		if dir, ok := strings.CutSuffix(glob, "/**"); ok && dir != "" {
+			glob = dir + "/"
+		}
+		if dir, ok := strings.CutSuffix(glob, "/"); ok && dir != "" {
+			re, err := globToRegexp(dir)
+			if err != nil {
+				return nil, err
+			}
+			f.excludeDirs = append(f.excludeDirs, re)
+		}
+	}
+	return f, nil
+}
+
+// matchFile reports whether the file at relPath should be cached
+func (f *pathFilter) matchFile(relPath string, info os.FileInfo) bool {
+	if f == nil {
+		return true
+	}
+	if info.Size() < f.minSize || (f.maxSize > 0 && info.Size() > f.maxSize) {
+		return false
+	}
+	if !info.ModTime().After(f.newerThan) {
+		return false
+	}
+	if f.regex != nil && !f.regex.MatchString(relPath) {
+		return false
+	}
+	for _, re := range f.exclude {
+		if re.MatchString(relPath) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipDir reports whether the directory at relPath is excluded entirely
+func (f *pathFilter) skipDir(relPath string) bool {
+	if f == nil {
+		return false
+	}
+	if f.maxDepth >= 0 && strings.Count(relPath, "/")+1 > f.maxDepth {
+		return true
+	}
+	for _, re := range f.excludeDirs {
+		if re.MatchString(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp converts an rclone style filter glob into a regexp. A glob
+// starting with "/" is anchored at the job root, otherwise it matches the
+// end of the path, so "*.mkv" matches at any depth. "*" and "?" stop at
+// "/", "**" does not, "{a,b}" matches either alternative and "[...]" is a
+// character class, negated by a leading "!". A trailing "/" matches
+// everything inside a directory.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	if strings.HasPrefix(glob, "/") {
+		re.WriteString("^")
+		glob = glob[1:]
+	} else {
+		re.WriteString("(^|/)")
+	}
+	if strings.HasSuffix(glob, "/") {
+		glob += "**"
+	}
+
+	inBraces := false
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '\\':
+			if i+1 == len(glob) {
+				return nil, fmt.Errorf("glob %q ends with an escape", glob)
+			}
+			i++
+			re.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("glob %q has an unclosed [", glob)
+			}
+			class := glob[i+1 : i+end+1]
+			// "[!...]" negates a class, which like "?" stays within a segment
+			if strings.HasPrefix(class, "!") {
+				class = "^/" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+		case '{':
+			if inBraces {
+				return nil, fmt.Errorf("glob %q has nested {", glob)
+			}
+			inBraces = true
+			re.WriteString("(")
+		case '}':
+			if !inBraces {
+				return nil, fmt.Errorf("glob %q has an unmatched }", glob)
+			}
+			inBraces = false
+			re.WriteString(")")
+		case ',':
+			if inBraces {
+				re.WriteString("|")
+			} else {
+				re.WriteString(",")
+			}
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if inBraces {
+		return nil, fmt.Errorf("glob %q has an unclosed {", glob)
+	}
+	re.WriteString("$")
+
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %v", glob, err)
+	}
+	return compiled, nil
+}
+
+// walkJobFiles calls fn for every file under sourcePath selected by the
+// job's filters and the server's extension rules, skipping excluded
+// directories. A file root is always visited, and walk errors are passed to
+// fn as with filepath.Walk.
+func (cm *Manager) walkJobFiles(sourcePath string, opts JobOptions, fn filepath.WalkFunc) error {
+	return filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || (path == sourcePath && !info.IsDir()) {
+			return fn(path, info, err)
+		}
+		relPath, err := filepath.Rel(sourcePath, path)
+		if err != nil {
+			return fn(path, info, err)
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if info.IsDir() {
+			if path != sourcePath && opts.filter.skipDir(relPath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !cm.extensions.Allowed(path) || !opts.filter.matchFile(relPath, info) {
+			return nil
+		}
+		return fn(path, info, nil)
+	})
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/pathutil"
+)
+
+// Limits of QueueStalled
+const (
+	// queueStallTimeout is how long a job may wait in the queue while a
+	// slot is free before the queue counts as stuck
+	queueStallTimeout = time.Minute
+	// lockTimeout is how long the readiness check waits for the job
+	// manager before reporting it deadlocked
+	lockTimeout = 5 * time.Second
+)
+
+// QueueStalled returns an error if the job manager can't be locked or a
+// job has waited in the queue past queueStallTimeout with a slot free
+func (cm *Manager) QueueStalled() error {
+	locked := make(chan error, 1)
+	go func() {
+		cm.RLock()
+		defer cm.RUnlock()
+		if cm.readOnly || (cm.maxJobs > 0 && cm.running >= cm.maxJobs) {
+			locked <- nil
+			return
+		}
+		for _, job := range cm.queue {
+			if waited := time.Since(job.CreatedAt); waited > queueStallTimeout {
+				locked <- fmt.Errorf("job %s queued for %s with a free slot", job.ID, waited.Round(time.Second))
+				return
+			}
+		}
+		locked <- nil
+	}()
+
+	select {
+	case err := <-locked:
+		return err
+	case <-time.After(lockTimeout):
+		return fmt.Errorf("job manager unresponsive for %s", lockTimeout)
+	}
+}
+
+// MountChanged pauses running jobs reading from mountPath while it is down
+// and resumes them once it is back
+func (cm *Manager) MountChanged(mountPath string, healthy bool) {
+	cm.RLock()
+	jobs := make([]*Job, 0, len(cm.jobs))
+	for _, job := range cm.jobs {
+		if pathutil.HasPrefix(filepath.ToSlash(job.sourcePath), filepath.ToSlash(filepath.Clean(mountPath))) {
+			jobs = append(jobs, job)
+		}
+	}
+	cm.RUnlock()
+
+	for _, job := range jobs {
+		progress := job.Progress()
+		switch {
+		case !healthy && progress.State == StateRunning:
+			if job.pauseForMount() == nil {
+				job.Log().Warn("Paused job while the mount is down")
+				cm.publishJob(job)
+			}
+		case healthy && progress.State == StatePaused && progress.MountDown:
+			if job.resume() == nil {
+				job.Log().Info("Resumed job")
+				cm.publishJob(job)
+			}
+		}
+	}
+}
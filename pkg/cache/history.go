@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// HistoryRecord summarizes a finished job
+type HistoryRecord struct {
+	ID           string     `json:"id"`
+	Path         string     `json:"path"`
+	State        JobState   `json:"state"`
+	TotalBytes   int64      `json:"total_bytes"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   time.Time  `json:"finished_at"`
+	Duration     float64    `json:"duration_seconds"`
+	AverageSpeed float64    `json:"average_speed"`
+	Errors       []JobError `json:"errors"`
+	ClientIP     string     `json:"client_ip"`
+	User         string     `json:"user,omitempty"`
+	RequestID    string     `json:"request_id,omitempty"`
+}
+
+// HistoryQuery selects a page of history records
+type HistoryQuery struct {
+	Since  time.Time
+	Until  time.Time
+	User   string                 // Only jobs started by this user, empty for all
+	Paths  func(path string) bool // Only jobs whose paths it accepts, nil for all
+	Offset int
+	Limit  int
+}
+
+// HistoryStore keeps finished jobs in an append-only JSON lines file
+type HistoryStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewHistoryStore creates a store backed by history.jsonl inside dir
+func NewHistoryStore(dir string) *HistoryStore {
+	return &HistoryStore{path: filepath.Join(dir, "history.jsonl")}
+}
+
+// Append adds a finished job to the history file
+func (hs *HistoryStore) Append(record HistoryRecord) error {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(hs.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(hs.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Query returns matching records, newest first, along with the total
+// number of matches before pagination
+func (hs *HistoryStore) Query(q HistoryQuery) ([]HistoryRecord, int, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	f, err := os.Open(hs.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []HistoryRecord{}, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var matches []HistoryRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if !q.Since.IsZero() && record.FinishedAt.Before(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && record.FinishedAt.After(q.Until) {
+			continue
+		}
+		if q.User != "" && record.User != q.User {
+			continue
+		}
+		if q.Paths != nil && !q.Paths(record.Path) {
+			continue
+		}
+		matches = append(matches, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Records are appended in completion order, so reverse for newest first
+	for i, k := 0, len(matches)-1; i < k; i, k = i+1, k-1 {
+		matches[i], matches[k] = matches[k], matches[i]
+	}
+
+	total := len(matches)
+	if q.Offset >= total {
+		return []HistoryRecord{}, total, nil
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matches) {
+		matches = matches[:q.Limit]
+	}
+	return matches, total, nil
+}
@@ -0,0 +1,531 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/tracing"
+)
+
+// JobState describes where a precache job is in its lifecycle
+type JobState string
+
+const (
+	StateQueued    JobState = "queued"
+	StateRunning   JobState = "running"
+	StatePaused    JobState = "paused"
+	StateComplete  JobState = "complete"
+	StateCancelled JobState = "cancelled"
+)
+
+// maxJobErrors caps how many errors are kept per job; ErrorCount keeps
+// counting past it
+const maxJobErrors = 100
+
+// JobError is a failure encountered while caching one file of a job
+type JobError struct {
+	Path    string    `json:"path"`
+	Error   string    `json:"error"`
+	Time    time.Time `json:"time"`
+	Retries int       `json:"retries"`
+}
+
+// progressInterval limits how often reader updates are folded into the
+// published progress counters
+const progressInterval = time.Second
+
+var (
+	ErrJobNotFound   = errors.New("no active cache operation found")
+	ErrJobNotRunning = errors.New("cache operation is not running")
+	ErrJobNotPaused  = errors.New("cache operation is not paused")
+	ErrJobFinished   = errors.New("cache operation already finished")
+	ErrJobUnfinished = errors.New("cache operation has not finished")
+	ErrJobExists     = errors.New("precache already in progress")
+	ErrReadOnly      = errors.New("server is read-only")
+	ErrShuttingDown  = errors.New("server is shutting down")
+)
+
+// CompletionSummary describes a finished job
+type CompletionSummary struct {
+	BytesRead    int64   `json:"bytes_read"`
+	SkippedBytes int64   `json:"skipped_bytes"` // Found already cached
+	WallTime     float64 `json:"wall_time_seconds"`
+	AverageSpeed float64 `json:"average_speed"`
+	PeakSpeed    float64 `json:"peak_speed"`
+	FilesCached  int     `json:"files_cached"`
+	FilesSkipped int     `json:"files_skipped"` // Already cached, also by an earlier run
+	FilesFailed  int     `json:"files_failed"`
+	ErrorCount   int     `json:"error_count"`
+}
+
+// fileOutcome is how caching one file of a job ended
+type fileOutcome int
+
+const (
+	fileCached fileOutcome = iota
+	fileSkipped
+	fileFailed
+)
+
+// Job is a single precache request for a file or directory
+type Job struct {
+	ID         string     `json:"id"`
+	Path       string     `json:"path"`
+	Options    JobOptions `json:"options"`
+	ClientIP   string     `json:"client_ip,omitempty"`
+	User       string     `json:"user,omitempty"` // Who started the job, when authenticated
+	RequestID  string     `json:"request_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Preempted  bool       `json:"preempted,omitempty"` // Paused to free a slot for a higher priority job
+	CacheProgress
+	Summary *CompletionSummary `json:"summary,omitempty"` // Set once the job finished
+
+	sourcePath   string
+	cachePath    string                     // Mirror of sourcePath inside the cache directory
+	files        map[string]*FileCheckpoint // Per-file checkpoints keyed by path relative to sourcePath
+	reading      map[string]*rangeCoverage  // Ranges read of files being cached, keyed like files
+	buffer       []byte                     // Buffer for reading file data
+	speedWindows []speedWindow              // Track speed history
+	peakSpeed    float64
+	fileCounts   [fileFailed + 1]int // Files by outcome
+	pendingBytes int64               // Bytes read since the last published update
+	lastUpdate   time.Time
+	onUpdate     func()              // Called after published progress changes
+	limiter      *RateLimiter        // Own bandwidth limit, nil to share the global one
+	trace        tracing.SpanContext // Span of the request that started the job
+	resumeCh     chan struct{}       // Closed when a paused job is resumed
+	ctx          context.Context
+	cancel       context.CancelFunc
+	mu           sync.Mutex // Mutex for thread-safe updates
+}
+
+// NewID returns a random RFC 4122 version 4 UUID
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// MarshalJSON encodes the job while holding its lock
+func (j *Job) MarshalJSON() ([]byte, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.updateEstimate()
+	type job Job
+	return json.Marshal((*job)(j))
+}
+
+// Progress returns a copy of the job's progress counters
+func (j *Job) Progress() CacheProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	progress := j.CacheProgress
+	progress.Errors = append([]JobError(nil), j.Errors...)
+	progress.updateEstimate()
+	return progress
+}
+
+// Timestamps returns when the job started and finished, nil until then
+func (j *Job) Timestamps() (started, finished *time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.StartedAt, j.FinishedAt
+}
+
+// Log returns a logger tagging records with the job, its path and who
+// started it
+func (j *Job) Log() *slog.Logger {
+	logger := slog.With("job", j.ID, "path", j.Path)
+	if j.User != "" {
+		logger = logger.With("user", j.User)
+	}
+	if j.RequestID != "" {
+		logger = logger.With("request_id", j.RequestID)
+	}
+	return logger
+}
+
+// record returns the persisted form of the job
+func (j *Job) record() JobRecord {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	files := make(map[string]*FileCheckpoint, len(j.files)+len(j.reading))
+	for name, checkpoint := range j.files {
+		cp := *checkpoint
+		files[name] = &cp
+	}
+	for name, coverage := range j.reading {
+		ranges := coverage.snapshot()
+		files[name] = &FileCheckpoint{BytesDone: RangesLength(ranges), Ranges: ranges}
+	}
+	return JobRecord{
+		ID:         j.ID,
+		Path:       j.Path,
+		SourcePath: j.sourcePath,
+		CachePath:  j.cachePath,
+		CreatedAt:  j.CreatedAt,
+		State:      j.State,
+		Options:    j.Options,
+		ClientIP:   j.ClientIP,
+		User:       j.User,
+		RequestID:  j.RequestID,
+		Files:      files,
+	}
+}
+
+// historyRecord summarizes the finished job for the history store
+func (j *Job) historyRecord() HistoryRecord {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	record := HistoryRecord{
+		ID:         j.ID,
+		Path:       j.Path,
+		State:      j.State,
+		TotalBytes: j.TotalBytesRead,
+		StartedAt:  j.CreatedAt,
+		Errors:     append([]JobError{}, j.Errors...),
+		ClientIP:   j.ClientIP,
+		User:       j.User,
+		RequestID:  j.RequestID,
+	}
+	if j.StartedAt != nil {
+		record.StartedAt = *j.StartedAt
+	}
+	if j.FinishedAt != nil {
+		record.FinishedAt = *j.FinishedAt
+	}
+	record.Duration = record.FinishedAt.Sub(record.StartedAt).Seconds()
+	if record.Duration > 0 {
+		record.AverageSpeed = float64(record.TotalBytes) / record.Duration
+	}
+	return record
+}
+
+// addError records a failure encountered while caching
+func (j *Job) addError(path string, err error, retries int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.ErrorCount++
+	if len(j.Errors) < maxJobErrors {
+		j.Errors = append(j.Errors, JobError{
+			Path:    path,
+			Error:   err.Error(),
+			Time:    time.Now(),
+			Retries: retries,
+		})
+	}
+}
+
+// fileDone reports whether a file was fully read in an earlier run
+func (j *Job) fileDone(relPath string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	checkpoint, exists := j.files[relPath]
+	return exists && checkpoint.Complete
+}
+
+// countFile records how caching one file ended
+func (j *Job) countFile(outcome fileOutcome) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.fileCounts[outcome]++
+}
+
+// startFile returns the coverage of a file about to be cached, holding the
+// ranges an earlier run read of it. The ranges are saved with the job's
+// checkpoints until the file ends.
+func (j *Job) startFile(relPath string) *rangeCoverage {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	coverage := &rangeCoverage{}
+	if checkpoint, exists := j.files[relPath]; exists && !checkpoint.Complete {
+		coverage.ranges = append([]ByteRange(nil), checkpoint.Ranges...)
+	}
+	j.reading[relPath] = coverage
+	return coverage
+}
+
+// endFile checkpoints the ranges read of a file that failed
+func (j *Job) endFile(relPath string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	coverage, exists := j.reading[relPath]
+	if !exists {
+		return
+	}
+	delete(j.reading, relPath)
+	ranges := coverage.snapshot()
+	j.files[relPath] = &FileCheckpoint{BytesDone: RangesLength(ranges), Ranges: ranges}
+}
+
+// markFileDone checkpoints a fully read file
+func (j *Job) markFileDone(relPath string, size int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.reading, relPath)
+	j.files[relPath] = &FileCheckpoint{BytesDone: size, Complete: true}
+}
+
+// updateSpeed calculates the average speed over the last 5 seconds
+func (j *Job) updateSpeed(bytesRead int64, currentTime time.Time) {
+	// Add new window
+	j.speedWindows = append(j.speedWindows, speedWindow{
+		bytesRead: bytesRead,
+		timestamp: currentTime,
+	})
+
+	// Remove windows older than 5 seconds
+	cutoffTime := currentTime.Add(-5 * time.Second)
+	var validWindows []speedWindow
+	var totalBytes int64
+
+	for _, window := range j.speedWindows {
+		if window.timestamp.After(cutoffTime) {
+			validWindows = append(validWindows, window)
+			totalBytes += window.bytesRead
+		}
+	}
+
+	j.speedWindows = validWindows
+
+	// Calculate average speed over the valid windows
+	if len(validWindows) > 0 {
+		timeRange := currentTime.Sub(validWindows[0].timestamp).Seconds()
+		if timeRange > 0 {
+			j.CurrentSpeed = float64(totalBytes) / timeRange
+			j.peakSpeed = max(j.peakSpeed, j.CurrentSpeed)
+		}
+	}
+}
+
+// addBytes records bytes read by a segment reader. Readers call it for
+// every chunk; the published counters and speed are refreshed at most once
+// per progressInterval so snapshots stay cheap.
+func (j *Job) addBytes(bytesRead int64) {
+	j.mu.Lock()
+	j.pendingBytes += bytesRead
+	currentTime := time.Now()
+	if currentTime.Sub(j.lastUpdate) < progressInterval {
+		j.mu.Unlock()
+		return
+	}
+	j.publishPending(currentTime)
+	j.mu.Unlock()
+
+	j.notifyUpdate()
+}
+
+// addSkipped records bytes that were already cached and need no reading
+func (j *Job) addSkipped(skipped int64) {
+	if skipped <= 0 {
+		return
+	}
+	j.mu.Lock()
+	j.SkippedBytes += skipped
+	j.CachedSize += skipped
+	j.mu.Unlock()
+
+	j.notifyUpdate()
+}
+
+// flushBytes publishes any bytes still pending, e.g. when a segment ends
+func (j *Job) flushBytes() {
+	j.mu.Lock()
+	if j.pendingBytes == 0 {
+		j.mu.Unlock()
+		return
+	}
+	j.publishPending(time.Now())
+	j.mu.Unlock()
+
+	j.notifyUpdate()
+}
+
+// publishPending folds pending bytes into the progress counters.
+// Caller must hold the job lock.
+func (j *Job) publishPending(currentTime time.Time) {
+	j.TotalBytesRead += j.pendingBytes
+	j.CachedSize += j.pendingBytes
+	j.updateSpeed(j.pendingBytes, currentTime)
+	j.pendingBytes = 0
+	j.lastUpdate = currentTime
+}
+
+// notifyUpdate tells the owner that published progress changed
+func (j *Job) notifyUpdate() {
+	if j.onUpdate != nil {
+		j.onUpdate()
+	}
+}
+
+// getState returns the current job state
+func (j *Job) getState() JobState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.State
+}
+
+// start marks a queued job as running
+func (j *Job) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	j.State = StateRunning
+	j.StartedAt = &now
+}
+
+// finish marks the job complete unless it was cancelled and summarizes
+// it
+func (j *Job) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	j.IsComplete = true
+	j.FinishedAt = &now
+	j.CurrentSpeed = 0
+	if j.State != StateCancelled {
+		j.State = StateComplete
+	}
+
+	summary := &CompletionSummary{
+		BytesRead:    j.TotalBytesRead,
+		SkippedBytes: j.SkippedBytes,
+		PeakSpeed:    j.peakSpeed,
+		FilesCached:  j.fileCounts[fileCached],
+		FilesSkipped: j.fileCounts[fileSkipped],
+		FilesFailed:  j.fileCounts[fileFailed],
+		ErrorCount:   j.ErrorCount,
+	}
+	if j.StartedAt != nil {
+		summary.WallTime = now.Sub(*j.StartedAt).Seconds()
+	}
+	if summary.WallTime > 0 {
+		summary.AverageSpeed = float64(summary.BytesRead) / summary.WallTime
+	}
+	// A job too short for a speed sample peaked at its average
+	summary.PeakSpeed = max(summary.PeakSpeed, summary.AverageSpeed)
+	j.Summary = summary
+}
+
+// pause stops readers at their next chunk boundary
+func (j *Job) pause() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.State != StateRunning {
+		return ErrJobNotRunning
+	}
+	j.State = StatePaused
+	j.CurrentSpeed = 0
+	j.resumeCh = make(chan struct{})
+	return nil
+}
+
+// preempt pauses the job to give its slot to a higher priority job
+func (j *Job) preempt() error {
+	if err := j.pause(); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.Preempted = true
+	j.mu.Unlock()
+	return nil
+}
+
+// pauseForSpace pauses the job until free cache space is available again
+func (j *Job) pauseForSpace() error {
+	if err := j.pause(); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.LowDiskSpace = true
+	j.mu.Unlock()
+	return nil
+}
+
+// pauseForMount pauses the job until the mount is healthy again
+func (j *Job) pauseForMount() error {
+	if err := j.pause(); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.MountDown = true
+	j.mu.Unlock()
+	return nil
+}
+
+// isPreempted reports whether the job gave up its slot. Cancelled jobs
+// keep the flag, since their slot was already released.
+func (j *Job) isPreempted() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Preempted
+}
+
+// resume releases readers blocked in waitIfPaused
+func (j *Job) resume() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.State != StatePaused {
+		return ErrJobNotPaused
+	}
+	j.State = StateRunning
+	j.Preempted = false
+	j.LowDiskSpace = false
+	j.MountDown = false
+	close(j.resumeCh)
+	j.resumeCh = nil
+	return nil
+}
+
+// stop cancels the job context and wakes any paused readers
+func (j *Job) stop() error {
+	j.mu.Lock()
+	if j.State == StateComplete || j.State == StateCancelled {
+		j.mu.Unlock()
+		return ErrJobFinished
+	}
+	j.State = StateCancelled
+	j.CurrentSpeed = 0
+	if j.resumeCh != nil {
+		close(j.resumeCh)
+		j.resumeCh = nil
+	}
+	j.mu.Unlock()
+
+	j.cancel()
+	return nil
+}
+
+// waitIfPaused blocks the calling reader while the job is paused and
+// reports whether the job was cancelled
+func (j *Job) waitIfPaused() error {
+	j.mu.Lock()
+	ch := j.resumeCh
+	j.mu.Unlock()
+
+	if ch != nil {
+		<-ch
+	}
+	return j.ctx.Err()
+}
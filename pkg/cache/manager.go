@@ -0,0 +1,1061 @@
+// Package cache is the precache engine of rclone-precache. A Manager
+// queues jobs that read files through an rclone mount, in full or only the
+// parts a player needs first, so that rclone's VFS cache holds them. It has
+// no HTTP dependencies and can be embedded in other programs:
+//
+//	cm := cache.NewManager(0, 2, cache.RetryPolicy{MaxRetries: 3, BaseDelay: time.Second},
+//		cache.ExtensionRules{}, nil, nil)
+//	opts := cache.JobOptions{Threads: 4}
+//	job, err := cm.StartJob("/Movies/Heat", "/mnt/media/Movies/Heat",
+//		"/var/cache/rclone/vfs/media/Movies/Heat", cache.JobOrigin{}, opts)
+//
+// Progress is polled with Job.Progress and GetGlobalProgress, or followed
+// on the EventHub returned by Events.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/sizer"
+	"github.com/fffonion/rclone-precache/pkg/tracing"
+)
+
+// maxFinishedJobs bounds the finished jobs kept for their summaries
+const maxFinishedJobs = 100
+
+// speedWindow is the bytes read at one instant of a job
+type speedWindow struct {
+	bytesRead int64
+	timestamp time.Time
+}
+
+// CacheProgress is how far a job has come
+type CacheProgress struct {
+	CurrentSpeed   float64    `json:"current_speed"`
+	TotalBytesRead int64      `json:"total_bytes_read"`
+	TotalSize      int64      `json:"total_size"`
+	IsComplete     bool       `json:"is_complete"`
+	State          JobState   `json:"state"`
+	CachedSize     int64      `json:"cached_size"`
+	SkippedBytes   int64      `json:"skipped_bytes"` // Bytes found already cached and not read again
+	BytesRemaining int64      `json:"bytes_remaining"`
+	ETASeconds     *float64   `json:"eta_seconds"`              // nil while the speed is unknown
+	LowDiskSpace   bool       `json:"low_disk_space,omitempty"` // Paused until free cache space is available
+	MountDown      bool       `json:"mount_down,omitempty"`     // Paused until the mount is healthy again
+	ErrorCount     int        `json:"error_count"`
+	Errors         []JobError `json:"errors"`
+}
+
+// GlobalProgress sums the progress of all unfinished jobs
+type GlobalProgress struct {
+	TotalSpeed     float64   `json:"total_speed"`
+	OverallPercent float64   `json:"overall_percent"`
+	ActiveJobs     int       `json:"active_jobs"`
+	QueuedJobs     int       `json:"queued_jobs"`
+	PausedJobs     int       `json:"paused_jobs"`
+	CachedSize     int64     `json:"cached_size"`
+	BytesRemaining int64     `json:"bytes_remaining"`
+	ETASeconds     *float64  `json:"eta_seconds"`
+	VFS            *VFSStats `json:"vfs,omitempty"` // rclone's own view of its cache
+}
+
+// estimateRemaining returns the bytes left and, when the speed is known,
+// the seconds needed to read them
+func estimateRemaining(totalSize, bytesRead int64, speed float64) (int64, *float64) {
+	remaining := totalSize - bytesRead
+	if remaining < 0 {
+		remaining = 0
+	}
+	if speed <= 0 {
+		return remaining, nil
+	}
+	eta := float64(remaining) / speed
+	return remaining, &eta
+}
+
+// updateEstimate refreshes BytesRemaining and ETASeconds from the rolling
+// speed. CachedSize is capped at TotalSize, which may be an estimate.
+func (cp *CacheProgress) updateEstimate() {
+	if cp.TotalSize > 0 {
+		cp.CachedSize = min(cp.CachedSize, cp.TotalSize)
+	}
+	if cp.IsComplete {
+		cp.BytesRemaining = 0
+		cp.ETASeconds = nil
+		return
+	}
+	cp.BytesRemaining, cp.ETASeconds = estimateRemaining(cp.TotalSize, cp.CachedSize, cp.CurrentSpeed)
+}
+
+// Manager queues precache jobs and reads the files they cover through the
+// mount, so rclone's VFS cache holds them before they are played
+type Manager struct {
+	sync.RWMutex
+	chunkSize  int // Read size in bytes, 0 to tune it per file
+	maxJobs    int // Maximum number of jobs running at once, 0 means unlimited
+	retry      RetryPolicy
+	extensions ExtensionRules
+	bwlimit    *RateLimiter    // Shared by all readers
+	minFree    int64           // Free cache bytes jobs must leave, 0 to disable the guard
+	quota      *Quota          // Evicts old cache files to make room, nil without a quota
+	vfs        *VFSCache       // rclone's cache metadata, nil to inspect cache files
+	vfsStats   *VFSStats       // Latest rclone VFS statistics, nil without a remote control
+	tracer     *tracing.Tracer // Exports job spans, nil without tracing
+	readOnly   bool            // Refuse new jobs
+	stopping   bool            // Shutting down, so refuse and start no jobs
+	running    int
+	jobs       map[string]*Job
+	queue      []*Job
+	sizer      *sizer.Sizer
+	store      *JobStore
+	history    *HistoryStore
+	events     *EventHub
+	notifiers  []*notifierQueue
+	updated    chan struct{} // Signalled when any job's progress changes
+	bytesRead  atomic.Int64  // Read by all jobs since the start
+	dirty      bool          // Set when file checkpoints changed since the last save
+}
+
+// NewManager creates a Manager reading chunkSize bytes at once, 0 to tune
+// it per file, with up to maxJobs jobs running at once, 0 for unlimited.
+// Unfinished jobs are saved to store and finished ones recorded in history;
+// either may be nil.
+func NewManager(chunkSize int, maxJobs int, retry RetryPolicy, extensions ExtensionRules, store *JobStore, history *HistoryStore) *Manager {
+	cm := &Manager{
+		jobs:       make(map[string]*Job),
+		sizer:      sizer.New(),
+		chunkSize:  chunkSize,
+		maxJobs:    maxJobs,
+		retry:      retry,
+		extensions: extensions,
+		bwlimit:    NewRateLimiter(0),
+		store:      store,
+		history:    history,
+		events:     NewEventHub(),
+		updated:    make(chan struct{}, 1),
+	}
+	go cm.checkpointLoop()
+	go cm.progressLoop()
+	return cm
+}
+
+// Events returns the hub job state changes and progress are published on
+func (cm *Manager) Events() *EventHub {
+	return cm.events
+}
+
+// Sizer returns the size cache used to estimate jobs
+func (cm *Manager) Sizer() *sizer.Sizer {
+	return cm.sizer
+}
+
+// Extensions returns the extension rules directory jobs follow
+func (cm *Manager) Extensions() ExtensionRules {
+	return cm.extensions
+}
+
+// SetBwLimit sets the bandwidth limit shared by all readers in bytes per
+// second, 0 for unlimited
+func (cm *Manager) SetBwLimit(bytesPerSec int64) {
+	cm.bwlimit.SetRate(bytesPerSec)
+}
+
+// SetReadOnly makes the manager refuse new jobs, or accept them again
+func (cm *Manager) SetReadOnly(readOnly bool) {
+	cm.Lock()
+	cm.readOnly = readOnly
+	cm.Unlock()
+}
+
+// ReadOnly reports whether new jobs are refused
+func (cm *Manager) ReadOnly() bool {
+	cm.RLock()
+	defer cm.RUnlock()
+	return cm.readOnly
+}
+
+// SetTracer exports a span for each job and the files it reads through t,
+// nil to stop tracing
+func (cm *Manager) SetTracer(t *tracing.Tracer) {
+	cm.Lock()
+	cm.tracer = t
+	cm.Unlock()
+}
+
+// countBytes records bytes newly read for a job
+func (cm *Manager) countBytes(job *Job, n int64) {
+	job.addBytes(n)
+	cm.bytesRead.Add(n)
+}
+
+// readFileSegment reads [startPos, endPos) in chunks sized by tuner and
+// returns the position reached, so a failed read can be retried from where
+// it stopped. Only bytes not yet in coverage count towards progress.
+func (cm *Manager) readFileSegment(file *os.File, startPos, endPos int64, job *Job, tuner *chunkTuner, coverage *rangeCoverage) (int64, error) {
+	// Seek to the start position
+	_, err := file.Seek(startPos, io.SeekStart)
+	if err != nil {
+		return startPos, err
+	}
+
+	var buffer []byte
+	currentPos := startPos
+	defer job.flushBytes()
+
+	for currentPos < endPos {
+		if err := job.waitIfPaused(); err != nil {
+			return currentPos, err
+		}
+
+		// Calculate how much to read in this iteration
+		bytesToRead := tuner.next()
+		if int64(bytesToRead) > (endPos - currentPos) {
+			bytesToRead = int(endPos - currentPos)
+		}
+		if len(buffer) < bytesToRead {
+			buffer = make([]byte, bytesToRead)
+		}
+
+		readStart := time.Now()
+		n, err := file.Read(buffer[:bytesToRead])
+		tuner.observe(n, time.Since(readStart))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return currentPos, err
+		}
+
+		cm.countBytes(job, coverage.add(currentPos, int64(n)))
+		currentPos += int64(n)
+
+		limiter := cm.bwlimit
+		if job.limiter != nil {
+			limiter = job.limiter
+		}
+		if err := limiter.Wait(job.ctx, n); err != nil {
+			return currentPos, err
+		}
+	}
+
+	return currentPos, nil
+}
+
+// readSegment opens its own handle on sourcePath and reads or, with the
+// advise strategy, warms one segment
+func (cm *Manager) readSegment(ctx context.Context, sourcePath string, startPos, endPos int64, job *Job, tuner *chunkTuner, coverage *rangeCoverage) (int64, error) {
+	ctx, span := tracing.Start(ctx, "read segment")
+	span.SetAttr("offset", startPos)
+	span.SetAttr("end", endPos)
+	pos, err := cm.readOpenSegment(ctx, sourcePath, startPos, endPos, job, tuner, coverage)
+	span.SetAttr("bytes", pos-startPos)
+	span.SetError(err)
+	span.End()
+	return pos, err
+}
+
+// readOpenSegment does the work of readSegment
+func (cm *Manager) readOpenSegment(ctx context.Context, sourcePath string, startPos, endPos int64, job *Job, tuner *chunkTuner, coverage *rangeCoverage) (int64, error) {
+	_, span := tracing.Start(ctx, "open")
+	file, err := os.Open(sourcePath)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return startPos, err
+	}
+	defer file.Close()
+
+	if job.Options.Strategy == StrategyAdvise {
+		return cm.adviseFileSegment(file, startPos, endPos, job, tuner, coverage)
+	}
+	return cm.readFileSegment(file, startPos, endPos, job, tuner, coverage)
+}
+
+// statFile returns the size of sourcePath, retrying transient failures
+func (cm *Manager) statFile(ctx context.Context, sourcePath string, job *Job, retrier *fileRetrier) (int64, error) {
+	_, span := tracing.Start(ctx, "stat")
+	defer span.End()
+	for {
+		info, err := os.Stat(sourcePath)
+		if err == nil {
+			return info.Size(), nil
+		}
+		if !retrier.wait(job.ctx) {
+			span.SetError(err)
+			return 0, err
+		}
+		span.AddEvent("retry", map[string]interface{}{"error": err.Error()})
+		job.Log().Warn("Retrying stat", "file", sourcePath, "error", err)
+	}
+}
+
+// cacheFile reads a file through the mount using parallel readers. Ranges
+// already present in the cache file at cacheFilePath are skipped. Failed
+// reads resume from the failing offset with exponential backoff until the
+// file's retry budget is spent. Ranges already in coverage, read by an
+// earlier run, are skipped too. It returns the number of bytes the job
+// wanted from the file and the number of retries used.
+func (cm *Manager) cacheFile(ctx context.Context, sourcePath, cacheFilePath string, job *Job, coverage *rangeCoverage) (int64, int, error) {
+	threads := job.Options.Threads
+	retrier := newFileRetrier(cm.retry)
+
+	fileSize, err := cm.statFile(ctx, sourcePath, job, retrier)
+	if err != nil {
+		return 0, retrier.count(), err
+	}
+
+	wanted := job.Options.wantedRanges(fileSize)
+	if job.Options.Mode == ModeMedia {
+		wanted = job.Options.mediaRanges(sourcePath, fileSize)
+	}
+	// Bytes of an earlier run were counted when the job was restored
+	unread := subtractRanges(wanted, coverage.snapshot())
+	toRead := unread
+	if cached, err := cm.CachedRanges(cacheFilePath); err == nil {
+		toRead = subtractRanges(unread, cached)
+		job.addSkipped(RangesLength(unread) - RangesLength(toRead))
+	}
+
+	chunkSize := cm.chunkSize
+	if job.Options.Chunk > 0 {
+		chunkSize = int(job.Options.Chunk)
+	}
+	minPiece := chunkSize
+	if minPiece <= 0 {
+		minPiece = adaptiveStartChunk
+	}
+	pieces := splitRanges(toRead, threads, int64(minPiece))
+	tuner := newChunkTuner(chunkSize)
+	work := make(chan ByteRange, len(pieces))
+	for _, piece := range pieces {
+		work <- piece
+	}
+	close(work)
+
+	var wg sync.WaitGroup
+	errors := make(chan error, len(pieces))
+
+	for i := 0; i < min(threads, len(pieces)); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for piece := range work {
+				startPos, endPos := piece.Offset, piece.End()
+				for {
+					pos, err := cm.readSegment(ctx, sourcePath, startPos, endPos, job, tuner, coverage)
+					if err == nil {
+						break
+					}
+					tuner.failed()
+					// A job paused while the mount is down retries once it is back
+					if job.waitIfPaused() != nil || !retrier.wait(job.ctx) {
+						errors <- err
+						return
+					}
+					job.Log().Warn("Retrying read", "file", sourcePath, "offset", pos, "error", err)
+					tracing.AddEvent(ctx, "retry", map[string]interface{}{"offset": pos, "error": err.Error()})
+					startPos = pos
+				}
+			}
+		}()
+	}
+
+	// Wait for all threads to complete
+	wg.Wait()
+	close(errors)
+
+	// Check for errors
+	for err := range errors {
+		if err != nil {
+			return 0, retrier.count(), err
+		}
+	}
+
+	if len(toRead) == 0 {
+		job.countFile(fileSkipped)
+	} else {
+		job.countFile(fileCached)
+	}
+	return RangesLength(wanted), retrier.count(), nil
+}
+
+// JobSpec names one path of a StartJobs request
+type JobSpec struct {
+	Path       string // Mount-relative path reported to clients
+	SourcePath string
+	CachePath  string
+	Options    JobOptions
+}
+
+// JobOrigin tells who asked for a job. All fields are empty for jobs the
+// server starts on its own.
+type JobOrigin struct {
+	ClientIP  string
+	User      string              // Authenticated user, empty without authentication
+	RequestID string              // API request that started the job
+	Trace     tracing.SpanContext // Span of the API request, continued by the job
+}
+
+// StartJob queues a precache job for sourcePath, reported under the
+// mount-relative path
+func (cm *Manager) StartJob(path, sourcePath, cachePath string, origin JobOrigin, opts JobOptions) (*Job, error) {
+	jobs, err := cm.StartJobs([]JobSpec{{Path: path, SourcePath: sourcePath, CachePath: cachePath, Options: opts}}, origin)
+	if err != nil {
+		return nil, err
+	}
+	return jobs[0], nil
+}
+
+// StartJobs queues one job per spec with shared options. Either every job
+// is queued or, if any path is missing or already being cached, none is.
+func (cm *Manager) StartJobs(specs []JobSpec, origin JobOrigin) ([]*Job, error) {
+	jobs := make([]*Job, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if seen[spec.Path] {
+			return nil, fmt.Errorf("%s: %w", spec.Path, ErrJobExists)
+		}
+		seen[spec.Path] = true
+
+		job, err := cm.newJob(NewID(), spec.Path, spec.SourcePath, spec.CachePath, spec.Options)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", spec.Path, err)
+		}
+		job.ClientIP = origin.ClientIP
+		job.User = origin.User
+		job.RequestID = origin.RequestID
+		job.trace = origin.Trace
+		jobs = append(jobs, job)
+	}
+
+	cm.Lock()
+	if cm.readOnly {
+		cm.Unlock()
+		return nil, ErrReadOnly
+	}
+	if cm.stopping {
+		cm.Unlock()
+		return nil, ErrShuttingDown
+	}
+	for _, job := range jobs {
+		if _, exists := cm.findJob(job.Path); exists {
+			cm.Unlock()
+			return nil, fmt.Errorf("%s: %w", job.Path, ErrJobExists)
+		}
+	}
+	if err := cm.checkSpace(jobs); err != nil {
+		cm.Unlock()
+		return nil, err
+	}
+	for _, job := range jobs {
+		job.Log().Info("Queued job")
+		cm.jobs[job.ID] = job
+		cm.enqueue(job)
+		cm.publishJob(job)
+		cm.notify(notifyQueued, job)
+		if job.Options.Preempt {
+			cm.preemptFor(job)
+		}
+	}
+	cm.dispatch()
+	cm.Unlock()
+
+	cm.persist()
+	return jobs, nil
+}
+
+// newJob creates a queued job without scheduling it
+func (cm *Manager) newJob(id, path, sourcePath, cachePath string, opts JobOptions) (*Job, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(sourcePath); err != nil {
+		return nil, err
+	}
+
+	var limiter *RateLimiter
+	if opts.BwLimit != nil {
+		limiter = NewRateLimiter(*opts.BwLimit)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Job{
+		ID:        id,
+		Path:      path,
+		Options:   opts,
+		CreatedAt: time.Now(),
+		CacheProgress: CacheProgress{
+			TotalSize: cm.plannedSize(sourcePath, opts),
+			State:     StateQueued,
+		},
+		sourcePath:   sourcePath,
+		cachePath:    cachePath,
+		files:        make(map[string]*FileCheckpoint),
+		reading:      make(map[string]*rangeCoverage),
+		speedWindows: make([]speedWindow, 0),
+		buffer:       make([]byte, cm.chunkSize),
+		onUpdate:     cm.progressChanged,
+		limiter:      limiter,
+		ctx:          ctx,
+		cancel:       cancel,
+	}, nil
+}
+
+// RestoreJobs requeues jobs that were unfinished when the server last stopped.
+// Files completed in the earlier run are skipped.
+func (cm *Manager) RestoreJobs() error {
+	if cm.store == nil {
+		return nil
+	}
+	records, err := cm.store.Load()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		job, err := cm.newJob(record.ID, record.Path, record.SourcePath, record.CachePath, record.Options)
+		if err != nil {
+			slog.Warn("Dropping saved job", "job", record.ID, "path", record.Path, "error", err)
+			continue
+		}
+		job.CreatedAt = record.CreatedAt
+		job.ClientIP = record.ClientIP
+		job.User = record.User
+		job.RequestID = record.RequestID
+		for name, checkpoint := range record.Files {
+			job.files[name] = checkpoint
+			job.TotalBytesRead += checkpoint.BytesDone
+			job.CachedSize += checkpoint.BytesDone
+		}
+
+		cm.Lock()
+		cm.jobs[job.ID] = job
+		cm.enqueue(job)
+		cm.publishJob(job)
+		cm.Unlock()
+		job.Log().Info("Resuming saved job")
+	}
+
+	cm.Lock()
+	cm.dispatch()
+	cm.Unlock()
+
+	cm.persist()
+	return nil
+}
+
+// persist saves all unfinished jobs to the job store
+func (cm *Manager) persist() {
+	if cm.store == nil {
+		return
+	}
+
+	cm.Lock()
+	records := make([]JobRecord, 0, len(cm.jobs))
+	for _, job := range cm.jobs {
+		record := job.record()
+		if record.State == StateComplete || record.State == StateCancelled {
+			continue
+		}
+		records = append(records, record)
+	}
+	cm.dirty = false
+	cm.Unlock()
+
+	if err := cm.store.Save(records); err != nil {
+		slog.Error("Error saving job state", "error", err)
+	}
+}
+
+// checkpointLoop periodically saves file checkpoints of running jobs,
+// including the ranges read of files they are in the middle of
+func (cm *Manager) checkpointLoop() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cm.RLock()
+		dirty := cm.dirty || cm.running > 0
+		cm.RUnlock()
+		if dirty {
+			cm.persist()
+		}
+	}
+}
+
+// enqueue adds a job behind all queued jobs of equal or higher priority.
+// Caller must hold the write lock.
+func (cm *Manager) enqueue(job *Job) {
+	i := len(cm.queue)
+	for i > 0 && cm.queue[i-1].Options.rank() < job.Options.rank() {
+		i--
+	}
+	cm.queue = append(cm.queue, nil)
+	copy(cm.queue[i+1:], cm.queue[i:])
+	cm.queue[i] = job
+}
+
+// dispatch starts queued jobs while job slots are available. Preempted jobs
+// get their slot back before queued jobs of the same or lower priority.
+// Caller must hold the write lock.
+func (cm *Manager) dispatch() {
+	for !cm.stopping && (cm.maxJobs <= 0 || cm.running < cm.maxJobs) {
+		preempted := cm.nextPreempted()
+		if preempted != nil && (len(cm.queue) == 0 || preempted.Options.rank() >= cm.queue[0].Options.rank()) {
+			if preempted.resume() == nil {
+				cm.running++
+				cm.publishJob(preempted)
+			}
+			continue
+		}
+		if len(cm.queue) == 0 {
+			return
+		}
+
+		job := cm.queue[0]
+		cm.queue = cm.queue[1:]
+		cm.running++
+		job.start()
+		cm.publishJob(job)
+		cm.notify(notifyStarted, job)
+		go cm.runJob(job)
+	}
+}
+
+// nextPreempted returns the oldest preempted job of the highest priority.
+// Caller must hold the lock.
+func (cm *Manager) nextPreempted() *Job {
+	var next *Job
+	for _, job := range cm.jobs {
+		if !job.isPreempted() || job.getState() != StatePaused {
+			continue
+		}
+		if next == nil || job.Options.rank() > next.Options.rank() ||
+			(job.Options.rank() == next.Options.rank() && job.CreatedAt.Before(next.CreatedAt)) {
+			next = job
+		}
+	}
+	return next
+}
+
+// preemptFor pauses running jobs of lower priority than job until a slot
+// is free for it, newest and lowest priority first.
+// Caller must hold the write lock.
+func (cm *Manager) preemptFor(job *Job) {
+	for cm.maxJobs > 0 && cm.running >= cm.maxJobs {
+		var victim *Job
+		for _, running := range cm.jobs {
+			if running.getState() != StateRunning || running.Options.rank() >= job.Options.rank() {
+				continue
+			}
+			if victim == nil || running.Options.rank() < victim.Options.rank() ||
+				(running.Options.rank() == victim.Options.rank() && running.CreatedAt.After(victim.CreatedAt)) {
+				victim = running
+			}
+		}
+		if victim == nil || victim.preempt() != nil {
+			return
+		}
+		cm.running--
+		cm.publishJob(victim)
+		victim.Log().Info("Paused job to run a higher priority job", "for_job", job.ID)
+	}
+}
+
+// runJob caches a single file or walks a directory, then frees its job slot.
+// Up to Options.Files files are cached at once.
+func (cm *Manager) runJob(job *Job) {
+	sourcePath := job.sourcePath
+	ctx := job.ctx
+
+	cm.RLock()
+	quota := cm.quota
+	tracer := cm.tracer
+	cm.RUnlock()
+	traceCtx, span := tracer.StartRoot(ctx, "precache job", tracing.SpanKindInternal, job.trace)
+	span.SetAttr("job.id", job.ID)
+	span.SetAttr("job.path", job.Path)
+	if job.RequestID != "" {
+		span.SetAttr("request_id", job.RequestID)
+	}
+	if quota != nil {
+		quota.MakeRoom(job.Progress().TotalSize)
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(job.Options.Files, 1))
+	cacheAsync := func(path string) error {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if err := cm.cacheEntry(traceCtx, job, path); err != nil {
+				job.Log().Error("Error caching file", "file", path, "error", err)
+			}
+		}()
+		return nil
+	}
+
+	// A file job is walked as a single entry with a relative path of "."
+	err := cm.walkJobFiles(sourcePath, job.Options, func(path string, info os.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		return cacheAsync(path)
+	})
+	// Sidecars of a video live next to it, outside the walked tree
+	for _, sidecar := range cm.findSidecars(sourcePath) {
+		if cacheAsync(sidecar) != nil {
+			break
+		}
+	}
+	wg.Wait()
+	if err != nil && ctx.Err() == nil {
+		job.Log().Error("Error walking directory", "dir", sourcePath, "error", err)
+		job.addError(".", err, 0)
+		span.SetError(err)
+	}
+	progress := job.Progress()
+	span.SetAttr("bytes", progress.TotalBytesRead)
+	span.SetAttr("errors", progress.ErrorCount)
+	span.End()
+	job.cancel()
+
+	cm.Lock()
+	// A job cancelled while preempted already gave up its slot
+	if !job.isPreempted() {
+		cm.running--
+	}
+	cm.dispatch()
+	cm.Unlock()
+
+	cm.CompleteJob(job.ID)
+}
+
+// cacheEntry caches one file of a job unless an earlier run finished it.
+// Failures are recorded on the job rather than returned, so the job moves
+// on to its next file.
+func (cm *Manager) cacheEntry(ctx context.Context, job *Job, path string) error {
+	relPath, err := filepath.Rel(job.sourcePath, path)
+	if err != nil {
+		return err
+	}
+	if job.fileDone(relPath) {
+		job.countFile(fileSkipped)
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "cache file")
+	span.SetAttr("file", relPath)
+	wanted, retries, err := cm.cacheFile(ctx, path, filepath.Join(job.cachePath, relPath), job, job.startFile(relPath))
+	span.SetAttr("bytes", wanted)
+	span.SetAttr("retries", retries)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		job.endFile(relPath)
+		if job.ctx.Err() == nil {
+			job.Log().Error("Error caching file", "file", path, "retries", retries, "error", err)
+			job.addError(relPath, err, retries)
+			job.countFile(fileFailed)
+		}
+		return nil
+	}
+	cm.checkpoint(job, relPath, wanted)
+	return nil
+}
+
+// checkpoint records a finished file so a restarted job can skip it
+func (cm *Manager) checkpoint(job *Job, relPath string, size int64) {
+	job.markFileDone(relPath, size)
+	cm.Lock()
+	cm.dirty = true
+	cm.Unlock()
+}
+
+// Shutdown refuses new jobs and pauses running ones so their readers stop
+// after the current chunk, then saves every unfinished job. Saved jobs
+// resume when the server starts again.
+func (cm *Manager) Shutdown() {
+	cm.Lock()
+	cm.stopping = true
+	paused := 0
+	for _, job := range cm.jobs {
+		if job.pause() == nil {
+			paused++
+		}
+	}
+	cm.Unlock()
+
+	cm.persist()
+	slog.Info("Saved jobs for the next start", "paused", paused)
+}
+
+// PauseJob pauses a running job, keeping its progress
+func (cm *Manager) PauseJob(id string) error {
+	job, exists := cm.GetJob(id)
+	if !exists {
+		return ErrJobNotFound
+	}
+	if err := job.pause(); err != nil {
+		return err
+	}
+	cm.publishJob(job)
+	return nil
+}
+
+// ResumeJob continues a paused job where it left off
+func (cm *Manager) ResumeJob(id string) error {
+	job, exists := cm.GetJob(id)
+	if !exists {
+		return ErrJobNotFound
+	}
+	cm.Lock()
+	defer cm.Unlock()
+	if cm.stopping {
+		return ErrShuttingDown
+	}
+	// Resuming a preempted job by hand takes a slot even if none is free
+	preempted := job.isPreempted()
+	if err := job.resume(); err != nil {
+		return err
+	}
+	if preempted {
+		cm.running++
+	}
+	cm.publishJob(job)
+	return nil
+}
+
+// CancelJob stops a queued or running job and frees its slot
+func (cm *Manager) CancelJob(id string) error {
+	cm.Lock()
+	job, exists := cm.jobs[id]
+	if !exists {
+		cm.Unlock()
+		return ErrJobNotFound
+	}
+	wasQueued := false
+	for i, queued := range cm.queue {
+		if queued == job {
+			cm.queue = append(cm.queue[:i], cm.queue[i+1:]...)
+			wasQueued = true
+			break
+		}
+	}
+	cm.Unlock()
+
+	if err := job.stop(); err != nil {
+		return err
+	}
+	cm.publishJob(job)
+	// Running jobs complete themselves once their readers exit
+	if wasQueued {
+		cm.CompleteJob(id)
+	}
+	return nil
+}
+
+// QueryHistory returns a page of finished jobs
+func (cm *Manager) QueryHistory(q HistoryQuery) ([]HistoryRecord, int, error) {
+	if cm.history == nil {
+		return []HistoryRecord{}, 0, nil
+	}
+	return cm.history.Query(q)
+}
+
+// GetJob looks up a job by ID
+func (cm *Manager) GetJob(id string) (*Job, bool) {
+	cm.RLock()
+	defer cm.RUnlock()
+	job, exists := cm.jobs[id]
+	return job, exists
+}
+
+// FindJob returns the unfinished job for a mount-relative path, if any
+func (cm *Manager) FindJob(path string) (*Job, bool) {
+	cm.RLock()
+	defer cm.RUnlock()
+	return cm.findJob(path)
+}
+
+// findJob is FindJob for callers holding the lock
+func (cm *Manager) findJob(path string) (*Job, bool) {
+	for _, job := range cm.jobs {
+		if job.Path == path && !job.Progress().IsComplete {
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// ListJobs returns all tracked jobs, oldest first
+func (cm *Manager) ListJobs() []*Job {
+	cm.RLock()
+	defer cm.RUnlock()
+	jobs := make([]*Job, 0, len(cm.jobs))
+	for _, job := range cm.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].CreatedAt.Before(jobs[k].CreatedAt)
+	})
+	return jobs
+}
+
+// CompleteJob marks a job finished and records it in the history. The
+// finished job and its summary are kept until cleared, or until more than
+// maxFinishedJobs finished jobs are kept.
+func (cm *Manager) CompleteJob(id string) {
+	cm.Lock()
+	job, exists := cm.jobs[id]
+	if exists {
+		job.finish()
+		cm.publishJob(job)
+		cm.notifyFinished(job)
+		cm.pruneFinished()
+	}
+	cm.Unlock()
+
+	cm.persist()
+	if exists {
+		record := job.historyRecord()
+		cm.events.Publish(Event{Type: EventComplete, Data: record})
+		if cm.history != nil {
+			if err := cm.history.Append(record); err != nil {
+				slog.Error("Error recording history", "job", id, "error", err)
+			}
+		}
+	}
+}
+
+// pruneFinished stops tracking the oldest finished jobs beyond
+// maxFinishedJobs. Caller must hold the write lock.
+func (cm *Manager) pruneFinished() {
+	var finished []*Job
+	for _, job := range cm.jobs {
+		if job.Progress().IsComplete {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool {
+		return finished[i].CreatedAt.Before(finished[k].CreatedAt)
+	})
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		cm.clear(job)
+	}
+}
+
+// ClearJob stops tracking a finished job
+func (cm *Manager) ClearJob(id string) error {
+	cm.Lock()
+	defer cm.Unlock()
+
+	job, exists := cm.jobs[id]
+	if !exists {
+		return ErrJobNotFound
+	}
+	if !job.Progress().IsComplete {
+		return ErrJobUnfinished
+	}
+	cm.clear(job)
+	return nil
+}
+
+// ClearFinished stops tracking the finished jobs whose paths match accepts,
+// or all of them if it is nil, and returns how many there were
+func (cm *Manager) ClearFinished(match func(path string) bool) int {
+	cm.Lock()
+	defer cm.Unlock()
+
+	cleared := 0
+	for _, job := range cm.jobs {
+		if job.Progress().IsComplete && (match == nil || match(job.Path)) {
+			cm.clear(job)
+			cleared++
+		}
+	}
+	return cleared
+}
+
+// clear forgets a finished job. Caller must hold the write lock.
+func (cm *Manager) clear(job *Job) {
+	delete(cm.jobs, job.ID)
+	cm.events.Publish(Event{Type: EventCleared, Data: job.ID})
+}
+
+// GetGlobalProgress returns the progress across all jobs
+func (cm *Manager) GetGlobalProgress() GlobalProgress {
+	cm.RLock()
+	defer cm.RUnlock()
+
+	var totalSpeed float64
+	var totalSize, cachedSize int64
+	activeJobs := 0
+	queuedJobs := 0
+	pausedJobs := 0
+
+	for _, job := range cm.jobs {
+		progress := job.Progress()
+		switch progress.State {
+		case StateQueued:
+			queuedJobs++
+			continue
+		case StatePaused:
+			pausedJobs++
+		}
+		if !progress.IsComplete {
+			totalSpeed += progress.CurrentSpeed
+			totalSize += progress.TotalSize
+			cachedSize += progress.CachedSize
+			activeJobs++
+		}
+	}
+
+	overallPercent := 0.0
+	if totalSize > 0 {
+		overallPercent = min(float64(cachedSize)/float64(totalSize)*100, 100)
+	}
+	bytesRemaining, eta := estimateRemaining(totalSize, cachedSize, totalSpeed)
+
+	var vfs *VFSStats
+	if cm.vfsStats != nil {
+		stats := *cm.vfsStats
+		vfs = &stats
+	}
+
+	return GlobalProgress{
+		TotalSpeed:     totalSpeed,
+		OverallPercent: overallPercent,
+		ActiveJobs:     activeJobs,
+		QueuedJobs:     queuedJobs,
+		PausedJobs:     pausedJobs,
+		CachedSize:     cachedSize,
+		BytesRemaining: bytesRemaining,
+		ETASeconds:     eta,
+		VFS:            vfs,
+	}
+}
@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+)
+
+// errNotMedia is returned for files that are neither MP4 nor Matroska
+var errNotMedia = errors.New("not a recognized media container")
+
+// Matroska element IDs used to locate the index
+const (
+	mkvEBML        = 0x1A45DFA3
+	mkvSegment     = 0x18538067
+	mkvSeekHead    = 0x114D9B74
+	mkvSeek        = 0x4DBB
+	mkvSeekID      = 0x53AB
+	mkvSeekPos     = 0x53AC
+	mkvCues        = 0x1C53BB6B
+	mkvCluster     = 0x1F43B675
+	mkvMaxChildren = 256 // Segment children inspected before giving up
+)
+
+// probeMediaRanges opens a media file and returns the byte ranges holding
+// its container index: every MP4 box except media data, or the Matroska
+// headers before the first cluster plus the cues
+func probeMediaRanges(sourcePath string, size int64) ([]ByteRange, error) {
+	f, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 8)
+	if _, err := f.ReadAt(magic, 0); err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(magic[4:8], []byte("ftyp")):
+		return mp4IndexRanges(f, size)
+	case binary.BigEndian.Uint32(magic[0:4]) == mkvEBML:
+		return mkvIndexRanges(f, size)
+	default:
+		return nil, errNotMedia
+	}
+}
+
+// mp4IndexRanges walks the top-level boxes of an MP4/MOV file
+func mp4IndexRanges(r io.ReaderAt, size int64) ([]ByteRange, error) {
+	var ranges []ByteRange
+	foundMoov := false
+	header := make([]byte, 16)
+
+	for offset := int64(0); offset+8 <= size; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		boxType := string(header[4:8])
+		headerLen := int64(8)
+
+		switch boxSize {
+		case 0:
+			// Box extends to the end of the file
+			boxSize = size - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if boxSize < headerLen {
+			return nil, fmt.Errorf("invalid %q box size %d at offset %d", boxType, boxSize, offset)
+		}
+		boxSize = min(boxSize, size-offset)
+
+		if boxType == "mdat" {
+			ranges = append(ranges, ByteRange{Offset: offset, Length: headerLen})
+		} else {
+			ranges = append(ranges, ByteRange{Offset: offset, Length: boxSize})
+		}
+		if boxType == "moov" {
+			foundMoov = true
+		}
+		offset += boxSize
+	}
+
+	if !foundMoov {
+		return nil, errors.New("no moov box found")
+	}
+	return mergeRanges(ranges), nil
+}
+
+// mkvIndexRanges locates the Matroska headers and cues
+func mkvIndexRanges(r io.ReaderAt, size int64) ([]ByteRange, error) {
+	// EBML header
+	id, dataSize, headerLen, err := readEBMLElement(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if id != mkvEBML {
+		return nil, errNotMedia
+	}
+	offset := headerLen + dataSize
+
+	// Segment, whose children are addressed relative to its data start
+	id, _, headerLen, err = readEBMLElement(r, offset)
+	if err != nil {
+		return nil, err
+	}
+	if id != mkvSegment {
+		return nil, errors.New("no segment element found")
+	}
+	segmentStart := offset + headerLen
+
+	var cuesPos int64 = -1
+	headersEnd := segmentStart
+	for i, child := 0, segmentStart; i < mkvMaxChildren && child < size; i++ {
+		id, dataSize, headerLen, err := readEBMLElement(r, child)
+		if err != nil {
+			return nil, err
+		}
+		if id == mkvCluster || dataSize < 0 {
+			break
+		}
+		switch id {
+		case mkvSeekHead:
+			if pos, err := mkvFindSeek(r, child+headerLen, dataSize, mkvCues); err == nil {
+				cuesPos = segmentStart + pos
+			}
+		case mkvCues:
+			cuesPos = child
+		}
+		child += headerLen + dataSize
+		headersEnd = child
+	}
+
+	if cuesPos < 0 {
+		return nil, errors.New("no cues found")
+	}
+	id, dataSize, headerLen, err = readEBMLElement(r, cuesPos)
+	if err != nil {
+		return nil, err
+	}
+	if id != mkvCues || dataSize < 0 {
+		return nil, errors.New("seek head points to invalid cues")
+	}
+
+	return mergeRanges([]ByteRange{
+		{Offset: 0, Length: headersEnd},
+		{Offset: cuesPos, Length: min(headerLen+dataSize, size-cuesPos)},
+	}), nil
+}
+
+// mkvFindSeek searches a SeekHead for the position of the target element
+func mkvFindSeek(r io.ReaderAt, start, length int64, target uint64) (int64, error) {
+	for offset := start; offset < start+length; {
+		id, dataSize, headerLen, err := readEBMLElement(r, offset)
+		if err != nil || dataSize < 0 {
+			return 0, errors.New("invalid seek head")
+		}
+		if id == mkvSeek {
+			var seekID uint64
+			var seekPos int64 = -1
+			for child := offset + headerLen; child < offset+headerLen+dataSize; {
+				childID, childSize, childHeader, err := readEBMLElement(r, child)
+				if err != nil || childSize < 0 || childSize > 8 {
+					return 0, errors.New("invalid seek entry")
+				}
+				value := make([]byte, childSize)
+				if _, err := r.ReadAt(value, child+childHeader); err != nil {
+					return 0, err
+				}
+				switch childID {
+				case mkvSeekID:
+					seekID = beUint(value)
+				case mkvSeekPos:
+					seekPos = int64(beUint(value))
+				}
+				child += childHeader + childSize
+			}
+			if seekID == target && seekPos >= 0 {
+				return seekPos, nil
+			}
+		}
+		offset += headerLen + dataSize
+	}
+	return 0, errors.New("element not in seek head")
+}
+
+// readEBMLElement reads the ID and data size of the element at offset. The
+// returned size is -1 for elements of unknown size.
+func readEBMLElement(r io.ReaderAt, offset int64) (id uint64, size int64, headerLen int64, err error) {
+	buf := make([]byte, 12)
+	n, err := r.ReadAt(buf, offset)
+	if n == 0 {
+		return 0, 0, 0, err
+	}
+	buf = buf[:n]
+
+	id, idLen, ok := readVint(buf, false)
+	if !ok || idLen > 4 {
+		return 0, 0, 0, fmt.Errorf("invalid element ID at offset %d", offset)
+	}
+	value, sizeLen, ok := readVint(buf[idLen:], true)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid element size at offset %d", offset)
+	}
+
+	size = int64(value)
+	// All value bits set means the size is unknown
+	if value == (uint64(1)<<(7*sizeLen))-1 {
+		size = -1
+	}
+	return id, size, int64(idLen + sizeLen), nil
+}
+
+// readVint decodes an EBML variable length integer. IDs keep their length
+// marker bit, sizes have it removed.
+func readVint(buf []byte, stripMarker bool) (uint64, int, bool) {
+	if len(buf) == 0 || buf[0] == 0 {
+		return 0, 0, false
+	}
+	length := 1
+	for mask := byte(0x80); buf[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || len(buf) < length {
+		return 0, 0, false
+	}
+
+	value := uint64(buf[0])
+	if stripMarker {
+		value &= uint64(0xFF >> length)
+	}
+	for i := 1; i < length; i++ {
+		value = value<<8 | uint64(buf[i])
+	}
+	return value, length, true
+}
+
+// beUint decodes a big-endian unsigned integer of up to 8 bytes
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// mergeRanges sorts ranges and joins overlapping or adjacent ones
+func mergeRanges(ranges []ByteRange) []ByteRange {
+	sort.Slice(ranges, func(i, k int) bool {
+		return ranges[i].Offset < ranges[k].Offset
+	})
+
+	var merged []ByteRange
+	for _, r := range ranges {
+		if r.Length <= 0 {
+			continue
+		}
+		if n := len(merged); n > 0 && r.Offset <= merged[n-1].End() {
+			merged[n-1].Length = max(merged[n-1].End(), r.End()) - merged[n-1].Offset
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// mediaRanges returns the container index ranges of a media file, falling
+// back to the head and tail ranges when the file cannot be parsed
+func (o JobOptions) mediaRanges(sourcePath string, size int64) []ByteRange {
+	ranges, err := probeMediaRanges(sourcePath, size)
+	if err != nil {
+		if !errors.Is(err, errNotMedia) {
+			slog.Warn("Falling back to head and tail", "file", sourcePath, "error", err)
+		}
+		return o.wantedRanges(size)
+	}
+	return ranges
+}
@@ -0,0 +1,143 @@
+package cache
+
+import "time"
+
+// notifyQueueSize bounds the notices waiting for a slow notifier; newer
+// ones are dropped once it is full
+const notifyQueueSize = 256
+
+// JobSummary is what notifiers are told about a job
+type JobSummary struct {
+	ID         string     `json:"id"`
+	Path       string     `json:"path"`
+	State      JobState   `json:"state"`
+	User       string     `json:"user,omitempty"`
+	ClientIP   string     `json:"client_ip,omitempty"`
+	RequestID  string     `json:"request_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	TotalSize  int64      `json:"total_size"` // Planned bytes, may be an estimate
+	BytesRead  int64      `json:"bytes_read"`
+	CachedSize int64      `json:"cached_size"`      // Read or found already cached, at most TotalSize
+	Duration   float64    `json:"duration_seconds"` // From start to finish, 0 until finished
+	ErrorCount int        `json:"error_count"`
+	Errors     []JobError `json:"errors"`
+
+	Summary *CompletionSummary `json:"summary,omitempty"` // Set for finished jobs
+}
+
+// Notifier is told when jobs move through their lifecycle. Each notifier
+// is called from its own goroutine, one job event at a time, so it may
+// block without holding up jobs or other notifiers.
+type Notifier interface {
+	// JobQueued is called once a job is accepted
+	JobQueued(job JobSummary)
+	// JobStarted is called when a job first gets a slot
+	JobStarted(job JobSummary)
+	// JobCompleted is called when a job read everything without errors
+	JobCompleted(job JobSummary)
+	// JobFailed is called when a job finished with errors or was cancelled
+	JobFailed(job JobSummary)
+}
+
+// notifyKind selects the Notifier method a notice is delivered to
+type notifyKind int
+
+const (
+	notifyQueued notifyKind = iota
+	notifyStarted
+	notifyCompleted
+	notifyFailed
+)
+
+type notice struct {
+	kind notifyKind
+	job  JobSummary
+}
+
+// notifierQueue delivers notices to one notifier in order
+type notifierQueue struct {
+	notifier Notifier
+	notices  chan notice
+}
+
+// run delivers notices in the order they were queued
+func (q *notifierQueue) run() {
+	for n := range q.notices {
+		switch n.kind {
+		case notifyQueued:
+			q.notifier.JobQueued(n.job)
+		case notifyStarted:
+			q.notifier.JobStarted(n.job)
+		case notifyCompleted:
+			q.notifier.JobCompleted(n.job)
+		case notifyFailed:
+			q.notifier.JobFailed(n.job)
+		}
+	}
+}
+
+// AddNotifier tells n about every job from now on
+func (cm *Manager) AddNotifier(n Notifier) {
+	q := &notifierQueue{notifier: n, notices: make(chan notice, notifyQueueSize)}
+	cm.Lock()
+	cm.notifiers = append(cm.notifiers, q)
+	cm.Unlock()
+	go q.run()
+}
+
+// notify queues a notice about job for every notifier. Caller must hold
+// the lock.
+func (cm *Manager) notify(kind notifyKind, job *Job) {
+	if len(cm.notifiers) == 0 {
+		return
+	}
+	n := notice{kind: kind, job: job.summary()}
+	for _, q := range cm.notifiers {
+		select {
+		case q.notices <- n:
+		default:
+			job.Log().Warn("Dropped job notification, notifier is too slow")
+		}
+	}
+}
+
+// notifyFinished tells notifiers whether a finished job succeeded. Caller
+// must hold the lock.
+func (cm *Manager) notifyFinished(job *Job) {
+	progress := job.Progress()
+	if progress.State == StateComplete && progress.ErrorCount == 0 {
+		cm.notify(notifyCompleted, job)
+	} else {
+		cm.notify(notifyFailed, job)
+	}
+}
+
+// summary returns what notifiers are told about the job
+func (j *Job) summary() JobSummary {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	summary := JobSummary{
+		ID:         j.ID,
+		Path:       j.Path,
+		State:      j.State,
+		User:       j.User,
+		ClientIP:   j.ClientIP,
+		RequestID:  j.RequestID,
+		CreatedAt:  j.CreatedAt,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+		TotalSize:  j.TotalSize,
+		BytesRead:  j.TotalBytesRead,
+		CachedSize: min(j.CachedSize, j.TotalSize),
+		ErrorCount: j.ErrorCount,
+		Errors:     append([]JobError{}, j.Errors...),
+		Summary:    j.Summary,
+	}
+	if j.StartedAt != nil && j.FinishedAt != nil {
+		summary.Duration = j.FinishedAt.Sub(*j.StartedAt).Seconds()
+	}
+	return summary
+}
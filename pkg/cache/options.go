@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Cache modes selecting which parts of each file a job reads
+const (
+	ModeFull     = "full"     // Read whole files
+	ModeHeadTail = "headtail" // Read only the first and last bytes of each file
+	ModeMedia    = "media"    // Read container indexes, falling back to head and tail
+)
+
+// Strategies selecting how a job warms the ranges it reads
+const (
+	StrategyRead   = "read"   // Copy bytes through userspace buffers
+	StrategyAdvise = "advise" // Ask the kernel to read ahead with posix_fadvise(WILLNEED)
+)
+
+// Job priorities, highest first in the queue
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// Default range sizes for ModeHeadTail and the ModeMedia fallback
+const (
+	defaultHeadBytes = 64 * 1024 * 1024
+	defaultTailBytes = 16 * 1024 * 1024
+)
+
+// maxParallelFiles bounds how many files one job may cache at once
+const maxParallelFiles = 64
+
+// JobOptions are the per-request settings a job runs with
+type JobOptions struct {
+	Threads   int        `json:"threads"`
+	Chunk     int64      `json:"chunk,omitempty"` // Bytes read at once, 0 for the server default
+	Files     int        `json:"files,omitempty"` // Files of a directory job cached at once
+	Mode      string     `json:"mode,omitempty"`
+	Strategy  string     `json:"strategy,omitempty"`
+	HeadBytes int64      `json:"head,omitempty"`
+	TailBytes int64      `json:"tail,omitempty"`
+	Include   []string   `json:"include,omitempty"` // Globs a file must match one of
+	Exclude   []string   `json:"exclude,omitempty"` // Globs no file may match
+	Regex     string     `json:"regex,omitempty"`   // Pattern the relative path must contain a match of
+	MinSize   int64      `json:"min_size,omitempty"`
+	MaxSize   int64      `json:"max_size,omitempty"`   // 0 means no upper limit
+	NewerThan *time.Time `json:"newer_than,omitempty"` // Only files modified after this
+	Depth     *int       `json:"depth,omitempty"`      // Directory levels to descend, 0 for the top level only
+	Priority  string     `json:"priority,omitempty"`
+	Preempt   bool       `json:"preempt,omitempty"` // Pause lower priority jobs to free a slot
+	BwLimit   *int64     `json:"bwlimit,omitempty"` // Bytes per second replacing the global limit, 0 for unlimited
+
+	filter *pathFilter // Compiled from the filter fields by validate
+}
+
+// Validate checks the options and fills in mode defaults
+func (o *JobOptions) Validate() error {
+	switch o.Mode {
+	case "", ModeFull:
+		o.Mode = ModeFull
+	case ModeHeadTail, ModeMedia:
+		if o.HeadBytes == 0 && o.TailBytes == 0 {
+			o.HeadBytes = defaultHeadBytes
+			o.TailBytes = defaultTailBytes
+		}
+	default:
+		return fmt.Errorf("unknown mode %q", o.Mode)
+	}
+	switch o.Strategy {
+	case "":
+		o.Strategy = StrategyRead
+	case StrategyRead:
+	case StrategyAdvise:
+		if !adviseSupported {
+			return fmt.Errorf("strategy %q is not supported on this platform", o.Strategy)
+		}
+	default:
+		return fmt.Errorf("unknown strategy %q", o.Strategy)
+	}
+	if o.Threads < 1 {
+		return fmt.Errorf("threads must be at least 1")
+	}
+	if o.Chunk < 0 {
+		return fmt.Errorf("chunk must not be negative")
+	}
+	if o.HeadBytes < 0 || o.TailBytes < 0 {
+		return fmt.Errorf("head and tail must not be negative")
+	}
+	if o.MinSize < 0 || o.MaxSize < 0 {
+		return fmt.Errorf("min_size and max_size must not be negative")
+	}
+	switch o.Priority {
+	case "":
+		o.Priority = PriorityNormal
+	case PriorityLow, PriorityNormal, PriorityHigh:
+	default:
+		return fmt.Errorf("unknown priority %q", o.Priority)
+	}
+	switch {
+	case o.Files == 0:
+		o.Files = 1
+	case o.Files < 0 || o.Files > maxParallelFiles:
+		return fmt.Errorf("files must be between 1 and %d", maxParallelFiles)
+	}
+	if o.BwLimit != nil && *o.BwLimit < 0 {
+		return fmt.Errorf("bwlimit must not be negative")
+	}
+	if o.Depth != nil && *o.Depth < 0 {
+		return fmt.Errorf("depth must not be negative")
+	}
+	if o.MaxSize > 0 && o.MaxSize < o.MinSize {
+		return fmt.Errorf("max_size must not be below min_size")
+	}
+
+	filter, err := newPathFilter(*o)
+	if err != nil {
+		return err
+	}
+	o.filter = filter
+	return nil
+}
+
+// rank orders priorities, higher runs first
+func (o JobOptions) rank() int {
+	switch o.Priority {
+	case PriorityLow:
+		return 0
+	case PriorityHigh:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// wantedRanges returns the parts of a size-byte file the job should cache.
+// Media jobs get their head and tail here; see mediaRanges for the index.
+func (o JobOptions) wantedRanges(size int64) []ByteRange {
+	if o.Mode == ModeFull || o.HeadBytes+o.TailBytes >= size {
+		return []ByteRange{{Offset: 0, Length: size}}
+	}
+
+	var ranges []ByteRange
+	if o.HeadBytes > 0 {
+		ranges = append(ranges, ByteRange{Offset: 0, Length: o.HeadBytes})
+	}
+	if o.TailBytes > 0 {
+		ranges = append(ranges, ByteRange{Offset: size - o.TailBytes, Length: o.TailBytes})
+	}
+	return ranges
+}
+
+// wantedBytes returns how many bytes of a size-byte file the job caches
+func (o JobOptions) wantedBytes(size int64) int64 {
+	return RangesLength(o.wantedRanges(size))
+}
+
+// plannedSize estimates the bytes a job will cache. Unfiltered whole-file
+// jobs use the allocated size from the sizer, other jobs walk the tree.
+// Media jobs are estimated by their head and tail, as probing every file up
+// front would read through the mount twice. Sidecars of a video are included.
+func (cm *Manager) plannedSize(sourcePath string, opts JobOptions) int64 {
+	var total int64
+	for _, sidecar := range cm.findSidecars(sourcePath) {
+		if info, err := os.Stat(sidecar); err == nil {
+			total += opts.wantedBytes(info.Size())
+		}
+	}
+	if opts.Mode == ModeFull && opts.filter == nil && !cm.extensions.restricts() {
+		return total + cm.sizer.Size(sourcePath)
+	}
+
+	cm.walkJobFiles(sourcePath, opts, func(path string, info os.FileInfo, err error) error {
+		if err == nil {
+			total += opts.wantedBytes(info.Size())
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"io/fs"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/pathutil"
+	"github.com/fffonion/rclone-precache/pkg/sizer"
+)
+
+// quotaInterval is how often the cache directory is brought back under quota
+const quotaInterval = time.Minute
+
+// CachedFile is a file in the cache directory that may be evicted
+type CachedFile struct {
+	Path       string    `json:"path"` // API path of the cached file
+	Size       int64     `json:"size"` // Bytes allocated on disk
+	LastAccess time.Time `json:"last_access"`
+
+	abs string
+}
+
+// QuotaDir is a cache directory a quota covers
+type QuotaDir struct {
+	Prefix string // API path its files are reported under
+	Path   string
+}
+
+// Quota keeps the cache directories under a combined size limit by
+// evicting the least recently used files
+type Quota struct {
+	mu       sync.Mutex // Serializes scans and evictions
+	dirs     []QuotaDir
+	stateDir string // Never scanned or evicted
+	limit    int64
+	// protected reports whether a file's API path must not be evicted
+	protected func(path string) bool
+	remove    func(path string) error
+}
+
+// NewQuota creates a quota of limit bytes for dirs, skipping stateDir.
+// Files for which protected returns true are kept, evicted files are
+// deleted with remove.
+func NewQuota(dirs []QuotaDir, stateDir string, limit int64, protected func(string) bool, remove func(string) error) *Quota {
+	return &Quota{dirs: dirs, stateDir: stateDir, limit: limit, protected: protected, remove: remove}
+}
+
+// Limit returns the quota in bytes
+func (q *Quota) Limit() int64 {
+	return q.limit
+}
+
+// scan lists the cached files, least recently used first, and returns the
+// bytes they use in total
+func (q *Quota) scan() ([]CachedFile, int64) {
+	files := []CachedFile{}
+	var used int64
+	for _, dir := range q.dirs {
+		used += q.scanDir(dir, &files)
+	}
+
+	sort.Slice(files, func(i, k int) bool {
+		return files[i].LastAccess.Before(files[k].LastAccess)
+	})
+	return files, used
+}
+
+// scanDir appends the files cached in one directory and returns the bytes
+// they use
+func (q *Quota) scanDir(dir QuotaDir, files *[]CachedFile) int64 {
+	var used int64
+	filepath.WalkDir(dir.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p == q.stateDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size, accessed := sizer.FileUsage(info)
+		used += size
+
+		rel, err := filepath.Rel(dir.Path, p)
+		if err != nil {
+			return nil
+		}
+		*files = append(*files, CachedFile{
+			Path:       path.Join("/", dir.Prefix, filepath.ToSlash(rel)),
+			Size:       size,
+			LastAccess: accessed,
+			abs:        p,
+		})
+		return nil
+	})
+	return used
+}
+
+// Candidates returns the evictable files, least recently used first, and
+// the bytes all cached files use
+func (q *Quota) Candidates() ([]CachedFile, int64) {
+	files, used := q.scan()
+	evictable := files[:0]
+	for _, file := range files {
+		if !q.protected(file.Path) {
+			evictable = append(evictable, file)
+		}
+	}
+	return evictable, used
+}
+
+// MakeRoom evicts least recently used files until need more bytes fit
+// under the quota, and returns the bytes freed
+func (q *Quota) MakeRoom(need int64) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	files, used := q.Candidates()
+	excess := used + need - q.limit
+	var freed int64
+	for _, file := range files {
+		if freed >= excess {
+			break
+		}
+		if err := q.remove(file.abs); err != nil {
+			slog.Error("Error evicting", "path", file.Path, "error", err)
+			continue
+		}
+		slog.Info("Evicted", "path", file.Path, "bytes", file.Size, "last_access", file.LastAccess)
+		freed += file.Size
+	}
+	if freed < excess {
+		slog.Warn("Cache is over quota with nothing left to evict", "excess_bytes", excess-freed)
+	}
+	return freed
+}
+
+// Run keeps the cache directories under quota as files are read through
+// the mount. It never returns.
+func (q *Quota) Run() {
+	ticker := time.NewTicker(quotaInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		q.MakeRoom(0)
+	}
+}
+
+// ProtectedPath reports whether a mount-relative path belongs to an
+// unfinished job or contains the path of one
+func (cm *Manager) ProtectedPath(path string) bool {
+	cm.RLock()
+	defer cm.RUnlock()
+
+	for _, job := range cm.jobs {
+		if state := job.getState(); state == StateComplete || state == StateCancelled {
+			continue
+		}
+		if pathutil.HasPrefix(path, job.Path) || pathutil.HasPrefix(job.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetQuota makes jobs evict least recently used files to make room before
+// they start, nil to stop
+func (cm *Manager) SetQuota(q *Quota) {
+	cm.Lock()
+	cm.quota = q
+	cm.Unlock()
+}
+
+// Quota returns the quota set with SetQuota, nil without one
+func (cm *Manager) Quota() *Quota {
+	cm.RLock()
+	defer cm.RUnlock()
+	return cm.quota
+}
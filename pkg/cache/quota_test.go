@@ -0,0 +1,39 @@
+package cache
+
+import "testing"
+
+func TestProtectedPath(t *testing.T) {
+	cm := NewManager(1<<20, 1, RetryPolicy{}, ExtensionRules{}, nil, nil)
+	for path, state := range map[string]JobState{
+		"/tv/show":  StateRunning,
+		"/movies/a": StateComplete,
+		"/movies/b": StateCancelled,
+		"/music/x":  StatePaused,
+	} {
+		job := &Job{ID: path, Path: path}
+		job.State = state
+		cm.jobs[job.ID] = job
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/tv/show", true},
+		{"/tv/show/s01/e01.mkv", true},
+		{"/tv", true},
+		{"/", true},
+		{"/music/x/track.flac", true},
+		{"/music", true},
+		{"/tv/show2", false},
+		{"/tv/other", false},
+		{"/movies/a", false},
+		{"/movies", false},
+		{"/movies/b/file", false},
+	}
+	for _, tt := range tests {
+		if got := cm.ProtectedPath(tt.path); got != tt.want {
+			t.Errorf("ProtectedPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
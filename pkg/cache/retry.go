@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxRetryDelay caps the exponential backoff between retries
+const maxRetryDelay = time.Minute
+
+// RetryPolicy controls how transient read failures are retried
+type RetryPolicy struct {
+	MaxRetries int           // Retries allowed per file, shared by all its segments
+	BaseDelay  time.Duration // Delay before the first retry, doubled for each further one
+}
+
+// backoff returns the delay before the given retry attempt (1-based)
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+	delay := rp.BaseDelay
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// fileRetrier tracks the retry budget of a single file
+type fileRetrier struct {
+	policy  RetryPolicy
+	retries int
+	mu      sync.Mutex
+}
+
+func newFileRetrier(policy RetryPolicy) *fileRetrier {
+	return &fileRetrier{policy: policy}
+}
+
+// wait sleeps before the next retry. It returns false when the file has
+// no retries left or the job was cancelled while waiting.
+func (fr *fileRetrier) wait(ctx context.Context) bool {
+	fr.mu.Lock()
+	if fr.retries >= fr.policy.MaxRetries {
+		fr.mu.Unlock()
+		return false
+	}
+	fr.retries++
+	delay := fr.policy.backoff(fr.retries)
+	fr.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// count returns how many retries were used
+func (fr *fileRetrier) count() int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.retries
+}
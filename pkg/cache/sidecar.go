@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultSidecarExtensions are cached alongside a precached video
+var DefaultSidecarExtensions = []string{".srt", ".ass", ".ssa", ".sub", ".idx", ".vtt", ".nfo", ".jpg", ".png"}
+
+// VideoExtensions identify files that get their sidecars cached
+var VideoExtensions = map[string]bool{
+	".mkv": true, ".mp4": true, ".m4v": true, ".avi": true, ".mov": true,
+	".wmv": true, ".ts": true, ".m2ts": true, ".webm": true, ".mpg": true,
+}
+
+// findSidecars returns the sidecar files next to a video, such as
+// "Movie.en.srt" or "Movie-poster.jpg" for "Movie.mkv". Directories and
+// other files have no sidecars.
+func (cm *Manager) findSidecars(sourcePath string) []string {
+	if len(cm.extensions.Sidecars) == 0 || !VideoExtensions[strings.ToLower(filepath.Ext(sourcePath))] {
+		return nil
+	}
+
+	dir, name := filepath.Split(sourcePath)
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var sidecars []string
+	for _, entry := range entries {
+		other := entry.Name()
+		if entry.IsDir() || other == name {
+			continue
+		}
+		if !strings.HasPrefix(other, stem+".") && !strings.HasPrefix(other, stem+"-") {
+			continue
+		}
+		if hasExtension(cm.extensions.Sidecars, strings.ToLower(filepath.Ext(other))) {
+			sidecars = append(sidecars, filepath.Join(dir, other))
+		}
+	}
+	return sidecars
+}
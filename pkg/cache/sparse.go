@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/fffonion/rclone-precache/pkg/sizer"
+)
+
+// ErrSparseUnsupported is returned where cached ranges can't be detected
+var ErrSparseUnsupported = errors.New("sparse range detection not supported on this platform")
+
+// ByteRange is a contiguous span of a file
+type ByteRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// End returns the offset just past the range
+func (r ByteRange) End() int64 {
+	return r.Offset + r.Length
+}
+
+// missingRanges returns the parts of [0, size) not covered by cached, which
+// must be sorted and non-overlapping
+func missingRanges(cached []ByteRange, size int64) []ByteRange {
+	return subtractRanges([]ByteRange{{Offset: 0, Length: size}}, cached)
+}
+
+// subtractRanges returns the parts of want not covered by have. Both must
+// be sorted and non-overlapping.
+func subtractRanges(want, have []ByteRange) []ByteRange {
+	var result []ByteRange
+	i := 0
+	for _, w := range want {
+		offset := w.Offset
+		// Skip ranges that end before this one
+		for i < len(have) && have[i].End() <= offset {
+			i++
+		}
+		for k := i; k < len(have) && have[k].Offset < w.End(); k++ {
+			if have[k].Offset > offset {
+				result = append(result, ByteRange{Offset: offset, Length: have[k].Offset - offset})
+			}
+			offset = max(offset, have[k].End())
+		}
+		if offset < w.End() {
+			result = append(result, ByteRange{Offset: offset, Length: w.End() - offset})
+		}
+	}
+	return result
+}
+
+// RangesLength sums the lengths of ranges
+func RangesLength(ranges []ByteRange) int64 {
+	var total int64
+	for _, r := range ranges {
+		total += r.Length
+	}
+	return total
+}
+
+// splitRanges divides ranges into pieces for parallel readers, aiming for one
+// piece per thread but never smaller than minPiece. Pieces after the first in
+// a range start slightly early so neighbouring readers overlap.
+func splitRanges(ranges []ByteRange, threads int, minPiece int64) []ByteRange {
+	if threads < 1 {
+		threads = 1
+	}
+	total := RangesLength(ranges)
+	pieceSize := (total + int64(threads) - 1) / int64(threads)
+	if pieceSize < minPiece {
+		pieceSize = minPiece
+	}
+	// Overlap is 5% of piece size or 1MB, whichever is smaller
+	overlapSize := min(pieceSize/20, 1024*1024)
+
+	var pieces []ByteRange
+	for _, r := range ranges {
+		for offset := r.Offset; offset < r.End(); offset += pieceSize {
+			start := offset
+			if start > r.Offset {
+				start = max(start-overlapSize, r.Offset)
+			}
+			end := min(offset+pieceSize, r.End())
+			pieces = append(pieces, ByteRange{Offset: start, Length: end - start})
+		}
+	}
+	return pieces
+}
+
+// rangeCoverage tracks the unique bytes of one file read so far, so that
+// overlapping pieces and retried reads are only counted once
+type rangeCoverage struct {
+	mu     sync.Mutex
+	ranges []ByteRange
+}
+
+// add records a read of length bytes at offset and returns how many of
+// them were not covered before
+func (c *rangeCoverage) add(offset, length int64) int64 {
+	r := ByteRange{Offset: offset, Length: length}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	added := RangesLength(subtractRanges([]ByteRange{r}, c.ranges))
+	c.ranges = mergeRanges(append(c.ranges, r))
+	return added
+}
+
+// snapshot returns a copy of the ranges read so far
+func (c *rangeCoverage) snapshot() []ByteRange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ByteRange(nil), c.ranges...)
+}
+
+// CachedBytes returns how many bytes of a cache file hold data, falling
+// back to the allocated size from s where holes can't be detected
+func CachedBytes(path string, s *sizer.Sizer) int64 {
+	ranges, err := cachedRanges(path)
+	if err != nil {
+		return s.Calculate(path)
+	}
+	return RangesLength(ranges)
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package cache
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cachedRanges lists the data ranges of a sparse cache file using
+// lseek(SEEK_DATA/SEEK_HOLE)
+func cachedRanges(path string) ([]ByteRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	fd := int(f.Fd())
+
+	var ranges []ByteRange
+	var offset int64
+	for offset < size {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// No data past offset
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, ByteRange{Offset: data, Length: hole - data})
+		offset = hole
+	}
+	return ranges, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package cache
+
+// cachedRanges is not available on this platform, so callers fall back to
+// reading whole files
+func cachedRanges(path string) ([]ByteRange, error) {
+	return nil, ErrSparseUnsupported
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileCheckpoint records how much of a single file a job has read
+type FileCheckpoint struct {
+	BytesDone int64       `json:"bytes_done"`
+	Complete  bool        `json:"complete"`
+	Ranges    []ByteRange `json:"ranges,omitempty"` // Read so far of an incomplete file
+}
+
+// JobRecord is the persisted form of an unfinished job
+type JobRecord struct {
+	ID         string                     `json:"id"`
+	Path       string                     `json:"path"`
+	SourcePath string                     `json:"source_path"`
+	CachePath  string                     `json:"cache_path"`
+	CreatedAt  time.Time                  `json:"created_at"`
+	State      JobState                   `json:"state"`
+	Options    JobOptions                 `json:"options"`
+	ClientIP   string                     `json:"client_ip,omitempty"`
+	User       string                     `json:"user,omitempty"`
+	RequestID  string                     `json:"request_id,omitempty"`
+	Files      map[string]*FileCheckpoint `json:"files,omitempty"`
+}
+
+// JobStore saves unfinished jobs to a JSON file so they survive restarts
+type JobStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewJobStore creates a store backed by jobs.json inside dir
+func NewJobStore(dir string) *JobStore {
+	return &JobStore{path: filepath.Join(dir, "jobs.json")}
+}
+
+// Load reads all saved job records, returning none if the file is missing
+func (js *JobStore) Load() ([]JobRecord, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	data, err := os.ReadFile(js.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []JobRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Save atomically replaces the saved job records
+func (js *JobStore) Save(records []JobRecord) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	return SaveJSON(js.path, records)
+}
+
+// SaveJSON atomically replaces the file at path with v encoded as JSON
+func SaveJSON(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
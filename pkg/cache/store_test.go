@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJobStoreRoundTrip(t *testing.T) {
+	store := NewJobStore(t.TempDir())
+	records, err := store.Load()
+	if err != nil || records != nil {
+		t.Fatalf("Load of a missing store = %v, %v, want nil, nil", records, err)
+	}
+
+	saved := []JobRecord{{
+		ID:         "job",
+		Path:       "/tv/show",
+		SourcePath: "/mnt/tv/show",
+		CachePath:  "/cache/tv/show",
+		CreatedAt:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		State:      StatePaused,
+		Options:    JobOptions{Threads: 4, Mode: ModeFull, Priority: PriorityHigh},
+		User:       "alice",
+		Files: map[string]*FileCheckpoint{
+			"e01.mkv": {BytesDone: 100, Complete: true},
+			"e02.mkv": {BytesDone: 30, Ranges: []ByteRange{{Offset: 0, Length: 10}, {Offset: 50, Length: 20}}},
+		},
+	}}
+	if err := store.Save(saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, saved) {
+		t.Errorf("Load = %+v, want %+v", loaded, saved)
+	}
+}
+
+func TestPartialFileCheckpoint(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "movie.mkv")
+	if err := os.WriteFile(source, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cm := NewManager(1<<20, 1, RetryPolicy{}, ExtensionRules{}, nil, nil)
+	job, err := cm.newJob("job", "/movie.mkv", source, t.TempDir(), JobOptions{Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coverage := job.startFile(".")
+	coverage.add(0, 10)
+	coverage.add(40, 20)
+	want := []ByteRange{{Offset: 0, Length: 10}, {Offset: 40, Length: 20}}
+	checkpoint := job.record().Files["."]
+	if checkpoint == nil || checkpoint.Complete || checkpoint.BytesDone != 30 || !reflect.DeepEqual(checkpoint.Ranges, want) {
+		t.Fatalf("checkpoint while reading = %+v, want 30 bytes in %v", checkpoint, want)
+	}
+
+	// A restored job picks up the ranges read so far
+	restored, err := cm.newJob("job", "/movie.mkv", source, t.TempDir(), JobOptions{Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.files["."] = checkpoint
+	if got := restored.startFile(".").snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored coverage = %v, want %v", got, want)
+	}
+
+	restored.markFileDone(".", 100)
+	checkpoint = restored.record().Files["."]
+	if !checkpoint.Complete || checkpoint.BytesDone != 100 || checkpoint.Ranges != nil {
+		t.Errorf("checkpoint after the file finished = %+v", checkpoint)
+	}
+}
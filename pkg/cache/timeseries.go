@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// TimeSeriesInterval is the time covered by one sample
+	TimeSeriesInterval = time.Minute
+	// MaxTimeSeriesRange is how far back samples are kept
+	MaxTimeSeriesRange = 7 * 24 * time.Hour
+
+	maxTimeSamples = int(MaxTimeSeriesRange / TimeSeriesInterval)
+)
+
+// TimeSample is the aggregate throughput of one interval
+type TimeSample struct {
+	Time        time.Time `json:"time"` // End of the interval
+	BytesRead   int64     `json:"bytes_read"`
+	Speed       float64   `json:"speed"`      // Average over the interval
+	PeakSpeed   float64   `json:"peak_speed"` // Highest total speed seen each second
+	CachedBytes int64     `json:"cached_bytes"`
+	ActiveJobs  int       `json:"active_jobs"`
+}
+
+// TimeSeries keeps the samples of the last MaxTimeSeriesRange in memory
+// and appends them to a JSON lines file, so graphs survive restarts. The
+// file is rewritten once it holds twice as many samples as are kept.
+type TimeSeries struct {
+	path    string
+	samples []TimeSample // Oldest first
+	lines   int          // Samples in the file
+	mu      sync.Mutex
+}
+
+// NewTimeSeries creates a series backed by timeseries.jsonl inside dir and
+// loads the samples saved there
+func NewTimeSeries(dir string) *TimeSeries {
+	ts := &TimeSeries{path: filepath.Join(dir, "timeseries.jsonl")}
+	if err := ts.load(); err != nil {
+		slog.Error("Error loading throughput samples", "error", err)
+	}
+	return ts
+}
+
+// load reads the saved samples, keeping the newest
+func (ts *TimeSeries) load() error {
+	f, err := os.Open(ts.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var sample TimeSample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			continue
+		}
+		ts.lines++
+		ts.samples = append(ts.samples, sample)
+		if len(ts.samples) > maxTimeSamples {
+			ts.samples = ts.samples[1:]
+		}
+	}
+	return scanner.Err()
+}
+
+// Add records a sample, dropping the oldest once the series is full
+func (ts *TimeSeries) Add(sample TimeSample) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.samples = append(ts.samples, sample)
+	if len(ts.samples) > maxTimeSamples {
+		ts.samples = append([]TimeSample(nil), ts.samples[len(ts.samples)-maxTimeSamples:]...)
+	}
+	var err error
+	if ts.lines >= 2*maxTimeSamples {
+		err = ts.rewrite()
+	} else {
+		err = ts.append(sample)
+	}
+	if err != nil {
+		slog.Error("Error saving throughput sample", "error", err)
+	}
+}
+
+// append adds a sample to the file. Caller must hold the lock.
+func (ts *TimeSeries) append(sample TimeSample) error {
+	if err := os.MkdirAll(filepath.Dir(ts.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ts.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	ts.lines++
+	return nil
+}
+
+// rewrite replaces the file with the kept samples. Caller must hold the
+// lock.
+func (ts *TimeSeries) rewrite() error {
+	tmpPath := ts.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, sample := range ts.samples {
+		if err := enc.Encode(sample); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	ts.lines = len(ts.samples)
+	return os.Rename(tmpPath, ts.path)
+}
+
+// Query returns the samples after since, oldest first. A step longer than
+// TimeSeriesInterval merges the samples of each step into one: bytes are
+// summed, speeds averaged or maxed, and cached bytes and active jobs taken
+// from the last sample.
+func (ts *TimeSeries) Query(since time.Time, step time.Duration) []TimeSample {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	samples := []TimeSample{}
+	for _, sample := range ts.samples {
+		if !sample.Time.After(since) {
+			continue
+		}
+		if step <= TimeSeriesInterval {
+			samples = append(samples, sample)
+			continue
+		}
+		// Samples belong to the step their interval ends in
+		end := since.Add((sample.Time.Sub(since) + step - 1) / step * step)
+		if n := len(samples); n > 0 && samples[n-1].Time.Equal(end) {
+			merged := &samples[n-1]
+			merged.BytesRead += sample.BytesRead
+			merged.PeakSpeed = max(merged.PeakSpeed, sample.PeakSpeed)
+			merged.CachedBytes = sample.CachedBytes
+			merged.ActiveJobs = sample.ActiveJobs
+			merged.Speed = float64(merged.BytesRead) / step.Seconds()
+			continue
+		}
+		sample.Time = end
+		sample.Speed = float64(sample.BytesRead) / step.Seconds()
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// RecordTimeSeries adds a sample to ts every TimeSeriesInterval, with the
+// bytes read by all jobs and the cache size reported by cachedBytes
+func (cm *Manager) RecordTimeSeries(ts *TimeSeries, cachedBytes func() int64) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		start := time.Now()
+		lastRead := cm.bytesRead.Load()
+		var peak float64
+		for now := range ticker.C {
+			global := cm.GetGlobalProgress()
+			peak = max(peak, global.TotalSpeed)
+			if now.Sub(start) < TimeSeriesInterval {
+				continue
+			}
+
+			read := cm.bytesRead.Load()
+			ts.Add(TimeSample{
+				Time:        now,
+				BytesRead:   read - lastRead,
+				Speed:       float64(read-lastRead) / now.Sub(start).Seconds(),
+				PeakSpeed:   peak,
+				CachedBytes: cachedBytes(),
+				ActiveJobs:  global.ActiveJobs,
+			})
+			start, lastRead, peak = now, read, 0
+		}
+	}()
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VFSCache reads rclone's VFS cache directory, where the data of remote
+// "name:path" lives below vfs/name/path and the metadata of each item,
+// including the byte ranges present, below vfsMeta/name/path
+type VFSCache struct {
+	dataRoot string
+	metaRoot string
+}
+
+// vfsItem is the metadata rclone keeps for one cached file
+type vfsItem struct {
+	ModTime time.Time
+	ATime   time.Time
+	Size    int64
+	Rs      []struct {
+		Pos  int64
+		Size int64
+	}
+	Fingerprint string
+	Dirty       bool
+}
+
+// NewVFSCache locates the cache of remote, e.g. "gdrive:media", inside
+// rclone's --cache-dir
+func NewVFSCache(cacheDir, remote string) (*VFSCache, error) {
+	name, root, ok := strings.Cut(remote, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid remote %q, expected name:path", remote)
+	}
+	rel := filepath.Join(name, filepath.FromSlash(strings.Trim(root, "/")))
+	return &VFSCache{
+		dataRoot: filepath.Join(cacheDir, "vfs", rel),
+		metaRoot: filepath.Join(cacheDir, "vfsMeta", rel),
+	}, nil
+}
+
+// DataRoot returns the directory holding the cached data of the remote,
+// the mirror of the mount
+func (v *VFSCache) DataRoot() string {
+	return v.dataRoot
+}
+
+// metaPath maps a path below the data root to its metadata counterpart
+func (v *VFSCache) metaPath(dataPath string) (string, error) {
+	rel, err := filepath.Rel(v.dataRoot, dataPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the VFS cache", dataPath)
+	}
+	return filepath.Join(v.metaRoot, rel), nil
+}
+
+// readItem parses the metadata file at metaPath
+func readItem(metaPath string) (*vfsItem, error) {
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	var item vfsItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", metaPath, err)
+	}
+	return &item, nil
+}
+
+// ranges returns the byte ranges rclone recorded as present
+func (item *vfsItem) ranges() []ByteRange {
+	ranges := make([]ByteRange, 0, len(item.Rs))
+	for _, r := range item.Rs {
+		ranges = append(ranges, ByteRange{Offset: r.Pos, Length: r.Size})
+	}
+	return mergeRanges(ranges)
+}
+
+// CachedRanges returns the ranges of the file at dataPath that rclone holds
+func (v *VFSCache) CachedRanges(dataPath string) ([]ByteRange, error) {
+	metaPath, err := v.metaPath(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	item, err := readItem(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	return item.ranges(), nil
+}
+
+// CachedSize totals the bytes rclone holds for the file or directory at
+// dataPath
+func (v *VFSCache) CachedSize(dataPath string) int64 {
+	metaPath, err := v.metaPath(dataPath)
+	if err != nil {
+		return 0
+	}
+	var total int64
+	filepath.WalkDir(metaPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if item, err := readItem(path); err == nil {
+			total += RangesLength(item.ranges())
+		}
+		return nil
+	})
+	return total
+}
+
+// Remove deletes the data of a cached file together with its metadata, so
+// rclone doesn't trust ranges that are gone
+func (v *VFSCache) Remove(dataPath string) error {
+	if err := os.Remove(dataPath); err != nil {
+		return err
+	}
+	metaPath, err := v.metaPath(dataPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(metaPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// UseVFSCache makes skipped ranges follow rclone's own metadata instead of
+// inspecting the cache files, nil to inspect them again
+func (cm *Manager) UseVFSCache(v *VFSCache) {
+	cm.Lock()
+	cm.vfs = v
+	cm.Unlock()
+}
+
+// CachedRanges returns the cached ranges of the cache file at path
+func (cm *Manager) CachedRanges(path string) ([]ByteRange, error) {
+	cm.RLock()
+	vfs := cm.vfs
+	cm.RUnlock()
+	if vfs != nil {
+		return vfs.CachedRanges(path)
+	}
+	return cachedRanges(path)
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"log/slog"
+	"time"
+)
+
+// vfsStatsInterval is how often rclone's VFS statistics are fetched
+const vfsStatsInterval = 5 * time.Second
+
+// VFSStats is the state of rclone's VFS cache as reported by vfs/stats
+type VFSStats struct {
+	Fs                string    `json:"fs"`
+	CacheBytesUsed    int64     `json:"cache_bytes_used"`
+	CacheFiles        int       `json:"cache_files"`
+	UploadsInProgress int       `json:"uploads_in_progress"`
+	UploadsQueued     int       `json:"uploads_queued"`
+	ErroredFiles      int       `json:"errored_files"`
+	OutOfSpace        bool      `json:"out_of_space"`
+	UpdatedAt         time.Time `json:"updated_at"`
+	Error             string    `json:"error,omitempty"` // Set when the last fetch failed
+}
+
+// StartVFSStats polls rclone's VFS statistics with fetch in the background
+// so global progress can include them without waiting on rclone
+func (cm *Manager) StartVFSStats(fetch func() (VFSStats, error)) {
+	go func() {
+		for ; ; time.Sleep(vfsStatsInterval) {
+			stats, err := fetch()
+			cm.Lock()
+			if err != nil {
+				if cm.vfsStats == nil || cm.vfsStats.Error == "" {
+					slog.Error("Error fetching rclone VFS stats", "error", err)
+				}
+				// Keep the last good numbers alongside the error
+				if cm.vfsStats != nil {
+					stats = *cm.vfsStats
+				}
+				stats.Error = err.Error()
+			}
+			cm.vfsStats = &stats
+			cm.Unlock()
+		}
+	}()
+}
@@ -0,0 +1,15 @@
+// Package pathutil holds helpers for slash-separated paths shared by the
+// server and its packages.
+package pathutil
+
+import "strings"
+
+// HasPrefix reports whether p is prefix or lies below it. Both use forward
+// slashes.
+func HasPrefix(p, prefix string) bool {
+	// Roots such as "/" or "C:/" already end in a slash
+	if strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(p, prefix)
+	}
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
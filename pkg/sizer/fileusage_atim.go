@@ -0,0 +1,23 @@
+//go:build linux || openbsd || dragonfly
+
+package sizer
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// FileUsage returns the bytes a file allocates on disk and when it was
+// last read or written
+func FileUsage(info os.FileInfo) (int64, time.Time) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size(), info.ModTime()
+	}
+	accessed := time.Unix(stat.Atim.Unix())
+	if modified := info.ModTime(); modified.After(accessed) {
+		accessed = modified
+	}
+	return int64(stat.Blocks) * statBlockSize, accessed
+}
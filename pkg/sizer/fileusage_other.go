@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package sizer
+
+import (
+	"os"
+	"time"
+)
+
+// FileUsage falls back to the apparent size and modification time where
+// allocation and access times are not available
+func FileUsage(info os.FileInfo) (int64, time.Time) {
+	return info.Size(), info.ModTime()
+}
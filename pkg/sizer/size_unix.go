@@ -0,0 +1,22 @@
+//go:build !windows
+
+package sizer
+
+import "syscall"
+
+// statBlockSize is the unit of st_blocks, S_BLKSIZE. Linux, macOS and the
+// BSDs all count 512-byte blocks there whatever the filesystem's own block
+// size, which st_blksize reports instead.
+const statBlockSize = 512
+
+// AllocatedSize returns the bytes allocated on disk for path, which for a
+// sparse cache file is only what has been read so far, and whether path is a directory
+func AllocatedSize(path string) (int64, bool, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return 0, false, err
+	}
+	// Mode is a uint16 on macOS and most BSDs and a uint32 elsewhere
+	isDir := uint32(stat.Mode)&syscall.S_IFMT == syscall.S_IFDIR
+	return int64(stat.Blocks) * statBlockSize, isDir, nil
+}
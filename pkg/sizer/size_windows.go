@@ -0,0 +1,36 @@
+package sizer
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procGetCompressedFileSizeW = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetCompressedFileSizeW")
+
+// AllocatedSize returns the bytes allocated on disk for path, which for a
+// sparse cache file is only what has been read so far, and whether path is a directory. Filesystems without
+// sparse or compressed files, such as some WinFsp mounts, report the file
+// size instead.
+func AllocatedSize(path string) (int64, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false, err
+	}
+	if info.IsDir() {
+		return 0, true, nil
+	}
+
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false, err
+	}
+	var high uint32
+	low, _, callErr := procGetCompressedFileSizeW.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&high)))
+	// INVALID_FILE_SIZE is only a failure if the last error is set
+	if uint32(low) == 0xFFFFFFFF && callErr != windows.ERROR_SUCCESS {
+		return info.Size(), false, nil
+	}
+	return int64(high)<<32 | int64(uint32(low)), false, nil
+}
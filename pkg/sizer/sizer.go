@@ -0,0 +1,278 @@
+// Package sizer measures the bytes files and directories allocate on disk,
+// which for the sparse files of a cache is only what has been read so far.
+package sizer
+
+import (
+	"container/list"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/pathutil"
+)
+
+const (
+	// DefaultMaxAge is how long sizes are cached unless SetLimits changes it
+	DefaultMaxAge = 5 * time.Minute
+	// pruneInterval is how often expired sizes are dropped
+	pruneInterval = time.Minute
+)
+
+// SizeCache holds size information with timestamp
+type SizeCache struct {
+	Size      int64
+	Timestamp time.Time
+}
+
+// cacheEntry is an element of the LRU list
+type cacheEntry struct {
+	path string
+	SizeCache
+}
+
+// Sizer handles directory size calculations with caching. Expired entries
+// are pruned in the background, and beyond maxEntries the least recently
+// used ones are dropped.
+type Sizer struct {
+	cache map[string]*list.Element // Values are *cacheEntry
+	lru   *list.List               // Most recently used first
+	mu    sync.Mutex
+	// Cache entries older than this will be recalculated
+	maxAge     time.Duration
+	maxEntries int // 0 for no limit
+}
+
+// New creates a Sizer with an empty cache
+func New() *Sizer {
+	ds := &Sizer{
+		cache:  make(map[string]*list.Element),
+		lru:    list.New(),
+		maxAge: DefaultMaxAge,
+	}
+	go ds.pruneLoop()
+	return ds
+}
+
+// SetLimits sets how long sizes are cached and how many are kept, 0 for no
+// limit
+func (ds *Sizer) SetLimits(maxAge time.Duration, maxEntries int) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.maxAge = maxAge
+	ds.maxEntries = maxEntries
+	ds.evict()
+}
+
+// Size gets the actual allocated size of a file or directory
+func (ds *Sizer) Size(path string) int64 {
+	// Convert to absolute path for consistent cache keys
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return 0
+	}
+
+	// Check cache first
+	if size := ds.checkCache(absPath); size >= 0 {
+		return size
+	}
+
+	// Calculate new size
+	size := ds.Calculate(absPath)
+
+	// Store in cache
+	ds.store(absPath, size)
+
+	return size
+}
+
+// Invalidate drops cached sizes of path, everything below it and every
+// directory above it, whose sizes included path. It returns how many were
+// dropped.
+func (ds *Sizer) Invalidate(path string) int {
+	// Keys are absolute, so relative paths must be resolved the same way
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	prefix := filepath.ToSlash(path)
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	dropped := 0
+	for p, elem := range ds.cache {
+		if pathutil.HasPrefix(filepath.ToSlash(p), prefix) {
+			ds.remove(elem)
+			dropped++
+		}
+	}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if elem, exists := ds.cache[dir]; exists {
+			ds.remove(elem)
+			dropped++
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	return dropped
+}
+
+// Flush drops all cached sizes, returning how many were dropped
+func (ds *Sizer) Flush() int {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	dropped := len(ds.cache)
+	ds.cache = make(map[string]*list.Element)
+	ds.lru.Init()
+	return dropped
+}
+
+// Stats describes the contents of the size cache
+type Stats struct {
+	Entries     int     `json:"entries"`
+	MaxEntries  int     `json:"max_entries"`  // 0 for no limit
+	MemoryBytes int64   `json:"memory_bytes"` // Rough estimate of keys and map overhead
+	MaxAge      float64 `json:"max_age_seconds"`
+}
+
+// entryOverhead approximates the memory of a cache entry besides its key:
+// the map slot, the list element and the cacheEntry it points to
+const entryOverhead = 144
+
+// Stats returns the number of cached sizes and their approximate memory use
+func (ds *Sizer) Stats() Stats {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	stats := Stats{Entries: len(ds.cache), MaxEntries: ds.maxEntries, MaxAge: ds.maxAge.Seconds()}
+	for p := range ds.cache {
+		stats.MemoryBytes += int64(len(p)) + entryOverhead
+	}
+	return stats
+}
+
+// checkCache checks if we have a valid cached size, marking it as recently
+// used
+func (ds *Sizer) checkCache(path string) int64 {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if elem, exists := ds.cache[path]; exists {
+		entry := elem.Value.(*cacheEntry)
+		if time.Since(entry.Timestamp) < ds.maxAge {
+			ds.lru.MoveToFront(elem)
+			return entry.Size
+		}
+	}
+	return -1
+}
+
+// store caches the size of path, dropping the least recently used sizes
+// beyond the limit
+func (ds *Sizer) store(path string, size int64) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	value := SizeCache{Size: size, Timestamp: time.Now()}
+	if elem, exists := ds.cache[path]; exists {
+		elem.Value.(*cacheEntry).SizeCache = value
+		ds.lru.MoveToFront(elem)
+		return
+	}
+	ds.cache[path] = ds.lru.PushFront(&cacheEntry{path: path, SizeCache: value})
+	ds.evict()
+}
+
+// evict drops the least recently used sizes beyond maxEntries. Caller must
+// hold the lock.
+func (ds *Sizer) evict() {
+	for ds.maxEntries > 0 && ds.lru.Len() > ds.maxEntries {
+		ds.remove(ds.lru.Back())
+	}
+}
+
+// remove drops one entry. Caller must hold the lock.
+func (ds *Sizer) remove(elem *list.Element) {
+	delete(ds.cache, elem.Value.(*cacheEntry).path)
+	ds.lru.Remove(elem)
+}
+
+// pruneLoop drops expired sizes every pruneInterval, so files walked once
+// don't stay in memory forever
+func (ds *Sizer) pruneLoop() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ds.prune()
+	}
+}
+
+// prune drops the sizes older than maxAge
+func (ds *Sizer) prune() {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for _, elem := range ds.cache {
+		if time.Since(elem.Value.(*cacheEntry).Timestamp) >= ds.maxAge {
+			ds.remove(elem)
+		}
+	}
+}
+
+// Calculate computes the actual size of a file or directory, using cached
+// sizes only for what lies below it
+func (ds *Sizer) Calculate(path string) int64 {
+	// Walked paths become cache keys, which Invalidate expects absolute
+	if absPath, err := filepath.Abs(path); err == nil {
+		path = absPath
+	}
+	size, isDir, err := AllocatedSize(path)
+	if err != nil {
+		return 0
+	}
+
+	// If it's not a directory, return its size directly
+	if !isDir {
+		return size
+	}
+	cacheHits := 0
+
+	// For directories, walk through all contents
+	var totalSize int64
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return filepath.SkipDir
+		}
+
+		// Check if we have this path in cache
+		if cachedSize := ds.checkCache(p); cachedSize >= 0 && p != path {
+			cacheHits += 1
+			totalSize += cachedSize
+			if d.IsDir() {
+				return filepath.SkipDir // Skip this directory as we have its size
+			}
+			return nil
+		}
+
+		// If not in cache, get size of this item
+		size, isDir, err := AllocatedSize(p)
+		if err != nil {
+			return nil
+		}
+
+		// For non-directories, add size and cache it
+		if !isDir {
+			ds.store(p, size)
+			totalSize += size
+		}
+
+		return nil
+	})
+
+	//log.Printf("Total %d of file sizes using cache", cacheHits)
+
+	return totalSize
+}
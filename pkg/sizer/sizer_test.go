@@ -0,0 +1,73 @@
+package sizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInvalidate(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]int{"a/b/one": 4096, "a/two": 4096, "c/three": 4096}
+	for name, size := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New()
+	before := s.Size(root)
+	s.Size(filepath.Join(root, "a"))
+	s.Size(filepath.Join(root, "a", "b"))
+
+	changed := filepath.Join(root, "a", "b", "one")
+	if err := os.WriteFile(changed, make([]byte, 64*1024), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if dropped := s.Invalidate(changed); dropped != 4 {
+		t.Errorf("Invalidate dropped %d sizes, want the file and its 3 parents", dropped)
+	}
+	for _, name := range []string{"", "a", "a/b", "a/b/one"} {
+		if s.checkCache(filepath.Join(root, name)) >= 0 {
+			t.Errorf("size of %q is still cached", name)
+		}
+	}
+	for _, name := range []string{"a/two", "c/three"} {
+		if s.checkCache(filepath.Join(root, name)) < 0 {
+			t.Errorf("size of unrelated %q was dropped", name)
+		}
+	}
+	if after := s.Size(root); after <= before {
+		t.Errorf("Size after invalidation = %d, want more than %d", after, before)
+	}
+}
+
+func TestInvalidateDirectory(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a/one", "a/sub/two", "b/three"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, 4096), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New()
+	s.Size(root)
+	s.Size(filepath.Join(root, "a"))
+	s.Invalidate(filepath.Join(root, "a"))
+	for _, name := range []string{"", "a", "a/one", "a/sub/two"} {
+		if s.checkCache(filepath.Join(root, name)) >= 0 {
+			t.Errorf("size of %q is still cached", name)
+		}
+	}
+	if s.checkCache(filepath.Join(root, "b", "three")) < 0 {
+		t.Error("size of unrelated b/three was dropped")
+	}
+}
@@ -0,0 +1,381 @@
+// Package tracing records spans of API requests and precache jobs and
+// exports them to an OpenTelemetry collector over OTLP/HTTP.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds of the OTLP trace format
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+)
+
+// spanStatusError is the OTLP status code of a failed span
+const spanStatusError = 2
+
+// Limits of spans waiting to be exported
+const (
+	traceBatchSize = 512
+	traceMaxQueued = 8192
+)
+
+// SpanContext identifies a span within its trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// Valid reports whether the span context was set
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{}
+}
+
+// ParseTraceparent reads a W3C traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(header, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	return sc, sc.Valid() && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats the span context as a W3C traceparent header
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-01", sc.TraceID, sc.SpanID)
+}
+
+type spanKey struct{}
+
+// FromContext returns the span context of the span carried by ctx, if
+// any
+func FromContext(ctx context.Context) SpanContext {
+	if sp, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return sp.sc
+	}
+	return SpanContext{}
+}
+
+// Start begins a child of the span carried by ctx and returns a
+// context carrying the child. Without a span in ctx nothing is traced.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok {
+		return ctx, nil
+	}
+	return parent.tracer.StartRoot(ctx, name, SpanKindInternal, parent.sc)
+}
+
+// Span times one operation. A nil span, as started by a nil Tracer,
+// records nothing.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent [8]byte
+	name   string
+	kind   int
+	start  time.Time
+	attrs  map[string]interface{}
+	events []spanEvent
+	err    error
+	mu     sync.Mutex
+}
+
+type spanEvent struct {
+	name  string
+	time  time.Time
+	attrs map[string]interface{}
+}
+
+// SetAttr records a string, integer, float or boolean attribute
+func (sp *Span) SetAttr(key string, value interface{}) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.attrs[key] = value
+	sp.mu.Unlock()
+}
+
+// AddEvent records something that happened during the span, such as a
+// retry
+func (sp *Span) AddEvent(name string, attrs map[string]interface{}) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.events = append(sp.events, spanEvent{name: name, time: time.Now(), attrs: attrs})
+	sp.mu.Unlock()
+}
+
+// SetError marks the span as failed
+func (sp *Span) SetError(err error) {
+	if sp == nil || err == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.err = err
+	sp.mu.Unlock()
+}
+
+// End finishes the span and queues it for export
+func (sp *Span) End() {
+	if sp == nil {
+		return
+	}
+	sp.tracer.enqueue(sp.export(time.Now()))
+}
+
+// AddEvent records an event on the span carried by ctx, if any
+func AddEvent(ctx context.Context, name string, attrs map[string]interface{}) {
+	if sp, ok := ctx.Value(spanKey{}).(*Span); ok {
+		sp.AddEvent(name, attrs)
+	}
+}
+
+// Tracer exports spans to an OpenTelemetry collector over OTLP/HTTP with
+// JSON encoding. A nil Tracer disables tracing.
+type Tracer struct {
+	url     string // Collector traces endpoint, e.g. http://localhost:4318/v1/traces
+	service string
+	client  *http.Client
+	queue   []otlpSpan
+	dropped int
+	flush   chan struct{}
+	mu      sync.Mutex
+}
+
+// New starts exporting spans to the OTLP/HTTP endpoint, the
+// collector's base URL without /v1/traces
+func New(endpoint, service string) *Tracer {
+	t := &Tracer{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		flush:   make(chan struct{}, 1),
+	}
+	go t.exportLoop(5 * time.Second)
+	return t
+}
+
+// StartRoot begins a span of kind under a parent from another request or
+// job, or a new trace if parent is not valid
+func (t *Tracer) StartRoot(ctx context.Context, name string, kind int, parent SpanContext) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	sp := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		attrs:  make(map[string]interface{}),
+	}
+	if parent.Valid() {
+		sp.sc.TraceID = parent.TraceID
+		sp.parent = parent.SpanID
+	} else {
+		rand.Read(sp.sc.TraceID[:])
+	}
+	rand.Read(sp.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+// enqueue keeps a finished span for the next export, dropping it when the
+// collector has fallen too far behind
+func (t *Tracer) enqueue(span otlpSpan) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) >= traceMaxQueued {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, span)
+	if len(t.queue) == traceBatchSize {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// exportLoop sends queued spans every interval, or sooner once a batch
+// is full
+func (t *Tracer) exportLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flush:
+		}
+		t.mu.Lock()
+		spans, dropped := t.queue, t.dropped
+		t.queue, t.dropped = nil, 0
+		t.mu.Unlock()
+
+		if dropped > 0 {
+			slog.Warn("Dropped spans the collector could not keep up with", "spans", dropped)
+		}
+		for len(spans) > 0 {
+			n := min(len(spans), traceBatchSize)
+			if err := t.export(spans[:n]); err != nil {
+				slog.Warn("Error exporting spans", "url", t.url, "spans", len(spans), "error", err)
+				break
+			}
+			spans = spans[n:]
+		}
+	}
+}
+
+// Flush exports the queued spans, for use before exiting
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	spans := t.queue
+	t.queue = nil
+	t.mu.Unlock()
+	for len(spans) > 0 {
+		n := min(len(spans), traceBatchSize)
+		if err := t.export(spans[:n]); err != nil {
+			slog.Warn("Error exporting spans", "url", t.url, "spans", len(spans), "error", err)
+			return
+		}
+		spans = spans[n:]
+	}
+}
+
+// export posts one batch of spans
+func (t *Tracer) export(spans []otlpSpan) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": t.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "rclone-precache"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpSpan is a finished span in the OTLP JSON encoding
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Events       []otlpEvent     `json:"events,omitempty"`
+	Status       *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpEvent struct {
+	Time       string          `json:"timeUnixNano"`
+	Name       string          `json:"name"`
+	Attributes []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpAttributes encodes attributes, with integers as strings as the
+// JSON encoding requires
+func otlpAttributes(attrs map[string]interface{}) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attrs))
+	for key, value := range attrs {
+		var v map[string]interface{}
+		switch value := value.(type) {
+		case string:
+			v = map[string]interface{}{"stringValue": value}
+		case bool:
+			v = map[string]interface{}{"boolValue": value}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": value}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+		}
+		encoded = append(encoded, otlpAttribute{Key: key, Value: v})
+	}
+	return encoded
+}
+
+// unixNano formats a time as the OTLP JSON encoding expects
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// export converts the span to its OTLP form
+func (sp *Span) export(end time.Time) otlpSpan {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	span := otlpSpan{
+		TraceID:    hex.EncodeToString(sp.sc.TraceID[:]),
+		SpanID:     hex.EncodeToString(sp.sc.SpanID[:]),
+		Name:       sp.name,
+		Kind:       sp.kind,
+		Start:      unixNano(sp.start),
+		End:        unixNano(end),
+		Attributes: otlpAttributes(sp.attrs),
+	}
+	if sp.parent != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(sp.parent[:])
+	}
+	for _, event := range sp.events {
+		span.Events = append(span.Events, otlpEvent{Time: unixNano(event.time), Name: event.name, Attributes: otlpAttributes(event.attrs)})
+	}
+	if sp.err != nil {
+		span.Status = &otlpStatus{Code: spanStatusError, Message: sp.err.Error()}
+	}
+	return span
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlexClient looks up media file paths through the Plex API, since Plex
+// webhooks only carry metadata keys
+type PlexClient struct {
+	url    string
+	token  string
+	client *http.Client
+	ahead  int // Episodes to precache after the one being played
+}
+
+// NewPlexClient creates a client for the Plex server at baseURL
+func NewPlexClient(baseURL, token string, ahead int) *PlexClient {
+	return &PlexClient{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+		ahead:  ahead,
+	}
+}
+
+// PlexWebhook is the part of a Plex webhook payload used for precaching
+type PlexWebhook struct {
+	Event    string `json:"event"`
+	Metadata struct {
+		RatingKey        string `json:"ratingKey"`
+		Type             string `json:"type"`
+		Title            string `json:"title"`
+		GrandparentTitle string `json:"grandparentTitle"`
+	} `json:"Metadata"`
+}
+
+// filePath returns the path of the first file of a library item
+func (p *PlexClient) filePath(ratingKey string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url+"/library/metadata/"+url.PathEscape(ratingKey), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Plex-Token", p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("plex returned %s", resp.Status)
+	}
+
+	var body struct {
+		MediaContainer struct {
+			Metadata []struct {
+				Media []struct {
+					Part []struct {
+						File string `json:"file"`
+					} `json:"Part"`
+				} `json:"Media"`
+			} `json:"Metadata"`
+		} `json:"MediaContainer"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	for _, item := range body.MediaContainer.Metadata {
+		for _, media := range item.Media {
+			for _, part := range media.Part {
+				if part.File != "" {
+					return part.File, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("no file found for item %s", ratingKey)
+}
+
+// handlePlexWebhook precaches the episodes following one that started
+// playing or was watched. Plex posts the payload as a multipart form field.
+func (s *Server) handlePlexWebhook(c *gin.Context) {
+	if s.plex == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Plex integration is not configured"})
+		return
+	}
+
+	var hook PlexWebhook
+	payload := c.PostForm("payload")
+	if payload == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing payload"})
+		return
+	}
+	if err := json.Unmarshal([]byte(payload), &hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch hook.Event {
+	case "media.play", "media.resume", "media.scrobble":
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Ignored %s event", hook.Event)})
+		return
+	}
+	if hook.Metadata.Type != "episode" {
+		c.JSON(http.StatusOK, gin.H{"message": "Ignored item that is not an episode"})
+		return
+	}
+
+	file, err := s.plex.filePath(hook.Metadata.RatingKey)
+	if err != nil {
+		slog.Error("Error looking up Plex item", "rating_key", hook.Metadata.RatingKey, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	reqPath, ok := s.externalPath(file)
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("No path mapping for %s", file)})
+		return
+	}
+
+	var ids []string
+	for _, next := range s.nextEpisodes(reqPath, s.plex.ahead) {
+		job, err := s.queuePath(next, originOf(c), s.defaultOptions(next))
+		if err != nil {
+			slog.Error("Error queueing next episode", "path", next, "error", err)
+			continue
+		}
+		ids = append(ids, job.ID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Caching %d episodes after %s", len(ids), reqPath),
+		"job_ids": ids,
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// probePaths are served without authentication so probes need no
+// credentials. They report check names and errors, not mount contents.
+var probePaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+// ReadyCheck is the outcome of one readiness check
+type ReadyCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// checkWritable creates and removes a file in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// handleHealthz answers as long as the process serves requests, for
+// liveness probes
+func (s *Server) handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz reports whether mounts respond, cache directories are
+// writable and the job queue moves, with 503 if any check fails. Meant for
+// Docker HEALTHCHECK and Kubernetes readiness probes.
+func (s *Server) handleReadyz(c *gin.Context) {
+	var checks []ReadyCheck
+	add := func(name string, err error) {
+		check := ReadyCheck{Name: name, OK: err == nil}
+		if err != nil {
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	for _, m := range s.mounts {
+		suffix := ""
+		if m.Name != "" {
+			suffix = ":" + m.Name
+		}
+		if m.health != nil {
+			var err error
+			if status := m.health.Status(); !status.Healthy {
+				err = fmt.Errorf("%s", status.Error)
+			}
+			add("mount"+suffix, err)
+		}
+		add("cache"+suffix, checkWritable(m.CachePath))
+	}
+	add("queue", s.cacheManager.QueueStalled())
+
+	code := http.StatusOK
+	ready := true
+	for _, check := range checks {
+		if !check.OK {
+			ready = false
+			code = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, gin.H{"ready": ready, "checks": checks})
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setChildAttributes makes the kernel stop a managed child process when
+// this server dies, so no orphaned mount is left behind
+func setChildAttributes(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+import "os/exec"
+
+// setChildAttributes has nothing to set on this platform
+func setChildAttributes(cmd *exec.Cmd) {}
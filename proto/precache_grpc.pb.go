@@ -0,0 +1,254 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: precache.proto
+
+// Package precache.v1 is the gRPC API of rclone-precache. It mirrors the
+// REST API: paths are rooted at the mount, or at the mount names when
+// several mounts are served, and callers authenticate with the same
+// credentials sent as metadata, e.g. x-api-key or authorization.
+
+package precachepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Precache_Browse_FullMethodName         = "/precache.v1.Precache/Browse"
+	Precache_StartJob_FullMethodName       = "/precache.v1.Precache/StartJob"
+	Precache_StreamProgress_FullMethodName = "/precache.v1.Precache/StreamProgress"
+	Precache_CancelJob_FullMethodName      = "/precache.v1.Precache/CancelJob"
+)
+
+// PrecacheClient is the client API for Precache service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PrecacheClient interface {
+	// Browse lists a directory with the bytes of each entry already cached
+	Browse(ctx context.Context, in *BrowseRequest, opts ...grpc.CallOption) (*BrowseResponse, error)
+	// StartJob starts caching a file or directory
+	StartJob(ctx context.Context, in *StartJobRequest, opts ...grpc.CallOption) (*Job, error)
+	// StreamProgress sends global and per-job progress about once a second
+	// until the client goes away, or the watched job finishes
+	StreamProgress(ctx context.Context, in *StreamProgressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ProgressUpdate], error)
+	// CancelJob cancels a queued or running job
+	CancelJob(ctx context.Context, in *CancelJobRequest, opts ...grpc.CallOption) (*CancelJobResponse, error)
+}
+
+type precacheClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPrecacheClient(cc grpc.ClientConnInterface) PrecacheClient {
+	return &precacheClient{cc}
+}
+
+func (c *precacheClient) Browse(ctx context.Context, in *BrowseRequest, opts ...grpc.CallOption) (*BrowseResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BrowseResponse)
+	err := c.cc.Invoke(ctx, Precache_Browse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *precacheClient) StartJob(ctx context.Context, in *StartJobRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, Precache_StartJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *precacheClient) StreamProgress(ctx context.Context, in *StreamProgressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ProgressUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Precache_ServiceDesc.Streams[0], Precache_StreamProgress_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamProgressRequest, ProgressUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Precache_StreamProgressClient = grpc.ServerStreamingClient[ProgressUpdate]
+
+func (c *precacheClient) CancelJob(ctx context.Context, in *CancelJobRequest, opts ...grpc.CallOption) (*CancelJobResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelJobResponse)
+	err := c.cc.Invoke(ctx, Precache_CancelJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PrecacheServer is the server API for Precache service.
+// All implementations must embed UnimplementedPrecacheServer
+// for forward compatibility.
+type PrecacheServer interface {
+	// Browse lists a directory with the bytes of each entry already cached
+	Browse(context.Context, *BrowseRequest) (*BrowseResponse, error)
+	// StartJob starts caching a file or directory
+	StartJob(context.Context, *StartJobRequest) (*Job, error)
+	// StreamProgress sends global and per-job progress about once a second
+	// until the client goes away, or the watched job finishes
+	StreamProgress(*StreamProgressRequest, grpc.ServerStreamingServer[ProgressUpdate]) error
+	// CancelJob cancels a queued or running job
+	CancelJob(context.Context, *CancelJobRequest) (*CancelJobResponse, error)
+	mustEmbedUnimplementedPrecacheServer()
+}
+
+// UnimplementedPrecacheServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPrecacheServer struct{}
+
+func (UnimplementedPrecacheServer) Browse(context.Context, *BrowseRequest) (*BrowseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Browse not implemented")
+}
+func (UnimplementedPrecacheServer) StartJob(context.Context, *StartJobRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartJob not implemented")
+}
+func (UnimplementedPrecacheServer) StreamProgress(*StreamProgressRequest, grpc.ServerStreamingServer[ProgressUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamProgress not implemented")
+}
+func (UnimplementedPrecacheServer) CancelJob(context.Context, *CancelJobRequest) (*CancelJobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelJob not implemented")
+}
+func (UnimplementedPrecacheServer) mustEmbedUnimplementedPrecacheServer() {}
+func (UnimplementedPrecacheServer) testEmbeddedByValue()                  {}
+
+// UnsafePrecacheServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PrecacheServer will
+// result in compilation errors.
+type UnsafePrecacheServer interface {
+	mustEmbedUnimplementedPrecacheServer()
+}
+
+func RegisterPrecacheServer(s grpc.ServiceRegistrar, srv PrecacheServer) {
+	// If the following call pancis, it indicates UnimplementedPrecacheServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Precache_ServiceDesc, srv)
+}
+
+func _Precache_Browse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BrowseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PrecacheServer).Browse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Precache_Browse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PrecacheServer).Browse(ctx, req.(*BrowseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Precache_StartJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PrecacheServer).StartJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Precache_StartJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PrecacheServer).StartJob(ctx, req.(*StartJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Precache_StreamProgress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamProgressRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PrecacheServer).StreamProgress(m, &grpc.GenericServerStream[StreamProgressRequest, ProgressUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Precache_StreamProgressServer = grpc.ServerStreamingServer[ProgressUpdate]
+
+func _Precache_CancelJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PrecacheServer).CancelJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Precache_CancelJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PrecacheServer).CancelJob(ctx, req.(*CancelJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Precache_ServiceDesc is the grpc.ServiceDesc for Precache service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Precache_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "precache.v1.Precache",
+	HandlerType: (*PrecacheServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Browse",
+			Handler:    _Precache_Browse_Handler,
+		},
+		{
+			MethodName: "StartJob",
+			Handler:    _Precache_StartJob_Handler,
+		},
+		{
+			MethodName: "CancelJob",
+			Handler:    _Precache_CancelJob_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamProgress",
+			Handler:       _Precache_StreamProgress_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "precache.proto",
+}
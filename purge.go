@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/fffonion/rclone-precache/pkg/sizer"
+	"github.com/gin-gonic/gin"
+)
+
+// PurgeResult reports what a cache purge removed or, in a dry run, would
+// remove
+type PurgeResult struct {
+	Path       string `json:"path"`
+	Files      int    `json:"files"`
+	BytesFreed int64  `json:"bytes_freed"`
+	DryRun     bool   `json:"dry_run"`
+}
+
+// purgeCache removes the cache files below reqPath, leaving the state
+// directory alone. Directories emptied by the purge are removed too, except
+// the cache root. With a remote control configured, rclone is told to
+// forget the purged path.
+func (s *Server) purgeCache(reqPath string, dryRun bool) (PurgeResult, error) {
+	result := PurgeResult{Path: reqPath, DryRun: dryRun}
+	m, inner, err := s.locate(reqPath)
+	if err != nil {
+		return result, err
+	}
+	target := filepath.Join(m.CachePath, inner)
+	info, err := os.Lstat(target)
+	if err != nil {
+		return result, err
+	}
+
+	var dirs []string
+	err = filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == s.stateDir {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size, _ := sizer.FileUsage(info)
+		if !dryRun {
+			if err := s.removeCacheFile(path); err != nil {
+				return err
+			}
+		}
+		result.Files++
+		result.BytesFreed += size
+		return nil
+	})
+	if dryRun {
+		return result, err
+	}
+	s.sizer.Invalidate(target)
+
+	// Deepest first, so parents are empty by the time they are reached
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if dirs[i] != filepath.Clean(m.CachePath) {
+			os.Remove(dirs[i])
+		}
+	}
+	if err == nil {
+		slog.Info("Purged cache", "path", reqPath, "files", result.Files, "bytes_freed", result.BytesFreed)
+	}
+	if s.rc != nil {
+		if err := s.rc.syncVFS(reqPath, info.IsDir()); err != nil {
+			slog.Error("Error syncing rclone VFS after purge", "path", reqPath, "error", err)
+		}
+	}
+	return result, err
+}
+
+// handlePurgeCache deletes the cached data of a file or directory. With
+// dry_run=true it only reports how many bytes would be freed. Paths with an
+// unfinished job can't be purged.
+func (s *Server) handlePurgeCache(c *gin.Context) {
+	reqPath := cleanPath(c.Param("path"))
+	dryRun := false
+	if v := c.Query("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid dry_run %q", v)})
+			return
+		}
+	}
+	if s.named() && reqPath == "/" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Purge a single mount"})
+		return
+	}
+	if s.cacheManager.ProtectedPath(reqPath) {
+		c.JSON(http.StatusConflict, gin.H{"error": cache.ErrJobExists.Error()})
+		return
+	}
+
+	result, err := s.purgeCache(reqPath, dryRun)
+	if errors.Is(err, ErrUnknownMount) || errors.Is(err, os.ErrNotExist) && result.Files == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not cached"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
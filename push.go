@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"gopkg.in/yaml.v3"
+)
+
+// pushConfig are the settings of an ntfy or gotify notifications entry
+type pushConfig struct {
+	URL           string `yaml:"url"`   // ntfy topic URL or Gotify server URL
+	Token         string `yaml:"token"` // ntfy access token or Gotify application token
+	Username      string `yaml:"username"`
+	Password      string `yaml:"password"`
+	Priority      int    `yaml:"priority"`
+	ErrorPriority int    `yaml:"error-priority"` // Priority of failed jobs
+}
+
+// pushMessage is a notification for a phone
+type pushMessage struct {
+	title    string
+	body     string
+	failed   bool
+	ntfyTags string // Emoji shortcodes shown by ntfy
+}
+
+// pushJobMessage describes a job event for a push notification
+func pushJobMessage(event string, job cache.JobSummary) pushMessage {
+	switch event {
+	case notifyQueued:
+		return pushMessage{title: "Queued", body: job.Path, ntfyTags: "hourglass"}
+	case notifyStarted:
+		return pushMessage{title: "Caching", body: fmt.Sprintf("%s (%s)", job.Path, formatSize(job.TotalSize)), ntfyTags: "arrow_forward"}
+	case notifyCompleted:
+		return pushMessage{title: "Cached", body: fmt.Sprintf("%s\n%s in %s", job.Path, formatSize(job.BytesRead), formatETA(&job.Duration)), ntfyTags: "white_check_mark"}
+	}
+	msg := pushMessage{title: "Failed", failed: true, ntfyTags: "x"}
+	if job.State == cache.StateCancelled {
+		msg = pushMessage{title: "Cancelled", ntfyTags: "no_entry"}
+	}
+	msg.body = fmt.Sprintf("%s\n%s of %s", job.Path, formatSize(job.CachedSize), formatSize(job.TotalSize))
+	if job.ErrorCount > 0 {
+		msg.body += fmt.Sprintf(", %d errors", job.ErrorCount)
+		if len(job.Errors) > 0 {
+			msg.body += "\n" + job.Errors[0].Path + ": " + job.Errors[0].Error
+		}
+	}
+	return msg
+}
+
+// pushNotifier sends job events to a push service through send
+type pushNotifier struct {
+	service string
+	send    func(msg pushMessage) error
+}
+
+func (p *pushNotifier) notify(event string, job cache.JobSummary) {
+	if err := p.send(pushJobMessage(event, job)); err != nil {
+		// Keep tokens in URLs out of logs
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		slog.Warn("Error sending push notification", "service", p.service, "job", job.ID, "error", err)
+	}
+}
+
+func (p *pushNotifier) JobQueued(job cache.JobSummary) {
+	p.notify(notifyQueued, job)
+}
+
+func (p *pushNotifier) JobStarted(job cache.JobSummary) {
+	p.notify(notifyStarted, job)
+}
+
+func (p *pushNotifier) JobCompleted(job cache.JobSummary) {
+	p.notify(notifyCompleted, job)
+}
+
+func (p *pushNotifier) JobFailed(job cache.JobSummary) {
+	p.notify(notifyFailed, job)
+}
+
+// decodePushConfig reads the settings of a push entry, filling in the
+// service's default priorities
+func decodePushConfig(entry *yaml.Node, priority, errorPriority int) (pushConfig, error) {
+	var config pushConfig
+	if err := entry.Decode(&config); err != nil {
+		return config, err
+	}
+	if u, err := url.Parse(config.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return config, fmt.Errorf("invalid url %q", config.URL)
+	}
+	if config.Priority == 0 {
+		config.Priority = priority
+	}
+	if config.ErrorPriority == 0 {
+		config.ErrorPriority = errorPriority
+	}
+	return config, nil
+}
+
+// postPush sends a push request and checks the reply
+func postPush(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("push service returned %s", resp.Status)
+	}
+	return nil
+}
+
+// newNtfyNotifier publishes to an ntfy topic URL such as
+// https://ntfy.sh/my-precache
+func newNtfyNotifier(_ *Server, entry *yaml.Node) (cache.Notifier, error) {
+	config, err := decodePushConfig(entry, 3, 4)
+	if err != nil {
+		return nil, err
+	}
+	if config.Priority > 5 || config.ErrorPriority > 5 {
+		return nil, fmt.Errorf("ntfy priorities range from 1 to 5")
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	send := func(msg pushMessage) error {
+		req, err := http.NewRequest(http.MethodPost, config.URL, strings.NewReader(msg.body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Title", msg.title)
+		req.Header.Set("Tags", msg.ntfyTags)
+		priority := config.Priority
+		if msg.failed {
+			priority = config.ErrorPriority
+		}
+		req.Header.Set("Priority", fmt.Sprint(priority))
+		if config.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+config.Token)
+		} else if config.Username != "" {
+			req.SetBasicAuth(config.Username, config.Password)
+		}
+		return postPush(client, req)
+	}
+	return &pushNotifier{service: "ntfy", send: send}, nil
+}
+
+// newGotifyNotifier sends messages to a Gotify server with an application
+// token
+func newGotifyNotifier(_ *Server, entry *yaml.Node) (cache.Notifier, error) {
+	config, err := decodePushConfig(entry, 5, 8)
+	if err != nil {
+		return nil, err
+	}
+	if config.Token == "" {
+		return nil, fmt.Errorf("token is required")
+	}
+	endpoint := strings.TrimSuffix(config.URL, "/") + "/message"
+	client := &http.Client{Timeout: 10 * time.Second}
+	send := func(msg pushMessage) error {
+		priority := config.Priority
+		if msg.failed {
+			priority = config.ErrorPriority
+		}
+		body, err := json.Marshal(map[string]interface{}{
+			"title":    msg.title,
+			"message":  msg.body,
+			"priority": priority,
+		})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", config.Token)
+		return postPush(client, req)
+	}
+	return &pushNotifier{service: "gotify", send: send}, nil
+}
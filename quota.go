@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultCandidateLimit bounds the eviction candidates listed by the API
+const defaultCandidateLimit = 50
+
+// EnableQuota limits the cache directories to limit bytes in total. Jobs evict least
+// recently used files to make room before they start. Files of unfinished
+// jobs and pinned paths are kept.
+func (s *Server) EnableQuota(limit int64) {
+	dirs := make([]cache.QuotaDir, 0, len(s.mounts))
+	for _, m := range s.mounts {
+		dirs = append(dirs, cache.QuotaDir{Prefix: m.Name, Path: m.CachePath})
+	}
+	quota := cache.NewQuota(dirs, s.stateDir, limit, func(path string) bool {
+		return s.cacheManager.ProtectedPath(path) || s.pins.covers(path)
+	}, s.removeCacheFile)
+	s.cacheManager.SetQuota(quota)
+	go quota.Run()
+}
+
+// handleQuota reports cache usage against the quota and the files that
+// would be evicted first. The limit query parameter bounds the list.
+func (s *Server) handleQuota(c *gin.Context) {
+	quota := s.cacheManager.Quota()
+	if quota == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cache quota is not configured"})
+		return
+	}
+
+	limit := defaultCandidateLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	candidates, used := quota.Candidates()
+	c.JSON(http.StatusOK, gin.H{
+		"quota":      quota.Limit(),
+		"used":       used,
+		"over":       max(used-quota.Limit(), 0),
+		"candidates": candidates[:min(limit, len(candidates))],
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RadarrWebhook is the part of a Radarr webhook payload used for precaching
+type RadarrWebhook struct {
+	EventType string `json:"eventType"`
+	Movie     struct {
+		Title      string `json:"title"`
+		FolderPath string `json:"folderPath"`
+	} `json:"movie"`
+	MovieFile *struct {
+		Path string `json:"path"`
+	} `json:"movieFile"`
+	RenamedMovieFiles []struct {
+		Path         string `json:"path"`
+		PreviousPath string `json:"previousPath"`
+	} `json:"renamedMovieFiles"`
+}
+
+// handleRadarrWebhook precaches movies imported by Radarr. Imports cache
+// the new movie file, renames cancel jobs for the old paths and cache the
+// movie folder again.
+func (s *Server) handleRadarrWebhook(c *gin.Context) {
+	var hook RadarrWebhook
+	if err := c.ShouldBindJSON(&hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var target string
+	switch hook.EventType {
+	case "Test":
+		c.JSON(http.StatusOK, gin.H{"message": "Test received"})
+		return
+	case "Download":
+		target = hook.Movie.FolderPath
+		if hook.MovieFile != nil && hook.MovieFile.Path != "" {
+			target = hook.MovieFile.Path
+		}
+	case "Rename":
+		for _, renamed := range hook.RenamedMovieFiles {
+			if oldPath, ok := s.externalPath(renamed.PreviousPath); ok {
+				s.cancelPath(oldPath, c.GetString("user"))
+			}
+		}
+		target = hook.Movie.FolderPath
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Ignored %s event", hook.EventType)})
+		return
+	}
+
+	reqPath, ok := s.externalPath(target)
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("No path mapping for %s", target)})
+		return
+	}
+	job, err := s.queuePath(reqPath, originOf(c), s.defaultOptions(reqPath))
+	if err != nil {
+		c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Caching %s for %s", reqPath, hook.Movie.Title),
+		"job_id":  job.ID,
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+)
+
+// RcClient calls the remote control API of the rclone process serving the
+// mount, e.g. one started with --rc
+type RcClient struct {
+	url    string
+	user   string
+	pass   string
+	fs     string // VFS to address when rclone serves several, empty for the only one
+	client *http.Client
+}
+
+// NewRcClient creates a client for the rclone remote control at baseURL
+func NewRcClient(baseURL, user, pass, fs string) *RcClient {
+	return &RcClient{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		user:   user,
+		pass:   pass,
+		fs:     fs,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// call posts params to an rc method and decodes the reply into out, which
+// may be nil
+func (rc *RcClient) call(method string, params map[string]interface{}, out interface{}) error {
+	if rc.fs != "" {
+		params["fs"] = rc.fs
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, rc.url+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if rc.user != "" {
+		req.SetBasicAuth(rc.user, rc.pass)
+	}
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// rclone explains failures in an "error" field
+		var rcErr struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &rcErr) == nil && rcErr.Error != "" {
+			return fmt.Errorf("%s: %s", method, rcErr.Error)
+		}
+		return fmt.Errorf("%s returned %s", method, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// vfsPath turns a mount-relative path into the path rclone's VFS expects
+func vfsPath(reqPath string) string {
+	return strings.TrimPrefix(path.Clean("/"+reqPath), "/")
+}
+
+// forget drops a file or directory from the VFS directory cache so rclone
+// re-reads its metadata
+func (rc *RcClient) forget(reqPath string, isDir bool) error {
+	key := "file"
+	if isDir {
+		key = "dir"
+	}
+	return rc.call("vfs/forget", map[string]interface{}{key: vfsPath(reqPath)}, nil)
+}
+
+// refresh re-reads the listing of a directory, but not its subdirectories,
+// into the VFS directory cache
+func (rc *RcClient) refresh(reqPath string) error {
+	return rc.call("vfs/refresh", map[string]interface{}{"dir": vfsPath(reqPath), "recursive": "false"}, nil)
+}
+
+// syncVFS tells rclone that cached data below reqPath was removed behind its
+// back, so its in-memory state matches the cache directory again
+func (rc *RcClient) syncVFS(reqPath string, isDir bool) error {
+	if err := rc.forget(reqPath, isDir); err != nil {
+		return err
+	}
+	return rc.refresh(path.Dir(cleanPath(reqPath)))
+}
+
+// VFSStats fetches the VFS cache statistics
+func (rc *RcClient) VFSStats() (cache.VFSStats, error) {
+	var reply struct {
+		Fs        string `json:"fs"`
+		DiskCache struct {
+			BytesUsed         int64 `json:"bytesUsed"`
+			Files             int   `json:"files"`
+			ErroredFiles      int   `json:"erroredFiles"`
+			OutOfSpace        bool  `json:"outOfSpace"`
+			UploadsInProgress int   `json:"uploadsInProgress"`
+			UploadsQueued     int   `json:"uploadsQueued"`
+		} `json:"diskCache"`
+	}
+	if err := rc.call("vfs/stats", map[string]interface{}{}, &reply); err != nil {
+		return cache.VFSStats{}, err
+	}
+	return cache.VFSStats{
+		Fs:                reply.Fs,
+		CacheBytesUsed:    reply.DiskCache.BytesUsed,
+		CacheFiles:        reply.DiskCache.Files,
+		UploadsInProgress: reply.DiskCache.UploadsInProgress,
+		UploadsQueued:     reply.DiskCache.UploadsQueued,
+		ErroredFiles:      reply.DiskCache.ErroredFiles,
+		OutOfSpace:        reply.DiskCache.OutOfSpace,
+		UpdatedAt:         time.Now(),
+	}, nil
+}
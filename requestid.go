@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/fffonion/rclone-precache/pkg/tracing"
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength bounds IDs accepted from clients and proxies
+const maxRequestIDLength = 128
+
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether a client supplied ID is safe to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID tags each request with the ID sent by a proxy or a new one,
+// and returns it in the response
+func requestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	c.Set("request_id", id)
+	c.Header(requestIDHeader, id)
+}
+
+// originOf returns who sent an API request
+func originOf(c *gin.Context) cache.JobOrigin {
+	return cache.JobOrigin{
+		ClientIP:  c.ClientIP(),
+		User:      c.GetString("user"),
+		RequestID: c.GetString("request_id"),
+		Trace:     tracing.FromContext(c.Request.Context()),
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/gin-gonic/gin"
+)
+
+// scheduleTick is how often the scheduler looks for due schedules
+const scheduleTick = 30 * time.Second
+
+// minScheduleInterval keeps schedules from flooding the job queue
+const minScheduleInterval = time.Minute
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule precaches a path repeatedly. Incremental schedules only cache
+// files modified since their previous run.
+type Schedule struct {
+	ID          string                 `json:"id"`
+	Path        string                 `json:"path"`
+	Interval    string                 `json:"interval"`
+	Incremental bool                   `json:"incremental"`
+	Options     map[string]interface{} `json:"options,omitempty"` // Same names and formats as the precache query parameters
+	CreatedAt   time.Time              `json:"created_at"`
+	LastRun     *time.Time             `json:"last_run,omitempty"`
+	NextRun     time.Time              `json:"next_run"`
+	LastJobID   string                 `json:"last_job_id,omitempty"`
+	LastError   string                 `json:"last_error,omitempty"`
+	Configured  bool                   `json:"configured,omitempty"` // Defined in the config file
+
+	interval time.Duration
+}
+
+// Scheduler starts jobs for due schedules and keeps them in schedules.json
+type Scheduler struct {
+	path      string
+	run       func(Schedule) (string, error) // Starts a job, returning its ID
+	schedules map[string]*Schedule
+	mu        sync.Mutex
+}
+
+// NewScheduler creates a scheduler backed by schedules.json inside dir
+func NewScheduler(dir string, run func(Schedule) (string, error)) *Scheduler {
+	return &Scheduler{
+		path:      filepath.Join(dir, "schedules.json"),
+		run:       run,
+		schedules: make(map[string]*Schedule),
+	}
+}
+
+// Load reads saved schedules, keeping none if the file is missing
+func (sc *Scheduler) Load() error {
+	data, err := os.ReadFile(sc.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var schedules []*Schedule
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		return err
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, schedule := range schedules {
+		if schedule.interval, err = parseDuration(schedule.Interval); err != nil {
+			slog.Warn("Dropping schedule", "schedule", schedule.ID, "path", schedule.Path, "error", err)
+			continue
+		}
+		sc.schedules[schedule.ID] = schedule
+	}
+	return nil
+}
+
+// Start runs due schedules in the background
+func (sc *Scheduler) Start() {
+	go func() {
+		sc.runDue(time.Now())
+		for now := range time.Tick(scheduleTick) {
+			sc.runDue(now)
+		}
+	}()
+}
+
+// runDue starts a job for every schedule whose next run has passed
+func (sc *Scheduler) runDue(now time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	changed := false
+	for _, schedule := range sc.schedules {
+		if now.Before(schedule.NextRun) {
+			continue
+		}
+		jobID, err := sc.run(*schedule)
+		schedule.NextRun = now.Add(schedule.interval)
+		changed = true
+		if err != nil {
+			// A still running earlier job is not an error, just try again later
+			if !errors.Is(err, cache.ErrJobExists) {
+				slog.Error("Error running schedule", "schedule", schedule.ID, "path", schedule.Path, "error", err)
+				schedule.LastError = err.Error()
+			}
+			continue
+		}
+		runAt := now
+		schedule.LastRun = &runAt
+		schedule.LastJobID = jobID
+		schedule.LastError = ""
+	}
+	if changed {
+		sc.save()
+	}
+}
+
+// Add validates and saves a new schedule, which first runs right away
+func (sc *Scheduler) Add(schedule Schedule) (Schedule, error) {
+	interval, err := parseDuration(schedule.Interval)
+	if err != nil {
+		return schedule, err
+	}
+	if interval < minScheduleInterval {
+		return schedule, fmt.Errorf("interval must be at least %v", minScheduleInterval)
+	}
+
+	schedule.ID = cache.NewID()
+	schedule.interval = interval
+	schedule.CreatedAt = time.Now()
+	schedule.NextRun = schedule.CreatedAt
+	schedule.LastRun = nil
+
+	sc.mu.Lock()
+	sc.schedules[schedule.ID] = &schedule
+	sc.save()
+	sc.mu.Unlock()
+
+	sc.runDue(time.Now())
+	return sc.Get(schedule.ID)
+}
+
+// Configure replaces the schedules defined in the config file. A schedule
+// keeps its run times across restarts while its path and interval stay the
+// same. IDs default to "config:" and the path.
+func (sc *Scheduler) Configure(schedules []Schedule) error {
+	configured := make(map[string]*Schedule, len(schedules))
+	for _, schedule := range schedules {
+		schedule.Path = cleanPath(schedule.Path)
+		if schedule.ID == "" {
+			schedule.ID = "config:" + schedule.Path
+		}
+		if configured[schedule.ID] != nil {
+			return fmt.Errorf("duplicate schedule %s", schedule.ID)
+		}
+		interval, err := parseDuration(schedule.Interval)
+		if err != nil {
+			return fmt.Errorf("schedule %s: %w", schedule.ID, err)
+		}
+		if interval < minScheduleInterval {
+			return fmt.Errorf("schedule %s: interval must be at least %v", schedule.ID, minScheduleInterval)
+		}
+		schedule.interval = interval
+		schedule.Configured = true
+		configured[schedule.ID] = &schedule
+	}
+
+	sc.mu.Lock()
+	for id, saved := range sc.schedules {
+		if saved.Configured && configured[id] == nil {
+			delete(sc.schedules, id)
+		}
+	}
+	now := time.Now()
+	for id, schedule := range configured {
+		saved, exists := sc.schedules[id]
+		if exists && saved.Path == schedule.Path && saved.Interval == schedule.Interval {
+			schedule.CreatedAt = saved.CreatedAt
+			schedule.LastRun = saved.LastRun
+			schedule.NextRun = saved.NextRun
+			schedule.LastJobID = saved.LastJobID
+			schedule.LastError = saved.LastError
+		} else {
+			schedule.CreatedAt = now
+			schedule.NextRun = now
+		}
+		sc.schedules[id] = schedule
+	}
+	sc.save()
+	sc.mu.Unlock()
+
+	sc.runDue(time.Now())
+	return nil
+}
+
+// Get returns a copy of a schedule
+func (sc *Scheduler) Get(id string) (Schedule, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	schedule, exists := sc.schedules[id]
+	if !exists {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	return *schedule, nil
+}
+
+// Remove deletes a schedule. Jobs it already started keep running.
+func (sc *Scheduler) Remove(id string) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if _, exists := sc.schedules[id]; !exists {
+		return ErrScheduleNotFound
+	}
+	delete(sc.schedules, id)
+	sc.save()
+	return nil
+}
+
+// List returns all schedules, oldest first
+func (sc *Scheduler) List() []Schedule {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	schedules := make([]Schedule, 0, len(sc.schedules))
+	for _, schedule := range sc.schedules {
+		schedules = append(schedules, *schedule)
+	}
+	sort.Slice(schedules, func(i, k int) bool {
+		return schedules[i].CreatedAt.Before(schedules[k].CreatedAt)
+	})
+	return schedules
+}
+
+// save writes all schedules to disk. Caller must hold the lock.
+func (sc *Scheduler) save() {
+	schedules := make([]*Schedule, 0, len(sc.schedules))
+	for _, schedule := range sc.schedules {
+		schedules = append(schedules, schedule)
+	}
+	if err := cache.SaveJSON(sc.path, schedules); err != nil {
+		slog.Error("Error saving schedules", "error", err)
+	}
+}
+
+// runSchedule starts the job for a due schedule. Incremental schedules
+// only pick up files modified since their last run.
+func (s *Server) runSchedule(schedule Schedule) (string, error) {
+	opts, err := s.scheduleOptions(schedule)
+	if err != nil {
+		return "", err
+	}
+	if schedule.Incremental && schedule.LastRun != nil {
+		if opts.NewerThan == nil || schedule.LastRun.After(*opts.NewerThan) {
+			since := *schedule.LastRun
+			opts.NewerThan = &since
+		}
+	}
+
+	reqPath := cleanPath(schedule.Path)
+	sourcePath, cachePath, err := s.paths(reqPath)
+	if err != nil {
+		return "", err
+	}
+	job, err := s.cacheManager.StartJob(reqPath, sourcePath, cachePath, cache.JobOrigin{}, opts)
+	if err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// scheduleOptions parses a schedule's job options
+func (s *Server) scheduleOptions(schedule Schedule) (cache.JobOptions, error) {
+	query, err := optionValues(schedule.Options)
+	if err != nil {
+		return cache.JobOptions{}, err
+	}
+	return s.parseJobOptions(schedule.Path, query)
+}
+
+// ConfigureSchedules replaces the schedules defined in the config file
+func (s *Server) ConfigureSchedules(schedules []Schedule) error {
+	for _, schedule := range schedules {
+		if _, err := s.scheduleOptions(schedule); err != nil {
+			return fmt.Errorf("schedule for %s: %w", schedule.Path, err)
+		}
+	}
+	return s.scheduler.Configure(schedules)
+}
+
+// handleListSchedules returns all schedules
+func (s *Server) handleListSchedules(c *gin.Context) {
+	c.JSON(http.StatusOK, s.scheduler.List())
+}
+
+// handleCreateSchedule adds a schedule from a JSON body with path,
+// interval such as 6h or 1d, incremental and options
+func (s *Server) handleCreateSchedule(c *gin.Context) {
+	var schedule Schedule
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	schedule.Path = cleanPath(schedule.Path)
+	setAuditTarget(c, schedule.Path)
+	if !s.exists(schedule.Path) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Path not found"})
+		return
+	}
+	if _, err := s.scheduleOptions(schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	schedule, err := s.scheduler.Add(schedule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, schedule)
+}
+
+// handleDeleteSchedule removes a schedule
+func (s *Server) handleDeleteSchedule(c *gin.Context) {
+	if err := s.scheduler.Remove(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+}
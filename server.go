@@ -2,13 +2,24 @@ package main
 
 import (
 	_ "embed"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/fffonion/rclone-precache/pkg/api"
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/fffonion/rclone-precache/pkg/sizer"
+	"github.com/fffonion/rclone-precache/pkg/tracing"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -29,85 +40,466 @@ var reactDomJS string
 var babelJS string
 
 type Server struct {
-	cacheManager *CacheManager
-	sizer        *DirectorySizer
-	mountPath    string
-	cachePath    string
-	threadCount  int
-}
-
-func NewServer(mountPath string, cachePath string, chunkSize int, threadCount int) *Server {
-	return &Server{
-		cacheManager: NewCacheManager(chunkSize),
-		sizer:        NewDirectorySizer(),
-		mountPath:    mountPath,
-		cachePath:    cachePath,
+	cacheManager  *cache.Manager
+	sizer         *sizer.Sizer
+	mounts        []*Mount
+	stateDir      string // Holds saved jobs, history and schedules
+	timeSeries    *cache.TimeSeries
+	threadCount   int
+	scheduler     *Scheduler
+	pins          *PinStore       // Paths never evicted from the cache
+	rc            *RcClient       // Remote control of the rclone serving the mount
+	vfsCache      *cache.VFSCache // rclone's cache metadata, nil to inspect cache files
+	mount         *RcloneMount    // Managed rclone process, nil for an existing mount
+	pathMap       PathMap         // Maps paths reported by integrations to API paths
+	plex          *PlexClient
+	radarr        *ArrClient // Looks up movie folders for Overseerr
+	sonarr        *ArrClient // Looks up series folders for Overseerr
+	hookToken     string     // Authenticates the hooks in tokenHooks
+	basicAuth     *BasicAuth // Users allowed in, nil to allow everyone
+	apiKeys       *APIKeyStore
+	auditLog      *AuditLog       // Who changed what through the API
+	tracer        *tracing.Tracer // Exports spans, nil without tracing
+	debug         bool            // Serves pprof profiles
+	oidc          *OIDCProvider   // Logs users in with OpenID Connect, nil if disabled
+	acl           PathACL         // Path prefixes users are limited to
+	prefetchCount int             // Episodes queued after a precached or played one
+	apiSpec       []byte          // OpenAPI document of the registered routes
+}
+
+func NewServer(mounts []*Mount, chunkSize int, threadCount int, maxJobs int, retry cache.RetryPolicy, extensions cache.ExtensionRules, stateDir string) *Server {
+	if stateDir == "" {
+		stateDir = filepath.Join(mounts[0].CachePath, ".rclone-precache")
+	}
+
+	s := &Server{
+		cacheManager: cache.NewManager(chunkSize, maxJobs, retry, extensions, cache.NewJobStore(stateDir), cache.NewHistoryStore(stateDir)),
+		mounts:       mounts,
+		stateDir:     stateDir,
 		threadCount:  threadCount,
+		auditLog:     NewAuditLog(stateDir),
+	}
+	// One size cache, so invalidating it through the API covers estimates too
+	s.sizer = s.cacheManager.Sizer()
+	s.timeSeries = cache.NewTimeSeries(stateDir)
+	s.cacheManager.RecordTimeSeries(s.timeSeries, s.totalCachedSize)
+
+	s.apiKeys = NewAPIKeyStore(stateDir)
+	if err := s.apiKeys.Load(); err != nil {
+		slog.Error("Error loading API keys", "error", err)
+	}
+
+	s.pins = NewPinStore(stateDir)
+	if err := s.pins.Load(); err != nil {
+		slog.Error("Error loading pins", "error", err)
 	}
+
+	s.scheduler = NewScheduler(stateDir, s.runSchedule)
+	if err := s.scheduler.Load(); err != nil {
+		slog.Error("Error loading schedules", "error", err)
+	}
+	s.scheduler.Start()
+	return s
 }
 
-// handleBrowse handles directory browsing requests
+// handleBrowse handles directory browsing requests. With refresh=true the
+// directory listing is first refreshed through rclone's remote control.
+// Entries are sorted by sort=name|size|mtime|cached_percent in
+// order=asc|desc, newest first by default, and can be narrowed by name with
+// q=substring and glob=pattern.
 func (s *Server) handleBrowse(c *gin.Context) {
 	reqPath := c.Param("path")
-	fullPath := filepath.Join(s.mountPath, reqPath)
-	cacheBase := filepath.Join(s.cachePath, reqPath)
+	user := c.GetString("user")
+	if !s.acl.visible(user, cleanPath(reqPath)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Access to %s is not allowed", cleanPath(reqPath))})
+		return
+	}
+	if s.named() && cleanPath(reqPath) == "/" {
+		s.browseMounts(c)
+		return
+	}
+	fullPath, cacheBase, err := s.paths(reqPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
+		return
+	}
+	sortBy := c.DefaultQuery("sort", "mtime")
+	if _, ok := fileInfoKeys[sortBy]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid sort %q, want name, size, mtime or cached_percent", sortBy)})
+		return
+	}
+	// Names read best A to Z, everything else largest or newest first
+	order := "desc"
+	if sortBy == "name" {
+		order = "asc"
+	}
+	if order = c.DefaultQuery("order", order); order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid order %q, want asc or desc", order)})
+		return
+	}
+	match, err := nameFilter(c.Query("q"), c.Query("glob"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	entries, err := os.ReadDir(fullPath)
+	if v := c.Query("refresh"); v != "" {
+		refresh, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid refresh %q", v)})
+			return
+		}
+		if refresh && s.rc == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rclone remote control is not configured"})
+			return
+		}
+		if refresh {
+			// A stale listing is still better than none
+			if err := s.rc.refresh(reqPath); err != nil {
+				slog.Error("Error refreshing directory", "path", reqPath, "error", err)
+			}
+		}
+	}
+
+	fileInfos, err := s.listDirectory(user, reqPath, fullPath, cacheBase, match)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
 		return
 	}
+	sortFileInfos(fileInfos, sortBy, order == "desc")
+	c.JSON(http.StatusOK, fileInfos)
+}
+
+// fileInfoKeys are the browse sort keys. Directories, which are listed
+// without a size, sort as empty.
+var fileInfoKeys = map[string]func(api.FileInfo) float64{
+	"name": nil,
+	"size": func(f api.FileInfo) float64 {
+		if f.Size == nil {
+			return 0
+		}
+		return float64(*f.Size)
+	},
+	"mtime": func(f api.FileInfo) float64 { return f.CreatedTime },
+	"cached_percent": func(f api.FileInfo) float64 {
+		if f.Size == nil || *f.Size == 0 {
+			return 0
+		}
+		return float64(f.CachedSize) / float64(*f.Size)
+	},
+}
+
+// sortFileInfos sorts a listing by one of fileInfoKeys, ties A to Z by name
+func sortFileInfos(fileInfos []api.FileInfo, sortBy string, desc bool) {
+	key := fileInfoKeys[sortBy]
+	sort.SliceStable(fileInfos, func(i, j int) bool {
+		a, b := fileInfos[i], fileInfos[j]
+		if key == nil {
+			return (a.Name < b.Name) != desc
+		}
+		if ka, kb := key(a), key(b); ka != kb {
+			return (ka < kb) != desc
+		}
+		return a.Name < b.Name
+	})
+}
+
+// nameFilter returns a case-insensitive match for entry names containing q
+// and matching glob, or nil if both are empty
+func nameFilter(q, glob string) (func(name string) bool, error) {
+	if q == "" && glob == "" {
+		return nil, nil
+	}
+	q, glob = strings.ToLower(q), strings.ToLower(glob)
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob %q", glob)
+	}
+	return func(name string) bool {
+		name = strings.ToLower(name)
+		if !strings.Contains(name, q) {
+			return false
+		}
+		if glob == "" {
+			return true
+		}
+		ok, _ := path.Match(glob, name)
+		return ok
+	}, nil
+}
 
-	var fileInfos []FileInfo
+// fileInfo describes the source file or directory at reqPath with the
+// bytes cached at cachePath
+func (s *Server) fileInfo(reqPath, cachePath string, info os.FileInfo) api.FileInfo {
+	var size *int64
+	if !info.IsDir() {
+		fileSize := info.Size()
+		size = &fileSize
+	}
+	return api.FileInfo{
+		Name:        info.Name(),
+		Path:        reqPath,
+		IsDir:       info.IsDir(),
+		Size:        size,
+		CreatedTime: float64(info.ModTime().Unix()),
+		CachedSize:  s.cachedSize(cachePath, info.IsDir()),
+	}
+}
+
+// listDirectory lists the entries of the source directory fullPath that
+// user may see, newest first, with the bytes cached below cacheBase. A
+// non-nil match skips the entries whose names it rejects.
+func (s *Server) listDirectory(user, reqPath, fullPath, cacheBase string, match func(name string) bool) ([]api.FileInfo, error) {
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfos := []api.FileInfo{}
 	for _, entry := range entries {
+		if match != nil && !match(entry.Name()) {
+			continue
+		}
 		info, err := entry.Info()
 		if err != nil {
 			continue
 		}
 
-		cachePath := filepath.Join(cacheBase, entry.Name())
-		var size *int64
-		if !entry.IsDir() {
-			s := info.Size()
-			size = &s
+		if !s.acl.visible(user, cleanPath(filepath.Join(reqPath, entry.Name()))) {
+			continue
 		}
+		cachePath := filepath.Join(cacheBase, entry.Name())
+		fileInfos = append(fileInfos, s.fileInfo(filepath.Join(reqPath, entry.Name()), cachePath, info))
+	}
 
-		fileInfo := FileInfo{
-			Name:        entry.Name(),
-			Path:        filepath.Join(reqPath, entry.Name()),
-			IsDir:       entry.IsDir(),
-			Size:        size,
-			CreatedTime: float64(info.ModTime().Unix()),
-			CachedSize:  s.sizer.calculateSize(cachePath),
-		}
-		fileInfos = append(fileInfos, fileInfo)
+	sortFileInfos(fileInfos, "mtime", true)
+	return fileInfos, nil
+}
+
+// handlePrecache handles precaching requests. With dry_run=true it only
+// returns an estimate, and a JSON body makes it a batch request.
+func (s *Server) handlePrecache(c *gin.Context) {
+	if dryRun, _ := strconv.ParseBool(c.Query("dry_run")); dryRun {
+		s.handleEstimate(c)
+		return
+	}
+	if c.ContentType() == "application/json" {
+		s.handleBatchPrecache(c)
+		return
+	}
+
+	reqPath := cleanPath(c.Param("path"))
+	if !s.allowPath(c, reqPath) {
+		return
+	}
+	sourcePath, cachePath, err := s.paths(reqPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts, err := s.parseJobOptions(reqPath, c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	sort.Slice(fileInfos, func(i, j int) bool {
-		return fileInfos[i].CreatedTime > fileInfos[j].CreatedTime
+	job, err := s.cacheManager.StartJob(reqPath, sourcePath, cachePath, originOf(c), opts)
+	if errors.Is(err, cache.ErrJobExists) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Precache already in progress for %s", reqPath)})
+		return
+	}
+	if err != nil {
+		c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	s.prefetchNext(reqPath, originOf(c))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Started caching directory: %s", reqPath),
+		"job_id":  job.ID,
 	})
+}
 
-	c.JSON(http.StatusOK, fileInfos)
+// parseJobOptions reads precache options for reqPath from the query
+// string, falling back to the defaults of the mount serving it:
+// threads per file, the chunk size read at once, mode (full, headtail or
+// media), strategy (read or advise), head and tail sizes such as 64M, and
+// repeatable include and exclude globs, a regex on the relative path,
+// min_size and max_size bounds, newer_than as an age such as 7d or a
+// timestamp, the directory depth to descend, the job priority with
+// preempt to pause lower priority jobs, a bwlimit replacing the global
+// bandwidth limit, and files, the number of files cached at once
+func (s *Server) parseJobOptions(reqPath string, query url.Values) (cache.JobOptions, error) {
+	if m, _, err := s.locate(reqPath); err == nil && len(m.Defaults) > 0 {
+		merged := url.Values{}
+		for key, values := range m.Defaults {
+			merged[key] = values
+		}
+		for key, values := range query {
+			merged[key] = values
+		}
+		query = merged
+	}
+
+	opts := cache.JobOptions{
+		Threads:  s.threadCount,
+		Mode:     query.Get("mode"),
+		Strategy: query.Get("strategy"),
+		Include:  query["include"],
+		Exclude:  query["exclude"],
+		Regex:    query.Get("regex"),
+		Priority: query.Get("priority"),
+	}
+	var err error
+	if v := query.Get("threads"); v != "" {
+		if opts.Threads, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("invalid threads %q", v)
+		}
+	}
+	if v := query.Get("chunk"); v != "" {
+		if opts.Chunk, err = parseSize(v); err != nil {
+			return opts, err
+		}
+	}
+	if v := query.Get("head"); v != "" {
+		if opts.HeadBytes, err = parseSize(v); err != nil {
+			return opts, err
+		}
+	}
+	if v := query.Get("tail"); v != "" {
+		if opts.TailBytes, err = parseSize(v); err != nil {
+			return opts, err
+		}
+	}
+	if v := query.Get("min_size"); v != "" {
+		if opts.MinSize, err = parseSize(v); err != nil {
+			return opts, err
+		}
+	}
+	if v := query.Get("max_size"); v != "" {
+		if opts.MaxSize, err = parseSize(v); err != nil {
+			return opts, err
+		}
+	}
+	if v := query.Get("newer_than"); v != "" {
+		cutoff, err := parseCutoff(v, time.Now())
+		if err != nil {
+			return opts, err
+		}
+		opts.NewerThan = &cutoff
+	}
+	if v := query.Get("preempt"); v != "" {
+		if opts.Preempt, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("invalid preempt %q", v)
+		}
+	}
+	if v := query.Get("bwlimit"); v != "" {
+		limit := int64(0)
+		if v != "off" {
+			if limit, err = parseSize(v); err != nil {
+				return opts, err
+			}
+		}
+		opts.BwLimit = &limit
+	}
+	if v := query.Get("files"); v != "" {
+		if opts.Files, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("invalid files %q", v)
+		}
+	}
+	if v := query.Get("depth"); v != "" {
+		depth, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid depth %q", v)
+		}
+		opts.Depth = &depth
+	}
+	return opts, opts.Validate()
 }
 
-// handlePrecache handles precaching requests
-func (s *Server) handlePrecache(c *gin.Context) {
-	reqPath := c.Param("path")
-	sourcePath := filepath.Join(s.mountPath, reqPath)
-	cachePath := filepath.Join(s.cachePath, reqPath)
+// handleBatchPrecache starts jobs for several paths at once. If any path
+// fails, no job is started.
+func (s *Server) handleBatchPrecache(c *gin.Context) {
+	var req api.BatchPrecacheRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	setAuditTarget(c, auditPaths(req.Paths))
+	if len(req.Paths) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No paths given"})
+		return
+	}
 
-	if _, exists := s.cacheManager.GetProgress(sourcePath); exists {
-		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Precache already in progress for %s", reqPath)})
+	query, err := optionValues(req.Options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	specs := make([]cache.JobSpec, 0, len(req.Paths))
+	for _, p := range req.Paths {
+		reqPath := cleanPath(p)
+		if !s.allowPath(c, reqPath) {
+			return
+		}
+		sourcePath, cachePath, err := s.paths(reqPath)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", reqPath, err)})
+			return
+		}
+		opts, err := s.parseJobOptions(reqPath, query)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		specs = append(specs, cache.JobSpec{Path: reqPath, SourcePath: sourcePath, CachePath: cachePath, Options: opts})
+	}
 
-	_, err := s.cacheManager.StartProgress(sourcePath, cachePath, s.threadCount)
+	jobs, err := s.cacheManager.StartJobs(specs, originOf(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Started caching directory: %s", reqPath)})
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Started caching %d paths", len(jobs)),
+		"job_ids": ids,
+	})
+}
+
+// optionValues converts JSON batch options into query values so they are
+// parsed exactly like query parameters
+func optionValues(options map[string]interface{}) (url.Values, error) {
+	query := url.Values{}
+	for name, value := range options {
+		switch v := value.(type) {
+		case string:
+			query.Set(name, v)
+		case int:
+			query.Set(name, strconv.Itoa(v))
+		case float64:
+			query.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			query.Set(name, strconv.FormatBool(v))
+		case []interface{}:
+			for _, item := range v {
+				str, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("option %s must be a list of strings", name)
+				}
+				query.Add(name, str)
+			}
+		case nil:
+		default:
+			return nil, fmt.Errorf("unsupported value for option %s", name)
+		}
+	}
+	return query, nil
 }
 
 // handleCacheProgress handles progress monitoring requests
@@ -117,39 +509,353 @@ func (s *Server) handleCacheProgress(c *gin.Context) {
 		// Return global progress
 		progress := s.cacheManager.GetGlobalProgress()
 		// Add cache size to global progress
-		progress.CachedSize = s.sizer.calculateSize(s.cachePath)
+		progress.CachedSize = s.totalCachedSize()
 		c.JSON(http.StatusOK, progress)
 		return
 	}
 
-	time.Sleep(1 * time.Second)
-	sourcePath := filepath.Join(s.mountPath, reqPath)
-	progress, exists := s.cacheManager.GetProgress(sourcePath)
+	if !s.visiblePath(c, reqPath) {
+		return
+	}
+	job, exists := s.cacheManager.FindJob(cleanPath(reqPath))
 	if !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "No active cache operation found"})
 		return
 	}
-	c.JSON(http.StatusOK, progress)
+	c.JSON(http.StatusOK, job)
+}
+
+// handleEvents streams job state changes and progress as Server-Sent Events
+func (s *Server) handleEvents(c *gin.Context) {
+	events := s.cacheManager.Events().Subscribe()
+	defer s.cacheManager.Events().Unsubscribe(events)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	user := c.GetString("user")
+
+	// Send the current state first so clients don't wait for the next tick
+	c.SSEvent(cache.EventProgress, cache.ProgressEvent{
+		Global: s.cacheManager.GetGlobalProgress(),
+		Jobs:   s.visibleJobs(user, s.cacheManager.ListJobs()),
+	})
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if event, ok = s.visibleEvent(user, event); ok {
+				c.SSEvent(event.Type, event.Data)
+			}
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// handleListJobs lists all tracked jobs, or with mine=true or user only
+// those started by one user
+func (s *Server) handleListJobs(c *gin.Context) {
+	user, ok := s.jobUserFilter(c)
+	if !ok {
+		return
+	}
+	jobs := s.visibleJobs(c.GetString("user"), s.cacheManager.ListJobs())
+	if user != "" {
+		mine := make([]*cache.Job, 0, len(jobs))
+		for _, job := range jobs {
+			if job.User == user {
+				mine = append(mine, job)
+			}
+		}
+		jobs = mine
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// jobUserFilter returns the user whose jobs a listing is limited to:
+// the caller with mine=true, or the user query parameter
+func (s *Server) jobUserFilter(c *gin.Context) (string, bool) {
+	user := c.Query("user")
+	if v := c.Query("mine"); v != "" {
+		mine, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid mine %q", v)})
+			return "", false
+		}
+		if mine {
+			if user = c.GetString("user"); user == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "mine needs an authenticated user"})
+				return "", false
+			}
+		}
+	}
+	return user, true
+}
+
+// handleGetJob returns a single job with its progress
+func (s *Server) handleGetJob(c *gin.Context) {
+	job, exists := s.cacheManager.GetJob(c.Param("id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": cache.ErrJobNotFound.Error()})
+		return
+	}
+	if !s.visiblePath(c, job.Path) {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// handlePause handles requests to pause a running job
+func (s *Server) handlePause(c *gin.Context) {
+	id := c.Param("id")
+	if !s.allowJob(c, id) {
+		return
+	}
+	if err := s.cacheManager.PauseJob(id); err != nil {
+		c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Paused job: %s", id)})
+}
+
+// handleResume handles requests to resume a paused job
+func (s *Server) handleResume(c *gin.Context) {
+	id := c.Param("id")
+	if !s.allowJob(c, id) {
+		return
+	}
+	if err := s.cacheManager.ResumeJob(id); err != nil {
+		c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Resumed job: %s", id)})
+}
+
+// handleCancel handles requests to cancel a queued or running job
+func (s *Server) handleCancel(c *gin.Context) {
+	id := c.Param("id")
+	if !s.allowJob(c, id) {
+		return
+	}
+	err := s.cacheManager.CancelJob(id)
+	if errors.Is(err, cache.ErrJobFinished) {
+		if err = s.cacheManager.ClearJob(id); err == nil {
+			c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Cleared job: %s", id)})
+			return
+		}
+	}
+	if err != nil {
+		c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Cancelled job: %s", id)})
+}
+
+// handleClearJobs forgets the finished jobs the caller may act on and
+// their summaries
+func (s *Server) handleClearJobs(c *gin.Context) {
+	var match func(path string) bool
+	if user := c.GetString("user"); len(s.acl[user]) > 0 {
+		match = func(path string) bool { return s.acl.allows(user, path) }
+	}
+	cleared := s.cacheManager.ClearFinished(match)
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Cleared %d finished jobs", cleared)})
+}
+
+// handleHistory returns finished jobs, newest first. Supports limit/offset
+// pagination and since/until date filters (RFC 3339 or YYYY-MM-DD).
+func (s *Server) handleHistory(c *gin.Context) {
+	q, ok := s.historyFilter(c)
+	if !ok {
+		return
+	}
+	q.Limit = 50
+	var err error
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+	}
+
+	records, total, err := s.cacheManager.QueryHistory(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"total":   total,
+		"offset":  q.Offset,
+		"limit":   q.Limit,
+		"records": records,
+	})
+}
+
+// historyFilter reads the user and since/until filters of a history
+// request, replying with an error if one is invalid
+func (s *Server) historyFilter(c *gin.Context) (cache.HistoryQuery, bool) {
+	var q cache.HistoryQuery
+	var err error
+	var ok bool
+	if q.User, ok = s.jobUserFilter(c); !ok {
+		return q, false
+	}
+	if user := c.GetString("user"); len(s.acl[user]) > 0 {
+		q.Paths = func(path string) bool { return s.acl.visible(user, path) }
+	}
+	if v := c.Query("since"); v != "" {
+		if q.Since, err = parseDate(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since: " + err.Error()})
+			return q, false
+		}
+	}
+	if v := c.Query("until"); v != "" {
+		if q.Until, err = parseDate(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until: " + err.Error()})
+			return q, false
+		}
+	}
+	return q, true
+}
+
+// parseDate accepts an RFC 3339 timestamp or a plain YYYY-MM-DD date
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, time.Local)
+}
+
+// cleanPath normalizes a request path to a rooted, slash-separated form
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// rejectWrites refuses mutating requests on a read-only server. GET
+// requests of the admin routes still pass.
+func (s *Server) rejectWrites(c *gin.Context) {
+	if s.cacheManager.ReadOnly() && c.Request.Method != http.MethodGet {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": cache.ErrReadOnly.Error()})
+	}
+}
+
+// jobErrorStatus maps cache manager errors to HTTP status codes
+func jobErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, cache.ErrJobNotFound), errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, cache.ErrReadOnly), errors.Is(err, errPathNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, cache.ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, cache.ErrLowDiskSpace):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, cache.ErrJobExists):
+		return http.StatusBadRequest
+	case errors.Is(err, cache.ErrJobNotRunning), errors.Is(err, cache.ErrJobNotPaused), errors.Is(err, cache.ErrJobFinished),
+		errors.Is(err, cache.ErrJobUnfinished):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func (s *Server) SetupRouter() *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	router.Use(requestID, logRequests, gin.Recovery(), s.traceRequests)
 
 	// Configure CORS
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Api-Key", csrfHeader, requestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", requestIDHeader},
 		AllowCredentials: true,
 	}))
+	router.Use(s.requireAuth, s.csrfProtect)
 
-	// API routes
-	api := router.Group("/api")
+	if s.oidc != nil {
+		router.GET("/auth/login", s.oidc.handleLogin)
+		router.GET("/auth/callback", s.oidc.handleCallback)
+		router.GET("/auth/logout", s.oidc.handleLogout)
+	}
+
+	router.GET("/healthz", s.handleHealthz)
+	router.GET("/readyz", s.handleReadyz)
+
+	// API routes, grouped by the API key scope they need
+	api := router.Group("/api", s.audit)
+	read := api.Group("", requireScope(ScopeRead))
+	{
+		read.GET("/browse/*path", s.handleBrowse)
+		read.GET("/stat/*path", s.handleStat)
+		read.GET("/du/*path", s.handleDiskUsage)
+		read.GET("/estimate/*path", s.handleEstimate)
+		read.GET("/cache-progress/*path", s.handleCacheProgress)
+		read.GET("/chunks/*path", s.handleChunks)
+		read.GET("/events", s.handleEvents)
+		read.GET("/ws", s.handleWebSocket)
+		read.GET("/history", s.handleHistory)
+		read.GET("/history/export", s.handleHistoryExport)
+		read.GET("/stats/timeseries", s.handleTimeSeries)
+		read.GET("/stats/usage", s.handleUsage)
+		read.GET("/jobs", s.handleListJobs)
+		read.GET("/jobs/:id", s.handleGetJob)
+		read.GET("/health", s.handleHealth)
+		read.GET("/version", s.handleVersion)
+		read.GET("/quota", s.handleQuota)
+		read.GET("/pins", s.handleListPins)
+		read.GET("/schedules", s.handleListSchedules)
+		read.GET("/openapi.json", s.handleOpenAPI)
+		read.GET("/docs", s.handleDocs)
+	}
+	precache := api.Group("", requireScope(ScopePrecache), s.rejectWrites)
+	{
+		precache.POST("/precache", s.handleBatchPrecache)
+		precache.POST("/precache/*path", s.handlePrecache)
+		precache.DELETE("/jobs", s.handleClearJobs)
+		precache.DELETE("/jobs/:id", s.handleCancel)
+		precache.POST("/jobs/:id/pause", s.handlePause)
+		precache.POST("/jobs/:id/resume", s.handleResume)
+		precache.POST("/hooks/radarr", s.handleRadarrWebhook)
+		precache.POST("/hooks/overseerr", s.handleOverseerrWebhook)
+		precache.POST("/hooks/tautulli", s.handleTautulliWebhook)
+		precache.POST("/pin/*path", s.handlePin)
+		precache.POST("/unpin/*path", s.handleUnpin)
+	}
+	// Hooks whose senders can't log in authenticate with the hook token
+	hooks := api.Group("/hooks", s.requireHookToken, requireScope(ScopePrecache), s.rejectWrites)
 	{
-		api.GET("/browse/*path", s.handleBrowse)
-		api.POST("/precache/*path", s.handlePrecache)
-		api.GET("/cache-progress/*path", s.handleCacheProgress)
+		hooks.POST("/plex", s.handlePlexWebhook)
+		hooks.POST("/completed", s.handleCompletedHook)
+	}
+	admin := api.Group("", requireScope(ScopeAdmin), s.rejectWrites)
+	{
+		admin.DELETE("/cache/*path", s.handlePurgeCache)
+		admin.GET("/sizecache", s.handleSizeCacheStats)
+		admin.DELETE("/sizecache", s.handleFlushSizeCache)
+		admin.POST("/schedules", s.handleCreateSchedule)
+		admin.DELETE("/schedules/:id", s.handleDeleteSchedule)
+		admin.GET("/audit", s.handleAudit)
+		admin.GET("/keys", s.handleListAPIKeys)
+		admin.POST("/keys", s.handleCreateAPIKey)
+		admin.DELETE("/keys/:id", s.handleDeleteAPIKey)
+	}
+
+	if s.debug {
+		s.registerPprof(router)
 	}
 
 	// Serve JS
@@ -176,5 +882,6 @@ func (s *Server) SetupRouter() *gin.Engine {
 		c.String(http.StatusOK, indexHTML)
 	})
 
+	s.apiSpec = marshalOpenAPI(router.Routes())
 	return router
 }
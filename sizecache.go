@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleSizeCacheStats reports how many directory and file sizes are cached
+// and roughly how much memory they use
+func (s *Server) handleSizeCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.sizer.Stats())
+}
+
+// handleFlushSizeCache drops cached sizes, so stale sizes don't linger until
+// they expire. With path only the source and cache sizes of that path and
+// everything below it are dropped.
+func (s *Server) handleFlushSizeCache(c *gin.Context) {
+	reqPath := c.Query("path")
+	if reqPath == "" {
+		dropped := s.sizer.Flush()
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Dropped %d cached sizes", dropped)})
+		return
+	}
+
+	reqPath = cleanPath(reqPath)
+	setAuditTarget(c, reqPath)
+	sourcePath, cachePath, err := s.paths(reqPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
+		return
+	}
+	dropped := s.sizer.Invalidate(sourcePath) + s.sizer.Invalidate(cachePath)
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Dropped %d cached sizes below %s", dropped, reqPath)})
+}
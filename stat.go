@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/fffonion/rclone-precache/pkg/api"
+	"github.com/fffonion/rclone-precache/pkg/pathutil"
+	"github.com/gin-gonic/gin"
+)
+
+// handleStat describes a single file or directory, so scripts can check
+// one path without listing its parent
+func (s *Server) handleStat(c *gin.Context) {
+	reqPath := cleanPath(c.Param("path"))
+	if !s.acl.visible(c.GetString("user"), reqPath) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Access to %s is not allowed", reqPath)})
+		return
+	}
+	sourcePath, cachePath, err := s.paths(reqPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
+		return
+	}
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
+		return
+	}
+
+	pathInfo := api.PathInfo{FileInfo: s.fileInfo(reqPath, cachePath, info)}
+	if size := pathInfo.Size; size != nil {
+		percent := 100.0
+		if *size > 0 {
+			percent = min(100, float64(pathInfo.CachedSize)*100/float64(*size))
+		}
+		pathInfo.CachedPercent = &percent
+	}
+	// The job may cache a directory above the path
+	for _, job := range s.cacheManager.ListJobs() {
+		if pathutil.HasPrefix(reqPath, job.Path) && !job.Progress().IsComplete {
+			pathInfo.Caching, pathInfo.JobID = true, job.ID
+			break
+		}
+	}
+	c.JSON(http.StatusOK, pathInfo)
+}
+
+// handleDiskUsage sums the source and cached bytes below each entry of a
+// directory, largest first, to show which folders dominate the cache.
+// Source sizes come from the sizer, so they may be up to its maximum age
+// old.
+func (s *Server) handleDiskUsage(c *gin.Context) {
+	reqPath := cleanPath(c.Param("path"))
+	user := c.GetString("user")
+	if !s.acl.visible(user, reqPath) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Access to %s is not allowed", reqPath)})
+		return
+	}
+	sourcePath, cacheBase, err := s.paths(reqPath)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
+		return
+	}
+	entries, err := os.ReadDir(sourcePath)
+	if err != nil {
+		if info, statErr := os.Stat(sourcePath); statErr == nil && !info.IsDir() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Disk usage is only available for directories"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Path not found"})
+		return
+	}
+
+	usage := api.DiskUsage{Path: reqPath, Entries: []api.DiskUsageEntry{}}
+	for _, entry := range entries {
+		entryPath := path.Join(reqPath, entry.Name())
+		if !s.acl.visible(user, entryPath) {
+			continue
+		}
+		du := api.DiskUsageEntry{
+			Name:       entry.Name(),
+			Path:       entryPath,
+			IsDir:      entry.IsDir(),
+			Size:       s.sizer.Size(filepath.Join(sourcePath, entry.Name())),
+			CachedSize: s.cachedSize(filepath.Join(cacheBase, entry.Name()), entry.IsDir()),
+		}
+		usage.Size += du.Size
+		usage.CachedSize += du.CachedSize
+		usage.Entries = append(usage.Entries, du)
+	}
+	sort.SliceStable(usage.Entries, func(i, j int) bool {
+		return usage.Entries[i].Size > usage.Entries[j].Size
+	})
+	c.JSON(http.StatusOK, usage)
+}
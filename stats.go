@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/api"
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultTimeSeriesRange is the range of throughput graphs unless asked
+const defaultTimeSeriesRange = 24 * time.Hour
+
+// totalCachedSize returns the bytes cached for all mounts
+func (s *Server) totalCachedSize() int64 {
+	var total int64
+	for _, m := range s.mounts {
+		total += s.cachedSize(m.CachePath, true)
+	}
+	return total
+}
+
+// handleTimeSeries returns throughput and cache size samples of the last
+// range, e.g. ?range=24h&step=5m, for drawing speed graphs
+func (s *Server) handleTimeSeries(c *gin.Context) {
+	span := defaultTimeSeriesRange
+	if v := c.Query("range"); v != "" {
+		d, err := parseDuration(v)
+		if err != nil || d <= 0 || d > cache.MaxTimeSeriesRange {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid range, want a duration up to 7d"})
+			return
+		}
+		span = d
+	}
+	step := cache.TimeSeriesInterval
+	if v := c.Query("step"); v != "" {
+		d, err := parseDuration(v)
+		if err != nil || d < cache.TimeSeriesInterval || d%cache.TimeSeriesInterval != 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid step, want a whole number of minutes"})
+			return
+		}
+		step = d
+	}
+
+	since := time.Now().Add(-span).Truncate(cache.TimeSeriesInterval)
+	c.JSON(http.StatusOK, api.TimeSeries{
+		Since:   since,
+		Step:    step.Seconds(),
+		Samples: s.timeSeries.Query(since, step),
+	})
+}
+
+// Default spans of usage reports
+const (
+	defaultUsageDays  = 30
+	defaultUsageWeeks = 12
+)
+
+// usageMount names the mount of a job path in usage reports
+func (s *Server) usageMount(reqPath string) string {
+	m, _, err := s.locate(reqPath)
+	if err != nil {
+		return "unknown"
+	}
+	if m.Name == "" {
+		return "default"
+	}
+	return m.Name
+}
+
+// periodStart returns the start of the day or week, beginning Monday,
+// holding t
+func periodStart(t time.Time, week bool) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if week {
+		day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// handleUsage returns the bytes read per day or week and per mount, e.g.
+// ?period=week&since=90d, so users of metered remotes can watch their
+// consumption. Jobs count towards the period they finished in; unfinished
+// jobs count towards the current one.
+func (s *Server) handleUsage(c *gin.Context) {
+	period := c.DefaultQuery("period", "day")
+	if period != "day" && period != "week" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period, want day or week"})
+		return
+	}
+	week := period == "week"
+	now := time.Now()
+	since := now.AddDate(0, 0, -defaultUsageDays+1)
+	if week {
+		since = now.AddDate(0, 0, -7*(defaultUsageWeeks-1))
+	}
+	until := now
+	var err error
+	if v := c.Query("since"); v != "" {
+		if since, err = parseCutoff(v, now); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since: " + err.Error()})
+			return
+		}
+	}
+	if v := c.Query("until"); v != "" {
+		if until, err = parseCutoff(v, now); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until: " + err.Error()})
+			return
+		}
+	}
+	since = periodStart(since, week)
+	if !until.After(since) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "until must be after since"})
+		return
+	}
+
+	report := api.UsageReport{Period: period, Since: since, Until: until, Mounts: map[string]int64{}}
+	index := map[string]int{}
+	for start := since; start.Before(until); {
+		index[start.Format("2006-01-02")] = len(report.Periods)
+		report.Periods = append(report.Periods, api.UsagePeriod{Start: start.Format("2006-01-02"), Mounts: map[string]int64{}})
+		if week {
+			start = start.AddDate(0, 0, 7)
+		} else {
+			start = start.AddDate(0, 0, 1)
+		}
+	}
+	add := func(at time.Time, reqPath string, bytes int64) {
+		i, ok := index[periodStart(at.In(now.Location()), week).Format("2006-01-02")]
+		if !ok {
+			return
+		}
+		mount := s.usageMount(reqPath)
+		report.Periods[i].Bytes += bytes
+		report.Periods[i].Jobs++
+		report.Periods[i].Mounts[mount] += bytes
+		report.Mounts[mount] += bytes
+		report.TotalBytes += bytes
+	}
+
+	records, _, err := s.cacheManager.QueryHistory(cache.HistoryQuery{Since: since, Until: until})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, record := range records {
+		add(record.FinishedAt, record.Path, record.TotalBytes)
+	}
+	if !until.Before(now) {
+		for _, job := range s.cacheManager.ListJobs() {
+			if progress := job.Progress(); !progress.IsComplete && progress.TotalBytesRead > 0 {
+				add(now, job.Path, progress.TotalBytesRead)
+			}
+		}
+	}
+	c.JSON(http.StatusOK, report)
+}
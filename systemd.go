@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemdListenFDStart is the first file descriptor systemd passes
+const systemdListenFDStart = 3
+
+// sdNotify sends a state such as READY=1 to systemd when it started the
+// process as a Type=notify service. It does nothing otherwise.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// systemdListener returns the socket passed by systemd socket activation,
+// or nil if the process was not socket activated. It must run before child
+// processes are started.
+func systemdListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	if fds > 1 {
+		return nil, fmt.Errorf("systemd passed %d sockets, want one", fds)
+	}
+	// Child processes such as rclone must not take the socket too.
+	// FileListener keeps a close-on-exec duplicate, and fd 3 itself is
+	// closed below.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(systemdListenFDStart, "systemd-socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// startWatchdog pings the systemd watchdog at half its interval while
+// healthy returns nil, so systemd restarts a hung server. It does nothing
+// unless the unit sets WatchdogSec.
+func startWatchdog(healthy func() error) {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	go func() {
+		for range time.Tick(interval) {
+			if err := healthy(); err != nil {
+				slog.Error("Skipping watchdog ping", "error", err)
+				continue
+			}
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				slog.Warn("Error pinging systemd watchdog", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/gin-gonic/gin"
+)
+
+// TautulliWebhook is the JSON data of a Tautulli webhook notification
+// agent. Configure the playback start and resume data as
+//
+//	{"action": "{action}", "file": "{file}"}
+type TautulliWebhook struct {
+	Action string `json:"action"`
+	File   string `json:"file"`
+}
+
+// handleTautulliWebhook fully precaches the file that started playing at
+// high priority, pausing lower priority jobs so it stays ahead of playback
+func (s *Server) handleTautulliWebhook(c *gin.Context) {
+	var hook TautulliWebhook
+	if err := c.ShouldBindJSON(&hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch hook.Action {
+	case "play", "resume":
+	case "test":
+		c.JSON(http.StatusOK, gin.H{"message": "Test received"})
+		return
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Ignored %s action", hook.Action)})
+		return
+	}
+	if hook.File == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file"})
+		return
+	}
+
+	reqPath, ok := s.externalPath(hook.File)
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("No path mapping for %s", hook.File)})
+		return
+	}
+
+	opts := s.defaultOptions(reqPath)
+	opts.Priority = cache.PriorityHigh
+	opts.Preempt = true
+	job, err := s.queuePath(reqPath, originOf(c), opts)
+	if err != nil {
+		c.JSON(jobErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	s.prefetchNext(reqPath, originOf(c))
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Caching %s", reqPath),
+		"job_id":  job.ID,
+	})
+}
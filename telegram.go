@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultTelegramAPI = "https://api.telegram.org"
+	// telegramPollTimeout is how long getUpdates waits for new messages
+	telegramPollTimeout = 50 * time.Second
+	// telegramStatusJobs limits the jobs listed by /status
+	telegramStatusJobs = 10
+)
+
+// telegramConfig are the settings of a telegram notifications entry
+type telegramConfig struct {
+	Token    string  `yaml:"token"`
+	ChatIDs  []int64 `yaml:"chat-ids"` // Chats notified and allowed to send commands
+	Commands bool    `yaml:"commands"`
+	APIURL   string  `yaml:"api-url"`
+}
+
+// telegramUpdate is the part of a Telegram update used for commands
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		From struct {
+			Username string `json:"username"`
+		} `json:"from"`
+	} `json:"message"`
+}
+
+// TelegramBot sends job events to Telegram chats and, if enabled, takes
+// /precache and /status commands from them
+type TelegramBot struct {
+	server  *Server
+	apiURL  string // Bot API URL including the token
+	chatIDs []int64
+	client  *http.Client
+}
+
+func newTelegramNotifier(s *Server, entry *yaml.Node) (cache.Notifier, error) {
+	var config telegramConfig
+	if err := entry.Decode(&config); err != nil {
+		return nil, err
+	}
+	if config.Token == "" {
+		return nil, fmt.Errorf("token is required")
+	}
+	if len(config.ChatIDs) == 0 {
+		return nil, fmt.Errorf("chat-ids is required")
+	}
+	if config.APIURL == "" {
+		config.APIURL = defaultTelegramAPI
+	}
+	b := &TelegramBot{
+		server:  s,
+		apiURL:  strings.TrimSuffix(config.APIURL, "/") + "/bot" + config.Token,
+		chatIDs: config.ChatIDs,
+		client:  &http.Client{Timeout: telegramPollTimeout + 10*time.Second},
+	}
+	if config.Commands {
+		go b.poll()
+	}
+	return b, nil
+}
+
+// call invokes a Bot API method and decodes its result into out, unless
+// it is nil
+func (b *TelegramBot) call(method string, params, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Post(b.apiURL+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// Keep the token in the URL out of logs
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("%s returned %s", method, resp.Status)
+	}
+	if !reply.OK {
+		return fmt.Errorf("%s: %s", method, reply.Description)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, out)
+}
+
+// send posts a message to one chat
+func (b *TelegramBot) send(chatID int64, text string) {
+	params := map[string]interface{}{"chat_id": chatID, "text": text}
+	if err := b.call("sendMessage", params, nil); err != nil {
+		slog.Warn("Error sending Telegram message", "chat", chatID, "error", err)
+	}
+}
+
+// broadcast posts a message to every configured chat
+func (b *TelegramBot) broadcast(text string) {
+	for _, chatID := range b.chatIDs {
+		b.send(chatID, text)
+	}
+}
+
+func (b *TelegramBot) JobQueued(job cache.JobSummary) {
+	b.broadcast("⏳ Queued " + job.Path)
+}
+
+func (b *TelegramBot) JobStarted(job cache.JobSummary) {
+	b.broadcast(fmt.Sprintf("▶️ Caching %s (%s)", job.Path, formatSize(job.TotalSize)))
+}
+
+func (b *TelegramBot) JobCompleted(job cache.JobSummary) {
+	b.broadcast(fmt.Sprintf("✅ Cached %s (%s in %s)", job.Path, formatSize(job.BytesRead), formatETA(&job.Duration)))
+}
+
+func (b *TelegramBot) JobFailed(job cache.JobSummary) {
+	text := fmt.Sprintf("❌ Failed %s (%s of %s)", job.Path, formatSize(job.BytesRead), formatSize(job.TotalSize))
+	if job.State == cache.StateCancelled {
+		text = fmt.Sprintf("🚫 Cancelled %s (%s of %s)", job.Path, formatSize(job.BytesRead), formatSize(job.TotalSize))
+	}
+	if job.ErrorCount > 0 {
+		text += fmt.Sprintf("\n%d errors", job.ErrorCount)
+		if len(job.Errors) > 0 {
+			text += ", first: " + job.Errors[0].Error
+		}
+	}
+	b.broadcast(text)
+}
+
+// allowed reports whether a chat may send commands
+func (b *TelegramBot) allowed(chatID int64) bool {
+	for _, id := range b.chatIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// poll receives messages with long polling and answers commands
+func (b *TelegramBot) poll() {
+	slog.Info("Taking Telegram commands", "chats", b.chatIDs)
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		params := map[string]interface{}{
+			"offset":          offset,
+			"timeout":         int(telegramPollTimeout.Seconds()),
+			"allowed_updates": []string{"message"},
+		}
+		if err := b.call("getUpdates", params, &updates); err != nil {
+			slog.Warn("Error polling Telegram updates", "error", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			if update.Message == nil || !strings.HasPrefix(update.Message.Text, "/") {
+				continue
+			}
+			chatID := update.Message.Chat.ID
+			if !b.allowed(chatID) {
+				slog.Warn("Ignored Telegram command from a chat not in chat-ids", "chat", chatID, "user", update.Message.From.Username)
+				continue
+			}
+			b.send(chatID, b.command(chatID, update.Message.From.Username, update.Message.Text))
+		}
+	}
+}
+
+// command runs a command and returns the reply
+func (b *TelegramBot) command(chatID int64, username, text string) string {
+	name, arg, _ := strings.Cut(strings.TrimSpace(text), " ")
+	name, _, _ = strings.Cut(name, "@") // Commands in groups may name the bot
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "/precache":
+		if arg == "" {
+			return "Usage: /precache <path>"
+		}
+		user := username
+		if user == "" {
+			user = strconv.FormatInt(chatID, 10)
+		}
+		return b.precache(arg, "telegram:"+user)
+	case "/status":
+		return b.status()
+	default:
+		return "Commands:\n/precache <path> - cache a file or directory\n/status - show running jobs"
+	}
+}
+
+// precache starts a job for a mount-relative or path-mapped path
+func (b *TelegramBot) precache(p, user string) string {
+	s := b.server
+	reqPath, ok := s.externalPath(p)
+	if !ok {
+		reqPath = cleanPath(p)
+	}
+	if !s.exists(reqPath) {
+		return "Path not found: " + reqPath
+	}
+	job, err := s.queuePath(reqPath, cache.JobOrigin{User: user}, s.defaultOptions(reqPath))
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	return fmt.Sprintf("Caching %s\nJob %s", reqPath, job.ID)
+}
+
+// status summarizes global progress and the unfinished jobs
+func (b *TelegramBot) status() string {
+	g := b.server.cacheManager.GetGlobalProgress()
+	var text strings.Builder
+	fmt.Fprintf(&text, "Active %d, queued %d, paused %d\n%s/s, %.1f%% done, %s left, ETA %s",
+		g.ActiveJobs, g.QueuedJobs, g.PausedJobs, formatSize(int64(g.TotalSpeed)),
+		g.OverallPercent, formatSize(g.BytesRemaining), formatETA(g.ETASeconds))
+	listed := 0
+	for _, job := range b.server.cacheManager.ListJobs() {
+		progress := job.Progress()
+		if progress.IsComplete {
+			continue
+		}
+		if listed == telegramStatusJobs {
+			text.WriteString("\n…")
+			break
+		}
+		percent := 0.0
+		if progress.TotalSize > 0 {
+			percent = float64(progress.CachedSize) / float64(progress.TotalSize) * 100
+		}
+		fmt.Fprintf(&text, "\n%s %.1f%% %s", progress.State, percent, job.Path)
+		listed++
+	}
+	return text.String()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSOptions select how the server serves HTTPS. Without a certificate or
+// ACME domains it serves plain HTTP.
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// ACMEDomains get certificates from Let's Encrypt through TLS-ALPN
+	// challenges, so the listen address must be reachable on port 443
+	ACMEDomains []string
+	ACMECache   string // Directory keeping issued certificates
+	ACMEEmail   string // Contact for expiry notices, optional
+	// ClientCAFile makes connections without a client certificate signed
+	// by one of its CAs fail the handshake
+	ClientCAFile string
+}
+
+// enabled reports whether the server serves HTTPS
+func (o TLSOptions) enabled() bool {
+	return o.CertFile != "" || len(o.ACMEDomains) > 0
+}
+
+// loadClientCAs reads the PEM encoded CA certificates client certificates
+// must be signed by
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// listen serves handler on ln, or on addr if ln is nil, until the server
+// fails or ctx is done. It then stops accepting connections and waits up to
+// shutdownTimeout for requests in flight before closing the rest.
+func listen(ctx context.Context, ln net.Listener, addr string, handler http.Handler, opts TLSOptions, shutdownTimeout time.Duration) error {
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", addr); err != nil {
+			return err
+		}
+	}
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: handler}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serve(srv, ln, opts)
+	}()
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Warn("Error notifying systemd", "error", err)
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("Shutting down HTTP server", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Event streams and WebSockets stay open until closed
+		srv.Close()
+	}
+	return nil
+}
+
+// serve runs srv on ln with the TLS options until it fails or is shut down
+func serve(srv *http.Server, ln net.Listener, opts TLSOptions) error {
+	addr := srv.Addr
+	if !opts.enabled() {
+		slog.Info("Serving HTTP", "addr", addr)
+		return ignoreClosed(srv.Serve(ln))
+	}
+
+	config, err := tlsConfig(opts)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	srv.TLSConfig = config
+	if len(opts.ACMEDomains) > 0 {
+		slog.Info("Serving HTTPS", "addr", addr, "acme_domains", opts.ACMEDomains)
+	} else {
+		slog.Info("Serving HTTPS", "addr", addr)
+	}
+	return ignoreClosed(srv.ServeTLS(ln, "", ""))
+}
+
+// tlsConfig returns the server TLS settings of opts, which must be enabled
+func tlsConfig(opts TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(opts.ACMEDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(opts.ACMEDomains...),
+			Cache:      autocert.DirCache(opts.ACMECache),
+			Email:      opts.ACMEEmail,
+		}
+		config = manager.TLSConfig()
+	} else {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if opts.ClientCAFile != "" {
+		pool, err := loadClientCAs(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		challenge := config.Clone()
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		// ACME validation servers have no client certificate
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+				return challenge, nil
+			}
+			return nil, nil
+		}
+	}
+	return config, nil
+}
+
+// ignoreClosed drops the error a server returns once it is shut down
+func ignoreClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/fffonion/rclone-precache/pkg/api"
+	"github.com/fffonion/rclone-precache/pkg/cache"
+)
+
+// topSnapshot is one poll of the server
+type topSnapshot struct {
+	global cache.GlobalProgress
+	jobs   []*cache.Job
+	err    error
+}
+
+// topActionDone reports the result of a pause, resume or cancel
+type topActionDone struct {
+	message string
+	err     error
+}
+
+// topTick asks for the next poll
+type topTick struct{}
+
+// topModel is the state of the terminal monitor
+type topModel struct {
+	api      *api.Client
+	interval time.Duration
+	snapshot topSnapshot
+	selected int
+	confirm  *cache.Job // Job to cancel once confirmed
+	status   string     // Result of the last action
+	width    int
+	height   int
+}
+
+var (
+	topTitle    = lipgloss.NewStyle().Bold(true)
+	topHeader   = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	topSelected = lipgloss.NewStyle().Reverse(true)
+	topDim      = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	topError    = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	topStates   = map[cache.JobState]lipgloss.Style{
+		cache.StateRunning:   lipgloss.NewStyle().Foreground(lipgloss.Color("10")),
+		cache.StateQueued:    lipgloss.NewStyle().Foreground(lipgloss.Color("11")),
+		cache.StatePaused:    lipgloss.NewStyle().Foreground(lipgloss.Color("13")),
+		cache.StateComplete:  lipgloss.NewStyle().Foreground(lipgloss.Color("12")),
+		cache.StateCancelled: lipgloss.NewStyle().Foreground(lipgloss.Color("8")),
+	}
+)
+
+// poll fetches global progress and the job list
+func (m topModel) poll() tea.Msg {
+	var snap topSnapshot
+	ctx := context.Background()
+	if snap.global, snap.err = m.api.GlobalProgress(ctx); snap.err != nil {
+		return snap
+	}
+	snap.jobs, snap.err = m.api.Jobs(ctx)
+	return snap
+}
+
+// act runs a job action in the background
+func (m topModel) act(action func(context.Context, string) error, id, message string) tea.Cmd {
+	return func() tea.Msg {
+		return topActionDone{message: message, err: action(context.Background(), id)}
+	}
+}
+
+func (m topModel) Init() tea.Cmd {
+	return m.poll
+}
+
+func (m topModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case topSnapshot:
+		m.snapshot = msg
+		m.selected = min(m.selected, max(len(msg.jobs)-1, 0))
+		return m, tea.Tick(m.interval, func(time.Time) tea.Msg { return topTick{} })
+	case topTick:
+		return m, m.poll
+	case topActionDone:
+		m.status = msg.message
+		if msg.err != nil {
+			m.status = topError.Render(msg.err.Error())
+		}
+		return m, m.poll
+	case tea.KeyMsg:
+		return m.key(msg)
+	}
+	return m, nil
+}
+
+// key handles a key press
+func (m topModel) key(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.confirm != nil {
+		job := m.confirm
+		m.confirm = nil
+		if msg.String() == "y" {
+			return m, m.act(m.api.CancelJob, job.ID, "Cancelled "+job.Path)
+		}
+		m.status = "Cancel aborted"
+		return m, nil
+	}
+
+	var job *cache.Job
+	if m.selected < len(m.snapshot.jobs) {
+		job = m.snapshot.jobs[m.selected]
+	}
+	switch msg.String() {
+	case "q", "ctrl+c", "esc":
+		return m, tea.Quit
+	case "up", "k":
+		m.selected = max(m.selected-1, 0)
+	case "down", "j":
+		m.selected = min(m.selected+1, max(len(m.snapshot.jobs)-1, 0))
+	case "p":
+		if job != nil {
+			return m, m.act(m.api.PauseJob, job.ID, "Paused "+job.Path)
+		}
+	case "r":
+		if job != nil {
+			return m, m.act(m.api.ResumeJob, job.ID, "Resumed "+job.Path)
+		}
+	case "c", "d", "delete":
+		if job != nil {
+			m.confirm = job
+			m.status = fmt.Sprintf("Cancel %s? y/n", job.Path)
+		}
+	}
+	return m, nil
+}
+
+func (m topModel) View() string {
+	var b strings.Builder
+	g := m.snapshot.global
+	b.WriteString(topTitle.Render("rclone-precache top") + "  " + topDim.Render(m.api.BaseURL) + "\n")
+	fmt.Fprintf(&b, "Speed %s/s  Active %d  Queued %d  Paused %d  Done %.1f%%  Remaining %s  ETA %s  Cached %s\n\n",
+		formatSize(int64(g.TotalSpeed)), g.ActiveJobs, g.QueuedJobs, g.PausedJobs, g.OverallPercent,
+		formatSize(g.BytesRemaining), formatETA(g.ETASeconds), formatSize(g.CachedSize))
+	if m.snapshot.err != nil {
+		b.WriteString(topError.Render("Error: "+m.snapshot.err.Error()) + "\n\n")
+	}
+
+	b.WriteString(topHeader.Render(fmt.Sprintf("%-10s %6s %10s %19s %9s  %s", "STATE", "DONE", "SPEED", "READ/TOTAL", "ETA", "PATH")) + "\n")
+	rows := len(m.snapshot.jobs)
+	if m.height > 0 {
+		rows = min(rows, max(m.height-8, 1))
+	}
+	first := max(0, m.selected-rows+1)
+	for i := first; i < first+rows && i < len(m.snapshot.jobs); i++ {
+		job := m.snapshot.jobs[i]
+		percent := 0.0
+		if job.TotalSize > 0 {
+			percent = float64(job.CachedSize) / float64(job.TotalSize) * 100
+		}
+		state := fmt.Sprintf("%-10s", job.State)
+		if style, ok := topStates[job.State]; ok && i != m.selected {
+			state = style.Render(state)
+		}
+		line := fmt.Sprintf("%s %5.1f%% %8s/s %19s %9s  %s", state, percent,
+			formatSize(int64(job.CurrentSpeed)),
+			formatSize(job.TotalBytesRead)+"/"+formatSize(job.TotalSize),
+			formatETA(job.ETASeconds), job.Path)
+		if job.ErrorCount > 0 {
+			line += topError.Render(fmt.Sprintf(" (%d errors)", job.ErrorCount))
+		}
+		if m.width > 0 {
+			line = ansi.Truncate(line, m.width, "…")
+		}
+		if i == m.selected {
+			line = topSelected.Render(line)
+		}
+		b.WriteString(line + "\n")
+	}
+	if len(m.snapshot.jobs) == 0 {
+		b.WriteString(topDim.Render("No jobs") + "\n")
+	}
+
+	b.WriteString("\n")
+	if m.status != "" {
+		b.WriteString(m.status + "\n")
+	}
+	b.WriteString(topDim.Render("↑/↓ select  p pause  r resume  c cancel  q quit"))
+	return b.String()
+}
+
+// formatETA formats seconds left as 1h02m or 45s, or - when unknown
+func formatETA(seconds *float64) string {
+	if seconds == nil {
+		return "-"
+	}
+	d := time.Duration(*seconds) * time.Second
+	switch {
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+}
+
+// runTop runs the terminal monitor, the top subcommand, against a server
+// reached through its REST API
+func runTop(args []string) error {
+	flags := flag.NewFlagSet("top", flag.ExitOnError)
+	serverURL := flags.String("url", "http://localhost:8000", "URL of the rclone-precache server")
+	apiKey := flags.String("api-key", os.Getenv(envPrefix+"API_KEY"), "API key, precache scope to pause and cancel jobs (default $"+envPrefix+"API_KEY)")
+	user := flags.String("user", "", "User for HTTP Basic auth")
+	password := flags.String("password", os.Getenv(envPrefix+"PASSWORD"), "Password for HTTP Basic auth (default $"+envPrefix+"PASSWORD)")
+	interval := flags.Duration("interval", time.Second, "How often to refresh")
+	insecure := flags.Bool("insecure", false, "Accept any TLS certificate, e.g. a self-signed one")
+	flags.Parse(args)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if *insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := api.NewClient(*serverURL, *apiKey)
+	client.User = *user
+	client.Password = *password
+	client.HTTPClient = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	model := topModel{api: client, interval: *interval}
+	_, err := tea.NewProgram(model, tea.WithAltScreen()).Run()
+	return err
+}
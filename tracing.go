@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/fffonion/rclone-precache/pkg/tracing"
+	"github.com/gin-gonic/gin"
+)
+
+// UseTracer exports spans of API requests and jobs through t
+func (s *Server) UseTracer(t *tracing.Tracer) {
+	s.tracer = t
+	s.cacheManager.SetTracer(t)
+}
+
+// traceRequests wraps each request in a server span, continuing the trace
+// of an incoming traceparent header
+func (s *Server) traceRequests(c *gin.Context) {
+	if s.tracer == nil {
+		return
+	}
+	parent, _ := tracing.ParseTraceparent(c.GetHeader("traceparent"))
+	name := c.Request.Method + " " + c.FullPath()
+	if c.FullPath() == "" {
+		name = c.Request.Method
+	}
+	ctx, span := s.tracer.StartRoot(c.Request.Context(), name, tracing.SpanKindServer, parent)
+	c.Request = c.Request.WithContext(ctx)
+	span.SetAttr("http.request.method", c.Request.Method)
+	span.SetAttr("http.route", c.FullPath())
+	span.SetAttr("url.path", c.Request.URL.Path)
+	span.SetAttr("client.address", c.ClientIP())
+	span.SetAttr("request_id", c.GetString("request_id"))
+	c.Header("traceparent", tracing.FromContext(ctx).Traceparent())
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttr("http.response.status_code", status)
+	if user := c.GetString("user"); user != "" {
+		span.SetAttr("enduser.id", user)
+	}
+	if status >= 500 {
+		span.SetError(fmt.Errorf("%s", http.StatusText(status)))
+	}
+	span.End()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseSize parses a byte count with an optional binary suffix as used by
+// rclone, e.g. "512", "64K", "16M", "1.5G" or "2T". A trailing "B" or "iB"
+// is accepted.
+func parseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "IB"), "B")
+
+	multiplier := int64(1)
+	if n := len(str); n > 0 {
+		switch str[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			str = str[:n-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// parseDuration parses a Go duration with an additional "d" unit for days,
+// e.g. "90m", "12h" or "7d"
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		value, err := strconv.ParseFloat(days, 64)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(value * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// parseCutoff turns an age such as "7d" into the time that long before now,
+// or parses an absolute RFC 3339 timestamp or YYYY-MM-DD date
+func parseCutoff(s string, now time.Time) (time.Time, error) {
+	if d, err := parseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := parseDate(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid duration or timestamp %q", s)
+	}
+	return t, nil
+}
+
+// parseList splits a comma separated flag value, dropping empty items
+func parseList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// formatSize formats a byte count with the binary suffixes parseSize
+// accepts, e.g. "512", "64K" or "1.5G"
+func formatSize(n int64) string {
+	const suffixes = "KMGT"
+	value := float64(n)
+	suffix := ""
+	for i := 0; value >= 1024 && i < len(suffixes); i++ {
+		value /= 1024
+		suffix = suffixes[i : i+1]
+	}
+	if suffix == "" {
+		return strconv.FormatInt(n, 10)
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + suffix
+}
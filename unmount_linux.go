@@ -0,0 +1,20 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// forceUnmount lazily detaches a FUSE mount, even one whose process died
+func forceUnmount(path string) error {
+	var output []byte
+	var err error
+	for _, args := range [][]string{{"fusermount3", "-uz", path}, {"fusermount", "-uz", path}, {"umount", "-l", path}} {
+		if output, err = exec.Command(args[0], args[1:]...).CombinedOutput(); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v: %s", err, output)
+}
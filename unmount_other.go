@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// forceUnmount detaches a mount, even one whose process died
+func forceUnmount(path string) error {
+	if output, err := exec.Command("umount", "-f", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}
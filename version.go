@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build information, set by goreleaser or with
+// go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// buildInfo returns the linked in build information, falling back to the
+// VCS details the go command records for builds without ldflags
+func buildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		Date:      date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "":
+				info.Date = setting.Value
+			case setting.Key == "vcs.modified" && setting.Value == "true" && info.Version == "dev":
+				info.Version = "dev-dirty"
+			}
+		}
+	}
+	return info
+}
+
+// String formats the build information for -version
+func (b BuildInfo) String() string {
+	s := "rclone-precache " + b.Version
+	if b.Commit != "" {
+		s += " (" + b.Commit
+		if b.Date != "" {
+			s += ", " + b.Date
+		}
+		s += ")"
+	}
+	return s + fmt.Sprintf(" %s %s", b.GoVersion, b.Platform)
+}
+
+// handleVersion reports what is running
+func (s *Server) handleVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, buildInfo())
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+)
+
+// UseVFSCache makes cache sizes, skipped ranges and removals follow rclone's
+// own metadata instead of inspecting the cache files
+func (s *Server) UseVFSCache(v *cache.VFSCache) {
+	s.vfsCache = v
+	s.cacheManager.UseVFSCache(v)
+}
+
+// cachedSize returns the cached bytes of the file or directory at path
+func (s *Server) cachedSize(path string, isDir bool) int64 {
+	switch {
+	case s.vfsCache != nil:
+		return s.vfsCache.CachedSize(path)
+	case isDir:
+		return s.sizer.Calculate(path)
+	default:
+		return cache.CachedBytes(path, s.sizer)
+	}
+}
+
+// removeCacheFile deletes one file from the cache directory
+func (s *Server) removeCacheFile(path string) error {
+	if s.vfsCache != nil {
+		return s.vfsCache.Remove(path)
+	}
+	return os.Remove(path)
+}
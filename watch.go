@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher queues precache jobs for files and directories appearing under
+// the watched directories of the mount. A new entry is queued once nothing
+// inside it changed for the settle delay, so copies finish first.
+type Watcher struct {
+	server  *Server
+	watcher *fsnotify.Watcher
+	settle  time.Duration
+	pending map[string]*time.Timer // Timers keyed by mount-relative path
+	mu      sync.Mutex
+}
+
+// StartWatcher watches the mount-relative dirs and their subdirectories
+func (s *Server) StartWatcher(dirs []string, settle time.Duration) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w := &Watcher{
+		server:  s,
+		watcher: fsw,
+		settle:  settle,
+		pending: make(map[string]*time.Timer),
+	}
+	for _, dir := range dirs {
+		sourcePath, _, err := s.paths(dir)
+		if err != nil {
+			fsw.Close()
+			return err
+		}
+		if err := w.addTree(sourcePath); err != nil {
+			fsw.Close()
+			return err
+		}
+		slog.Info("Watching for new files", "path", cleanPath(dir))
+	}
+	go w.loop()
+	return nil
+}
+
+// addTree watches dir and every directory below it
+func (w *Watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return w.watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// loop handles filesystem events until the watcher is closed
+func (w *Watcher) loop() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Watch error", "error", err)
+		}
+	}
+}
+
+// handle tracks a created or written path, adding watches for new directories
+func (w *Watcher) handle(event fsnotify.Event) {
+	reqPath, ok := w.server.requestPath(event.Name)
+	if !ok {
+		return
+	}
+
+	switch {
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		w.forget(reqPath)
+	case event.Has(fsnotify.Create), event.Has(fsnotify.Write):
+		if event.Has(fsnotify.Create) {
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+				if err := w.addTree(event.Name); err != nil {
+					slog.Error("Error watching", "dir", event.Name, "error", err)
+				}
+			}
+		}
+		w.touch(reqPath)
+	}
+}
+
+// touch restarts the settle timer of the new entry containing reqPath,
+// starting one for reqPath itself if it is not inside a pending entry
+func (w *Watcher) touch(reqPath string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for p := reqPath; ; p = parentPath(p) {
+		if timer, exists := w.pending[p]; exists {
+			timer.Reset(w.settle)
+			return
+		}
+		if p == "/" {
+			break
+		}
+	}
+	w.pending[reqPath] = time.AfterFunc(w.settle, func() {
+		w.queue(reqPath)
+	})
+}
+
+// forget drops a pending entry that was removed or renamed away
+func (w *Watcher) forget(reqPath string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if timer, exists := w.pending[reqPath]; exists {
+		timer.Stop()
+		delete(w.pending, reqPath)
+	}
+}
+
+// queue starts a precache job for a settled entry
+func (w *Watcher) queue(reqPath string) {
+	w.mu.Lock()
+	delete(w.pending, reqPath)
+	w.mu.Unlock()
+
+	s := w.server
+	sourcePath, _, err := s.paths(reqPath)
+	if err != nil {
+		return
+	}
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() && !s.cacheManager.Extensions().Allowed(sourcePath) {
+		return
+	}
+
+	job, err := s.queuePath(reqPath, cache.JobOrigin{}, s.defaultOptions(reqPath))
+	if err != nil {
+		slog.Error("Error queueing new path", "path", reqPath, "error", err)
+		return
+	}
+	job.Log().Info("Queued job for new path")
+}
+
+// parentPath returns the parent of a rooted, slash-separated path
+func parentPath(p string) string {
+	if i := strings.LastIndex(p, "/"); i > 0 {
+		return p[:i]
+	}
+	return "/"
+}
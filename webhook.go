@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of webhook notifications
+const (
+	defaultWebhookRetries    = 3
+	defaultWebhookRetryDelay = 5 * time.Second
+	defaultWebhookTimeout    = 10 * time.Second
+)
+
+// webhookConfig are the settings of a webhook notifications entry
+type webhookConfig struct {
+	URL        string            `yaml:"url"`
+	Secret     string            `yaml:"secret"` // Signs payloads with HMAC-SHA256 if set
+	Headers    map[string]string `yaml:"headers"`
+	Retries    *int              `yaml:"retries"`
+	RetryDelay string            `yaml:"retry-delay"` // Doubled for each further retry
+	Timeout    string            `yaml:"timeout"`
+}
+
+// webhookPayload is the JSON posted for a job event
+type webhookPayload struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	cache.JobSummary
+}
+
+// webhookNotifier posts job events as JSON to a URL, e.g. of a home
+// automation or chat system. Failed deliveries are retried on network
+// errors, 429 and 5xx replies.
+type webhookNotifier struct {
+	url        string
+	secret     []byte
+	headers    map[string]string
+	retries    int
+	retryDelay time.Duration
+	client     *http.Client
+}
+
+func newWebhookNotifier(_ *Server, entry *yaml.Node) (cache.Notifier, error) {
+	var config webhookConfig
+	if err := entry.Decode(&config); err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(config.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", config.URL)
+	}
+	w := &webhookNotifier{
+		url:        config.URL,
+		secret:     []byte(config.Secret),
+		headers:    config.Headers,
+		retries:    defaultWebhookRetries,
+		retryDelay: defaultWebhookRetryDelay,
+		client:     &http.Client{Timeout: defaultWebhookTimeout},
+	}
+	if config.Retries != nil {
+		if *config.Retries < 0 {
+			return nil, fmt.Errorf("retries must not be negative")
+		}
+		w.retries = *config.Retries
+	}
+	var err error
+	if config.RetryDelay != "" {
+		if w.retryDelay, err = parseDuration(config.RetryDelay); err != nil {
+			return nil, fmt.Errorf("retry-delay: %w", err)
+		}
+	}
+	if config.Timeout != "" {
+		if w.client.Timeout, err = parseDuration(config.Timeout); err != nil {
+			return nil, fmt.Errorf("timeout: %w", err)
+		}
+	}
+	return w, nil
+}
+
+func (w *webhookNotifier) JobQueued(job cache.JobSummary) {
+	w.post(notifyQueued, job)
+}
+
+func (w *webhookNotifier) JobStarted(job cache.JobSummary) {
+	w.post(notifyStarted, job)
+}
+
+func (w *webhookNotifier) JobCompleted(job cache.JobSummary) {
+	w.post(notifyCompleted, job)
+}
+
+func (w *webhookNotifier) JobFailed(job cache.JobSummary) {
+	w.post(notifyFailed, job)
+}
+
+// post delivers an event, retrying with exponential backoff
+func (w *webhookNotifier) post(event string, job cache.JobSummary) {
+	body, err := json.Marshal(webhookPayload{Event: event, Time: time.Now(), JobSummary: job})
+	if err != nil {
+		slog.Error("Error encoding webhook payload", "job", job.ID, "error", err)
+		return
+	}
+	delay := w.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := w.send(event, job.ID, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= w.retries {
+			slog.Warn("Error posting webhook", "url", w.url, "event", event, "job", job.ID, "attempts", attempt+1, "error", err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// send posts body once and reports whether a failure is worth retrying
+func (w *webhookNotifier) send(event, jobID string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rclone-precache/"+version)
+	req.Header.Set("X-Precache-Event", event)
+	req.Header.Set("X-Precache-Job", jobID)
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		req.Header.Set("X-Precache-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
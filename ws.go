@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/fffonion/rclone-precache/pkg/cache"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPongTimeout  = 60 * time.Second
+	wsPingInterval = 30 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	// Same policy as the CORS configuration: any origin may connect
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleWebSocket streams the same events as /api/events over a WebSocket.
+// Each message is a JSON object with "type" and "data" fields.
+func (s *Server) handleWebSocket(c *gin.Context) {
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		slog.Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	events := s.cacheManager.Events().Subscribe()
+	defer s.cacheManager.Events().Unsubscribe(events)
+
+	// Clients only send control frames; reading detects disconnects
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	user := c.GetString("user")
+	send := func(event cache.Event) bool {
+		event, visible := s.visibleEvent(user, event)
+		if !visible {
+			return true
+		}
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(event) == nil
+	}
+
+	if !send(cache.Event{
+		Type: cache.EventProgress,
+		Data: cache.ProgressEvent{
+			Global: s.cacheManager.GetGlobalProgress(),
+			Jobs:   s.cacheManager.ListJobs(),
+		},
+	}) {
+		return
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok || !send(event) {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}